@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// permLevel is a permission level for using the bot
+type permLevel int
+
+const (
+	permNone permLevel = iota
+	// trusted users can have links titled in direct messages, without rate limits
+	permTrusted
+	// channel managers can use the channel operator commands in any channel,
+	// manage per-channel domain allowlists, and invite the bot to channels
+	permChannelManager
+	// admins can use all commands
+	permAdmin
+)
+
+var permLevelNames = map[string]permLevel{
+	"trusted":         permTrusted,
+	"channel-manager": permChannelManager,
+	"admin":           permAdmin,
+}
+
+// accessList maps casefolded account names to their permission levels
+type accessList map[string]permLevel
+
+// parseACL parses a comma-delimited list of account:level pairs,
+// e.g. "alice:admin,bob:trusted"
+func parseACL(acl string) (result accessList, err error) {
+	result = make(accessList)
+	for _, entry := range strings.Split(acl, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		colon := strings.LastIndexByte(entry, ':')
+		if colon == -1 {
+			return nil, fmt.Errorf("invalid ACL entry `%s`: must be account:level", entry)
+		}
+		account, levelName := entry[:colon], entry[colon+1:]
+		level, ok := permLevelNames[strings.ToLower(strings.TrimSpace(levelName))]
+		if !ok || account == "" {
+			return nil, fmt.Errorf("invalid ACL entry `%s`: must be account:level, where level is one of admin, channel-manager, trusted", entry)
+		}
+		result[strings.ToLower(strings.TrimSpace(account))] = level
+	}
+	return
+}
+
+// level returns the permission level of a message's sender, based on
+// their account (from account-tag); relayed messages have none
+func (acl accessList) level(e ircmsg.Message) permLevel {
+	present, account := e.GetTag("account")
+	if !present || account == "" || account == "*" || relayedSender(e) {
+		return permNone
+	}
+	return acl[strings.ToLower(account)]
+}
+
+// ownerCommandLevels are the permission levels required by the owner commands
+var ownerCommandLevels = map[string]permLevel{
+	"abuse":    permAdmin,
+	"quit":     permAdmin,
+	"ignore":   permAdmin,
+	"unignore": permAdmin,
+	"block":    permAdmin,
+	"unblock":  permAdmin,
+	"allow":    permChannelManager,
+	"disallow": permChannelManager,
+	"join":     permChannelManager,
+	"part":     permChannelManager,
+	"stats":    permChannelManager,
+	"nick":     permAdmin,
+	"set":      permAdmin,
+	"loglevel": permAdmin,
+	"domain":   permChannelManager,
+	"export":   permAdmin,
+}
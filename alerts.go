@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlertConsecutive  = 10
+	defaultAlertDomainErrors = 20
+	domainErrorWindow        = time.Hour
+	maxTrackedDomains        = 1024
+	// don't repeat an alert about the same handler or domain more often than this:
+	alertInterval = time.Hour
+)
+
+// failureTracker watches for handlers that fail repeatedly (e.g., because
+// an API key expired) and for domains that keep erroring, so that the admins
+// can be told about it directly instead of having to read the logs
+type failureTracker struct {
+	sync.Mutex
+	consecutiveLimit int // consecutive failures of an API handler; 0 to disable
+	domainLimit      int // errors from one domain within domainErrorWindow; 0 to disable
+
+	consecutive  map[string]int
+	domainErrors map[string][]time.Time
+	lastAlert    map[string]time.Time
+}
+
+func newFailureTracker(consecutiveLimit, domainLimit int) *failureTracker {
+	return &failureTracker{
+		consecutiveLimit: consecutiveLimit,
+		domainLimit:      domainLimit,
+		consecutive:      make(map[string]int),
+		domainErrors:     make(map[string][]time.Time),
+		lastAlert:        make(map[string]time.Time),
+	}
+}
+
+// shouldAlertNoMutex rate-limits alerts about `key`; the caller must hold the mutex
+func (f *failureTracker) shouldAlertNoMutex(key string, now time.Time) bool {
+	if last, ok := f.lastAlert[key]; ok && now.Sub(last) < alertInterval {
+		return false
+	}
+	f.lastAlert[key] = now
+	return true
+}
+
+// record tracks the result of titling a link (failure is empty on success),
+// returning an alert to send, if any. handler is empty for links that were
+// fetched directly, which are tracked by domain instead.
+func (f *failureTracker) record(handler, host, failure string, now time.Time) (alert string) {
+	// a page without a title is not the bot's problem
+	if failure == errNoTitle {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if handler != "" {
+		if failure == "" {
+			delete(f.consecutive, handler)
+			return
+		}
+		f.consecutive[handler]++
+		count := f.consecutive[handler]
+		if f.consecutiveLimit > 0 && count >= f.consecutiveLimit && f.shouldAlertNoMutex("handler:"+handler, now) {
+			return fmt.Sprintf("the %s handler has failed %d times in a row (most recently: %s errors)", handler, count, failure)
+		}
+		return
+	}
+
+	if failure == "" || host == "" || f.domainLimit <= 0 {
+		return
+	}
+	if len(f.domainErrors) >= maxTrackedDomains {
+		for domain, times := range f.domainErrors {
+			if now.Sub(times[len(times)-1]) >= domainErrorWindow {
+				delete(f.domainErrors, domain)
+			}
+		}
+	}
+	errors := f.domainErrors[host]
+	// discard errors that have left the window
+	i := sort.Search(len(errors), func(i int) bool { return now.Sub(errors[i]) < domainErrorWindow })
+	errors = append(errors[i:], now)
+	f.domainErrors[host] = errors
+	if len(errors) >= f.domainLimit && f.shouldAlertNoMutex("domain:"+host, now) {
+		return fmt.Sprintf("%s has returned %d errors in the last hour (most recently: %s errors)", displayHost(host), len(errors), failure)
+	}
+	return
+}
+
+// recordFetchResult records the result of titling a link, notifying the
+// alert targets if something keeps failing
+func (irc *Bot) recordFetchResult(handler, host, failure string) {
+	alert := irc.failures.record(handler, host, failure, time.Now())
+	if alert == "" {
+		return
+	}
+	irc.logInfo("sending alert", "alert", alert)
+	for _, target := range irc.alertTargets {
+		irc.queueMessage(nil, "PRIVMSG", target, alert, false)
+	}
+}
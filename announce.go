@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maximum size of an /announce request body
+const announceMaxBody = 16 * 1024
+
+// announceRequest is the body of POST /announce: either a message to relay
+// to the channel, or a URL to title there
+type announceRequest struct {
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+}
+
+func announceError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// handleAnnounce lets other systems (e.g., CI or monitoring) post to
+// a channel we're in, authenticated with `Authorization: Bearer <token>`
+func (irc *Bot) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(irc.announceToken)) != 1 {
+		announceError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	var req announceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, announceMaxBody)).Decode(&req); err != nil {
+		announceError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if (req.Message == "") == (req.URL == "") {
+		announceError(w, http.StatusBadRequest, "exactly one of message and url is required")
+		return
+	}
+	if !strings.HasPrefix(req.Channel, "#") || !irc.channels.isMember(req.Channel, irc.CurrentNick()) {
+		announceError(w, http.StatusBadRequest, "not in that channel")
+		return
+	}
+	if !irc.Connected() || !irc.isActive() {
+		announceError(w, http.StatusServiceUnavailable, "not connected")
+		return
+	}
+
+	if req.URL != "" {
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			announceError(w, http.StatusBadRequest, "invalid url")
+			return
+		}
+		irc.logInfo("titling announced link", "channel", req.Channel, "url", req.URL)
+		go irc.title(titleRequest{target: req.Channel, requested: true}, req.URL)
+	} else {
+		if !irc.floodControl.allow(req.Channel, false) {
+			announceError(w, http.StatusTooManyRequests, "flood control")
+			return
+		}
+		// one line only; the server would treat the rest as commands
+		message, _, _ := strings.Cut(strings.ReplaceAll(req.Message, "\r", "\n"), "\n")
+		irc.logInfo("relaying announcement", "channel", req.Channel)
+		irc.sendReplyNotice(req.Channel, "", message)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	outputHistorySize = 64
+	outputHistoryTTL  = 10 * time.Minute
+	// shorter outputs are too likely to appear in messages by coincidence
+	outputHistoryMinLen = 16
+)
+
+// isBotOutput detects messages from clients that identify themselves as
+// bots, e.g. another titlebot replying to a link; titling these risks a loop
+// where two bots title each other's output indefinitely. (Replies aren't
+// enough to go on, since people's clients send them too; bots that don't
+// identify themselves are caught by the output history instead.)
+func isBotOutput(e ircmsg.Message) bool {
+	present, _ := e.GetTag(botTagName)
+	return present
+}
+
+// ignoreSender checks whether a message comes from ourselves, from a client
+// that identifies itself as a bot, or from a sender on one of the ignore lists
+func (irc *Bot) ignoreSender(e ircmsg.Message) bool {
+	nick := e.Nick()
+	if nick == irc.CurrentNick() {
+		return true
+	}
+	if present, _ := e.GetTag(botTagName); present {
+		return true
+	}
+	if irc.ignoreNicksRe != nil && irc.ignoreNicksRe.MatchString(nick) {
+		return true
+	}
+	return irc.state.isIgnored(e.Source, nick, messageAccount(e))
+}
+
+type outputEntry struct {
+	text   string
+	sentAt time.Time
+}
+
+// outputHistory remembers the bot's recent output, so that it can recognize
+// the output if it's sent back to it (by a relay, or by another titlebot
+// quoting it) and avoid titling it again
+type outputHistory struct {
+	sync.Mutex
+	entries [outputHistorySize]outputEntry
+	next    int
+}
+
+func (o *outputHistory) add(text string) {
+	if len(text) < outputHistoryMinLen {
+		return
+	}
+	o.Lock()
+	defer o.Unlock()
+	o.entries[o.next] = outputEntry{text: text, sentAt: time.Now()}
+	o.next = (o.next + 1) % outputHistorySize
+}
+
+// isRelayed checks whether a message contains some of our own recent output
+func (o *outputHistory) isRelayed(message string) bool {
+	o.Lock()
+	defer o.Unlock()
+	cutoff := time.Now().Add(-outputHistoryTTL)
+	for _, entry := range o.entries {
+		if entry.text != "" && entry.sentAt.After(cutoff) && strings.Contains(message, entry.text) {
+			return true
+		}
+	}
+	return false
+}
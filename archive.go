@@ -0,0 +1,166 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	waybackSaveURL = "https://web.archive.org/save/"
+	waybackWebURL  = "https://web.archive.org/web/"
+	// the Wayback Machine allows a handful of captures per minute
+	defaultArchiveInterval = 10 * time.Second
+	// don't resubmit a URL that was archived this recently
+	archiveReuseAge  = 24 * time.Hour
+	archiveQueueSize = 256
+	archiveTimeout   = 2 * time.Minute
+	// how much of a response we read (and discard)
+	archiveResponseLimit = 64 * 1024
+)
+
+type archiveJob struct {
+	url       string
+	historyID int64 // 0 if the link isn't in the history
+}
+
+// archiver submits titled links to the Wayback Machine or an ArchiveBox
+// instance, one at a time and no more often than `interval`
+type archiver struct {
+	archiveBox string // ArchiveBox base URL; if empty, use the Wayback Machine
+	token      string // Wayback "accesskey:secret", or an ArchiveBox API key
+	interval   time.Duration
+	queue      chan archiveJob
+}
+
+func newArchiver(service, token string, interval time.Duration) *archiver {
+	a := &archiver{token: token, interval: interval, queue: make(chan archiveJob, archiveQueueSize)}
+	if !strings.EqualFold(service, "wayback") {
+		a.archiveBox = strings.TrimSuffix(service, "/")
+	}
+	return a
+}
+
+// isWebURL checks whether a link is http or https, as opposed to the other
+// schemes we handle (which web archives can't archive)
+func isWebURL(url string) bool {
+	lower := strings.ToLower(url)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// archiveLink queues a link titled in a channel for archiving; like the
+// history, it excludes direct messages and spoilers
+func (irc *Bot) archiveLink(req titleRequest, url string, historyID int64) {
+	if irc.archiver == nil || req.spoiler || !isWebURL(url) || !req.inChannel() {
+		return
+	}
+	select {
+	case irc.archiver.queue <- archiveJob{url: url, historyID: historyID}:
+	default:
+		irc.logError("archive queue is full, dropping link", "url", url)
+	}
+}
+
+func (irc *Bot) archiveLoop() {
+	for job := range irc.archiver.queue {
+		if irc.ctx.Err() != nil {
+			return
+		}
+		if irc.history != nil {
+			// the link was probably just posted again; reuse the capture
+			archiveURL, err := irc.history.lastArchiveURL(job.url, time.Now().Add(-archiveReuseAge))
+			if err == nil && archiveURL != "" {
+				irc.recordArchiveURL(job, archiveURL)
+				continue
+			}
+		}
+		archiveURL, err := irc.submitArchive(job.url)
+		if !irc.checkErr(err, "couldn't archive link", "url", job.url) {
+			irc.logDebug("http", "archived link", "url", job.url, "archive", archiveURL)
+			irc.recordArchiveURL(job, archiveURL)
+		}
+		select {
+		case <-time.After(irc.archiver.interval):
+		case <-irc.ctx.Done():
+			return
+		}
+	}
+}
+
+func (irc *Bot) recordArchiveURL(job archiveJob, archiveURL string) {
+	if irc.history != nil && job.historyID != 0 {
+		irc.reportErr(irc.history.setArchiveURL(job.historyID, archiveURL), "couldn't record archive URL", "url", job.url)
+	}
+}
+
+// submitArchive asks the archive to capture url, returning the URL of the capture
+func (irc *Bot) submitArchive(url string) (archiveURL string, err error) {
+	if irc.archiver.archiveBox != "" {
+		return irc.submitArchiveBox(url)
+	}
+	return irc.submitWayback(url)
+}
+
+// submitWayback uses the Wayback Machine's Save Page Now
+func (irc *Bot) submitWayback(url string) (archiveURL string, err error) {
+	req, err := http.NewRequestWithContext(irc.ctx, "GET", waybackSaveURL+url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	if irc.archiver.token != "" {
+		req.Header.Set("Authorization", "LOW "+irc.archiver.token)
+	}
+	client := http.Client{Timeout: archiveTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, archiveResponseLimit))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Save Page Now returned status %d", resp.StatusCode)
+	}
+	// the capture's path, e.g. /web/20210102030405/https://example.com/
+	if location := resp.Header.Get("Content-Location"); strings.HasPrefix(location, "/web/") {
+		return "https://web.archive.org" + location, nil
+	}
+	if strings.HasPrefix(resp.Request.URL.Path, "/web/") {
+		return resp.Request.URL.String(), nil
+	}
+	// this redirects to the latest capture
+	return waybackWebURL + url, nil
+}
+
+// submitArchiveBox uses ArchiveBox's REST API
+func (irc *Bot) submitArchiveBox(url string) (archiveURL string, err error) {
+	body, _ := json.Marshal(map[string]interface{}{"urls": []string{url}, "depth": 0})
+	req, err := http.NewRequestWithContext(irc.ctx, "POST", irc.archiver.archiveBox+"/api/v1/cli/add", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	if irc.archiver.token != "" {
+		req.Header.Set("X-ArchiveBox-API-Key", irc.archiver.token)
+	}
+	client := http.Client{Timeout: archiveTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, archiveResponseLimit))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("ArchiveBox returned status %d", resp.StatusCode)
+	}
+	// ArchiveBox serves the latest snapshot of a URL here
+	return irc.archiver.archiveBox + "/archive/" + url, nil
+}
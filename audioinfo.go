@@ -0,0 +1,338 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+// audioInfo is what we can learn about an audio file from its tags and headers
+type audioInfo struct {
+	artist, title string
+	duration      time.Duration // 0 if we couldn't work it out
+}
+
+func (info audioInfo) String() string {
+	var details []string
+	switch {
+	case info.artist != "" && info.title != "":
+		details = append(details, info.artist+" - "+info.title)
+	case info.title != "":
+		details = append(details, info.title)
+	}
+	if info.duration > 0 {
+		details = append(details, formatClock(info.duration))
+	}
+	return strings.Join(details, ", ")
+}
+
+// formatClock formats a duration like a media player, e.g. 3:45 or 1:02:03
+func formatClock(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// durationAt returns how long it takes to play amount (samples, bytes, etc.)
+// at rate per second, dividing first so that large files can't overflow
+func durationAt(amount, rate int64) time.Duration {
+	if amount <= 0 || rate <= 0 || amount/rate > int64(math.MaxInt64/time.Second) {
+		return 0
+	}
+	return time.Duration(amount/rate)*time.Second + time.Duration(amount%rate*int64(time.Second)/rate)
+}
+
+// describeAudio reads the tags (and, when it can, the duration) from the
+// start of an MP3, FLAC, or Ogg (Vorbis or Opus) file; size is the size
+// of the whole file, if known, for estimating the duration
+func describeAudio(head []byte, size int64) (info audioInfo, ok bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		info, ok = flacInfo(head)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		info, ok = oggInfo(head, size)
+	default:
+		info, ok = mp3Info(head, size)
+	}
+	info.artist = ircutils.SanitizeText(info.artist, titleCharLimit)
+	info.title = ircutils.SanitizeText(info.title, titleCharLimit)
+	return info, ok && (info.title != "" || info.duration > 0)
+}
+
+// mp3Info reads an ID3v2 tag, then the first MPEG audio frame
+func mp3Info(head []byte, size int64) (info audioInfo, ok bool) {
+	audioStart := 0
+	if len(head) >= 10 && string(head[0:3]) == "ID3" {
+		tagSize := syncsafe(head[6:10])
+		audioStart = 10 + tagSize
+		end := min(audioStart, len(head))
+		info = id3Frames(head[10:end], head[3])
+		if head[5]&0x10 != 0 {
+			audioStart += 10 // footer
+		}
+	}
+	if audioStart < len(head) {
+		if duration := mpegDuration(head[audioStart:], size-int64(audioStart)); duration > 0 && info.duration == 0 {
+			info.duration = duration
+		}
+	}
+	return info, true
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// id3Frames reads the title, artist, and length frames of an ID3v2 tag
+func id3Frames(tag []byte, version byte) (info audioInfo) {
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		default:
+			frameSize = syncsafe(tag[4:8])
+		}
+		if frameSize <= 0 || headerLen+frameSize > len(tag) {
+			break
+		}
+		frame := tag[headerLen : headerLen+frameSize]
+		switch id {
+		case "TIT2", "TT2":
+			info.title = id3Text(frame)
+		case "TPE1", "TP1":
+			info.artist = id3Text(frame)
+		case "TLEN", "TLE":
+			if ms, err := strconv.ParseInt(id3Text(frame), 10, 64); err == nil {
+				info.duration = durationAt(ms, 1000)
+			}
+		}
+		tag = tag[headerLen+frameSize:]
+	}
+	return
+}
+
+// id3Text decodes a text frame: an encoding byte, then the text
+func id3Text(frame []byte) string {
+	if len(frame) < 2 {
+		return ""
+	}
+	text := frame[1:]
+	switch frame[0] {
+	case 0: // ISO-8859-1
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		return strings.TrimRight(string(runes), "\x00")
+	case 1, 2: // UTF-16 with a BOM, or UTF-16BE
+		order := binary.ByteOrder(binary.BigEndian)
+		if len(text) >= 2 && text[0] == 0xff && text[1] == 0xfe {
+			order, text = binary.LittleEndian, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xfe && text[1] == 0xff {
+			text = text[2:]
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			units[i] = order.Uint16(text[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	default: // UTF-8
+		return strings.TrimRight(string(text), "\x00")
+	}
+}
+
+var (
+	mpeg1Layer3Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Layer3Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mpegSampleRates     = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG 1
+		2: {22050, 24000, 16000}, // MPEG 2
+		0: {11025, 12000, 8000},  // MPEG 2.5
+	}
+)
+
+// mpegDuration works out the duration of MP3 audio from its first frame: from
+// the frame count in a Xing/Info header if there is one (as there is in VBR
+// files), otherwise from the bitrate and the size of the audio
+func mpegDuration(audio []byte, size int64) time.Duration {
+	i := 0
+	for ; i+4 <= len(audio); i++ {
+		if audio[i] == 0xff && audio[i+1]&0xe0 == 0xe0 {
+			break
+		}
+	}
+	if i+4 > len(audio) {
+		return 0
+	}
+	frame := audio[i:]
+	version := (frame[1] >> 3) & 0x03
+	layer := (frame[1] >> 1) & 0x03
+	rates, ok := mpegSampleRates[version]
+	rateIndex := (frame[2] >> 2) & 0x03
+	if !ok || layer != 1 || rateIndex == 3 { // only layer III
+		return 0
+	}
+	sampleRate := rates[rateIndex]
+	bitrate := mpeg2Layer3Bitrates[frame[2]>>4]
+	samplesPerFrame, sideInfo := 576, 17
+	mono := frame[3]>>6 == 3
+	if version == 3 {
+		bitrate = mpeg1Layer3Bitrates[frame[2]>>4]
+		samplesPerFrame, sideInfo = 1152, 32
+		if mono {
+			sideInfo = 17
+		}
+	} else if mono {
+		sideInfo = 9
+	}
+
+	xing := 4 + sideInfo
+	if xing+12 <= len(frame) {
+		if tag := string(frame[xing : xing+4]); tag == "Xing" || tag == "Info" {
+			flags := binary.BigEndian.Uint32(frame[xing+4 : xing+8])
+			if flags&0x01 != 0 {
+				frames := binary.BigEndian.Uint32(frame[xing+8 : xing+12])
+				return durationAt(int64(frames)*int64(samplesPerFrame), int64(sampleRate))
+			}
+		}
+	}
+	if bitrate == 0 || size <= 0 {
+		return 0
+	}
+	// (the bitrate is in kbit/s, so in bytes, it's 125 times that)
+	return durationAt(size-int64(i), int64(bitrate)*125)
+}
+
+// flacInfo reads the STREAMINFO and VORBIS_COMMENT metadata blocks
+func flacInfo(head []byte) (info audioInfo, ok bool) {
+	blocks := head[4:]
+	for len(blocks) >= 4 {
+		blockType := blocks[0] & 0x7f
+		last := blocks[0]&0x80 != 0
+		length := int(blocks[1])<<16 | int(blocks[2])<<8 | int(blocks[3])
+		if 4+length > len(blocks) {
+			break
+		}
+		block := blocks[4 : 4+length]
+		switch blockType {
+		case 0: // STREAMINFO
+			if len(block) >= 18 {
+				sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				info.duration = durationAt(samples, sampleRate)
+				ok = true
+			}
+		case 4: // VORBIS_COMMENT
+			info.artist, info.title = vorbisComments(block)
+		}
+		if last {
+			break
+		}
+		blocks = blocks[4+length:]
+	}
+	return
+}
+
+// vorbisComments reads the artist and title from a Vorbis comment block
+// (as used by FLAC, Vorbis, and Opus)
+func vorbisComments(block []byte) (artist, title string) {
+	if len(block) < 4 {
+		return
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(block))
+	if 4+vendorLen+4 > len(block) {
+		return
+	}
+	block = block[4+vendorLen:]
+	count := int(binary.LittleEndian.Uint32(block))
+	block = block[4:]
+	for i := 0; i < count && len(block) >= 4; i++ {
+		length := int(binary.LittleEndian.Uint32(block))
+		if 4+length > len(block) {
+			break
+		}
+		key, value, _ := strings.Cut(string(block[4:4+length]), "=")
+		switch strings.ToUpper(key) {
+		case "ARTIST":
+			artist = value
+		case "TITLE":
+			title = value
+		}
+		block = block[4+length:]
+	}
+	return
+}
+
+// oggPackets reassembles the first packets of an Ogg stream from its pages
+func oggPackets(head []byte, limit int) (packets [][]byte) {
+	var current []byte
+	for len(head) >= 27 && string(head[0:4]) == "OggS" && len(packets) < limit {
+		segments := int(head[26])
+		if 27+segments > len(head) {
+			break
+		}
+		lacing := head[27 : 27+segments]
+		data := head[27+segments:]
+		for _, length := range lacing {
+			if int(length) > len(data) {
+				return
+			}
+			current = append(current, data[:length]...)
+			data = data[length:]
+			if length < 255 {
+				packets = append(packets, current)
+				current = nil
+			}
+		}
+		head = data
+	}
+	return
+}
+
+// oggInfo reads the identification and comment headers of Vorbis or Opus
+// in an Ogg container; the duration is only estimated for Vorbis, from its
+// nominal bitrate
+func oggInfo(head []byte, size int64) (info audioInfo, ok bool) {
+	packets := oggPackets(head, 2)
+	if len(packets) == 0 {
+		return
+	}
+	identification := packets[0]
+	switch {
+	case bytes.HasPrefix(identification, []byte("\x01vorbis")) && len(identification) >= 24:
+		bitrate := int32(binary.LittleEndian.Uint32(identification[20:24]))
+		if bitrate > 0 && size > 0 && size <= math.MaxInt64/8 {
+			info.duration = durationAt(size*8, int64(bitrate))
+		}
+		if len(packets) > 1 && bytes.HasPrefix(packets[1], []byte("\x03vorbis")) {
+			info.artist, info.title = vorbisComments(packets[1][7:])
+		}
+	case bytes.HasPrefix(identification, []byte("OpusHead")):
+		if len(packets) > 1 && bytes.HasPrefix(packets[1], []byte("OpusTags")) {
+			info.artist, info.title = vorbisComments(packets[1][8:])
+		}
+	default:
+		return
+	}
+	return info, true
+}
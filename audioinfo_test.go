@@ -0,0 +1,214 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func testSyncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// testID3Frame encodes a text frame (in UTF-8, or UTF-16 with a BOM for
+// encoding 1) for the given ID3v2 version
+func testID3Frame(version byte, id string, encoding byte, text string) []byte {
+	body := []byte{encoding}
+	if encoding == 1 {
+		body = append(body, 0xff, 0xfe)
+		for _, r := range text {
+			body = binary.LittleEndian.AppendUint16(body, uint16(r))
+		}
+	} else {
+		body = append(body, text...)
+	}
+	frame := []byte(id)
+	switch version {
+	case 2:
+		frame = append(frame, byte(len(body)>>16), byte(len(body)>>8), byte(len(body)))
+	case 3:
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+		frame = append(frame, 0, 0)
+	default:
+		frame = append(frame, testSyncsafe(len(body))...)
+		frame = append(frame, 0, 0)
+	}
+	return append(frame, body...)
+}
+
+func testID3Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, 16)...) // padding
+	tag := append([]byte{'I', 'D', '3', version, 0, 0}, testSyncsafe(len(body))...)
+	return append(tag, body...)
+}
+
+// a 128 kbit/s, 44.1 kHz, stereo MPEG 1 layer III frame header
+var testMP3Frame = []byte{0xff, 0xfb, 0x90, 0x00}
+
+func testXingFrame(frames uint32) []byte {
+	frame := append(append([]byte(nil), testMP3Frame...), make([]byte, 32)...) // side info
+	frame = append(frame, "Xing"...)
+	frame = binary.BigEndian.AppendUint32(frame, 1)
+	return binary.BigEndian.AppendUint32(frame, frames)
+}
+
+func TestMP3Info(t *testing.T) {
+	cases := []struct {
+		name     string
+		head     []byte
+		size     int64
+		expected audioInfo
+	}{
+		{"ID3v2.2",
+			append(testID3Tag(2, testID3Frame(2, "TT2", 0, "Title"), testID3Frame(2, "TP1", 0, "Artist"), testID3Frame(2, "TLE", 0, "225000")), testMP3Frame...),
+			0, audioInfo{"Artist", "Title", 225 * time.Second}},
+		{"ID3v2.3",
+			append(testID3Tag(3, testID3Frame(3, "TPE1", 1, "Artíst"), testID3Frame(3, "TIT2", 1, "Títle")), testMP3Frame...),
+			0, audioInfo{"Artíst", "Títle", 0}},
+		{"ID3v2.4",
+			append(testID3Tag(4, testID3Frame(4, "TIT2", 3, "Title"), testID3Frame(4, "TPE1", 3, "Artist")), testXingFrame(1000)...),
+			1 << 20, audioInfo{"Artist", "Title", 26122448979}},
+		// 16000 bytes a second, in a file too big for size*8*time.Second
+		{"CBR over 1 GB", testMP3Frame, 2000000000, audioInfo{"", "", 125000 * time.Second}},
+		{"huge TLEN", testID3Tag(3, testID3Frame(3, "TIT2", 0, "Title"), testID3Frame(3, "TLEN", 0, "99999999999999999999")), 0, audioInfo{"", "Title", 0}},
+		{"frame past the end of the tag",
+			append(testID3Tag(3, testID3Frame(3, "TIT2", 0, "Title")), []byte("TPE1\x7f\xff\xff\xff\x00\x00\x03Artist")...),
+			0, audioInfo{"", "Title", 0}},
+	}
+	for _, c := range cases {
+		if info, _ := mp3Info(c.head, c.size); info != c.expected {
+			t.Errorf("%s: got %#v, expected %#v", c.name, info, c.expected)
+		}
+		for i := range c.head {
+			describeAudio(c.head[:i], c.size)
+		}
+	}
+}
+
+func testVorbisComments(comments ...string) []byte {
+	block := binary.LittleEndian.AppendUint32(nil, 6)
+	block = append(block, "vendor"...)
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, comment := range comments {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(comment)))
+		block = append(block, comment...)
+	}
+	return block
+}
+
+func testFLAC(sampleRate int, samples int64, comments []byte) []byte {
+	streamInfo := make([]byte, 34)
+	streamInfo[10] = byte(sampleRate >> 12)
+	streamInfo[11] = byte(sampleRate >> 4)
+	streamInfo[12] = byte(sampleRate<<4) | 0x02
+	streamInfo[13] = byte(samples >> 32 & 0x0f)
+	binary.BigEndian.PutUint32(streamInfo[14:], uint32(samples))
+	file := append([]byte("fLaC"), 0, 0, 0, byte(len(streamInfo)))
+	file = append(file, streamInfo...)
+	file = append(file, 0x84, byte(len(comments)>>16), byte(len(comments)>>8), byte(len(comments)))
+	return append(file, comments...)
+}
+
+func TestFLACInfo(t *testing.T) {
+	comments := testVorbisComments("title=Title", "ARTIST=Artist", "ALBUM=Album")
+	cases := []struct {
+		name     string
+		head     []byte
+		expected audioInfo
+		ok       bool
+	}{
+		{"tags", testFLAC(44100, 44100*200, comments), audioInfo{"Artist", "Title", 200 * time.Second}, true},
+		{"largest sample count", testFLAC(10, 1<<36-1, comments), audioInfo{"Artist", "Title", 6871947673*time.Second + 500*time.Millisecond}, true},
+		{"too long for a time.Duration", testFLAC(1, 1<<36-1, comments), audioInfo{"Artist", "Title", 0}, true},
+		{"no sample rate", testFLAC(0, 1000, comments), audioInfo{"Artist", "Title", 0}, true},
+		{"no comments", testFLAC(48000, 48000*3, nil), audioInfo{"", "", 3 * time.Second}, true},
+		{"comment count past the end", testFLAC(48000, 48000, testVorbisComments("TITLE=Title")[:14]), audioInfo{"", "", time.Second}, true},
+		{"truncated STREAMINFO", testFLAC(48000, 48000, comments)[:20], audioInfo{}, false},
+	}
+	for _, c := range cases {
+		if info, ok := flacInfo(c.head); info != c.expected || ok != c.ok {
+			t.Errorf("%s: got %#v, %t; expected %#v, %t", c.name, info, ok, c.expected, c.ok)
+		}
+		for i := range c.head {
+			describeAudio(c.head[:i], 0)
+		}
+	}
+}
+
+// testOggPage puts packets (of under 255 bytes each) in a page
+func testOggPage(packets ...[]byte) []byte {
+	page := append([]byte("OggS"), make([]byte, 22)...)
+	page = append(page, byte(len(packets)))
+	for _, packet := range packets {
+		page = append(page, byte(len(packet)))
+	}
+	return append(page, bytes.Join(packets, nil)...)
+}
+
+func testVorbisIdentification(bitrate uint32) []byte {
+	packet := append([]byte("\x01vorbis"), make([]byte, 23)...)
+	binary.LittleEndian.PutUint32(packet[20:], bitrate)
+	return packet
+}
+
+func TestOggInfo(t *testing.T) {
+	vorbisComment := append([]byte("\x03vorbis"), testVorbisComments("ARTIST=Artist", "TITLE=Title")...)
+	opusComment := append([]byte("OpusTags"), testVorbisComments("ARTIST=Artist", "TITLE=Title")...)
+	// a comment packet continued from one page onto the next
+	longComment := append([]byte("\x03vorbis"), testVorbisComments("TITLE=Title", "COMMENT="+string(bytes.Repeat([]byte("x"), 300)))...)
+	continued := testOggPage(testVorbisIdentification(0))
+	continued = append(continued, append([]byte("OggS"), make([]byte, 22)...)...)
+	continued = append(continued, 1, 255)
+	continued = append(continued, longComment[:255]...)
+	continued = append(continued, testOggPage(longComment[255:])...)
+
+	cases := []struct {
+		name     string
+		head     []byte
+		size     int64
+		expected audioInfo
+		ok       bool
+	}{
+		{"Vorbis", testOggPage(testVorbisIdentification(128000), vorbisComment), 2000000, audioInfo{"Artist", "Title", 125 * time.Second}, true},
+		{"Vorbis over 1 GB", testOggPage(testVorbisIdentification(128000), vorbisComment), 2000000000, audioInfo{"Artist", "Title", 125000 * time.Second}, true},
+		{"Vorbis with a huge size", testOggPage(testVorbisIdentification(128000)), 1 << 62, audioInfo{}, true},
+		{"Vorbis with no bitrate", testOggPage(testVorbisIdentification(0), vorbisComment), 2000000, audioInfo{"Artist", "Title", 0}, true},
+		{"Vorbis comments across pages", continued, 0, audioInfo{"", "Title", 0}, true},
+		{"Opus", testOggPage([]byte("OpusHead\x01\x02"), opusComment), 2000000, audioInfo{"Artist", "Title", 0}, true},
+		{"not audio", testOggPage([]byte("\x80theora")), 0, audioInfo{}, false},
+		{"no packets", []byte("OggS"), 0, audioInfo{}, false},
+	}
+	for _, c := range cases {
+		if info, ok := oggInfo(c.head, c.size); info != c.expected || ok != c.ok {
+			t.Errorf("%s: got %#v, %t; expected %#v, %t", c.name, info, ok, c.expected, c.ok)
+		}
+		for i := range c.head {
+			describeAudio(c.head[:i], c.size)
+		}
+	}
+}
+
+func TestDurationAt(t *testing.T) {
+	cases := []struct {
+		amount, rate int64
+		expected     time.Duration
+	}{
+		{44100 * 3, 44100, 3 * time.Second},
+		{1, 3, 333333333},
+		{1 << 62, 1, 0},
+		{1 << 62, 1 << 31, 2147483648 * time.Second},
+		{0, 1, 0},
+		{-5, 1, 0},
+		{5, 0, 0},
+	}
+	for _, c := range cases {
+		if result := durationAt(c.amount, c.rate); result != c.expected {
+			t.Errorf("durationAt(%d, %d) = %v, expected %v", c.amount, c.rate, result, c.expected)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// a hostname (at the start of the message or after whitespace or an
+	// opening bracket or quote), optionally followed by a path
+	bareDomainRe = regexp.MustCompile(`(?i)(?:^|[\s(<"'])((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+([a-z]{2,24}))(/\S*)?`)
+
+	// top-level domains we recognize in bare domains; others (e.g. .local,
+	// or .exe) are more likely to be something else
+	bareDomainTLDs = map[string]bool{
+		"com": true, "org": true, "net": true, "edu": true, "gov": true, "info": true, "io": true,
+		"dev": true, "app": true, "co": true, "me": true, "tv": true, "fm": true, "xyz": true,
+		"ai": true, "news": true, "blog": true, "wiki": true, "social": true, "chat": true,
+		"uk": true, "de": true, "fr": true, "nl": true, "eu": true, "ca": true, "au": true,
+		"us": true, "jp": true, "ru": true, "it": true, "es": true, "se": true, "ch": true,
+		"at": true, "be": true, "cz": true, "dk": true, "fi": true, "no": true, "nz": true,
+		"in": true, "br": true, "ie": true, "is": true, "tech": true, "site": true, "link": true,
+		// also common file extensions; see below
+		"sh": true, "py": true, "md": true, "rs": true, "pl": true, "so": true,
+	}
+	// TLDs that are also common file extensions (main.py, README.md): these
+	// need a www. or a path to be taken as a domain
+	fileExtensionTLDs = map[string]bool{
+		"sh": true, "py": true, "md": true, "rs": true, "pl": true, "so": true,
+	}
+)
+
+// findBareDomains finds links without a scheme, like www.example.com/foo
+// or example.org, in the part of a message that isn't already a URL (at spans)
+func findBareDomains(message string, spans [][]int) (matches []urlMatch) {
+	// blank out the URLs, keeping the whitespace around them
+	masked := []byte(message)
+	for _, span := range spans {
+		for i := span[0]; i < span[1]; i++ {
+			masked[i] = ' '
+		}
+	}
+	message = string(masked)
+	for _, match := range bareDomainRe.FindAllStringSubmatchIndex(message, -1) {
+		host, tld := message[match[2]:match[3]], strings.ToLower(message[match[4]:match[5]])
+		var path string
+		if match[6] != -1 {
+			path = message[match[6]:match[7]]
+		}
+		if !bareDomainTLDs[tld] {
+			continue
+		}
+		if fileExtensionTLDs[tld] && path == "" && !strings.HasPrefix(strings.ToLower(host), "www.") {
+			continue
+		}
+		// trailing punctuation is more likely to be part of the sentence
+		path = strings.TrimRight(path, ".,;:!?)>\"'")
+		matches = append(matches, urlMatch{match[2], "https://" + host + path})
+	}
+	return
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindBareDomains(t *testing.T) {
+	cases := []struct {
+		message  string
+		expected []string
+	}{
+		{"nothing to see", nil},
+		{"see example.org", []string{"https://example.org"}},
+		{"(www.example.com/foo/bar).", []string{"https://www.example.com/foo/bar"}},
+		// in the order they appear, around the URLs with a scheme
+		{"example.org then https://example.com/a then example.net/b",
+			[]string{"https://example.org", "https://example.com/a", "https://example.net/b"}},
+		{"https://example.com/a see also example.org",
+			[]string{"https://example.com/a", "https://example.org"}},
+		// not inside a URL, and not file names or unknown TLDs
+		{"https://example.com/example.org", []string{"https://example.com/example.org"}},
+		{"edit main.py and README.md, or file.exe", nil},
+		{"www.example.py github.sh/foo", []string{"https://www.example.py", "https://github.sh/foo"}},
+	}
+	for _, c := range cases {
+		if result := findLinks(c.message, true); !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("findLinks(%q, true) = %v, expected %v", c.message, result, c.expected)
+		}
+	}
+}
+
+func TestSplitSpoilersBareDomains(t *testing.T) {
+	urls, spoilers := splitSpoilers("example.org ||https://example.com/spoiler|| https://example.net", true)
+	if expected := []string{"https://example.org", "https://example.net"}; !reflect.DeepEqual(urls, expected) {
+		t.Errorf("got links %v, expected %v", urls, expected)
+	}
+	if expected := []string{"https://example.com/spoiler"}; !reflect.DeepEqual(spoilers, expected) {
+		t.Errorf("got spoilers %v, expected %v", spoilers, expected)
+	}
+	if urls, _ := splitSpoilers("example.org https://example.net", false); !reflect.DeepEqual(urls, []string{"https://example.net"}) {
+		t.Errorf("got links %v without bare domains", urls)
+	}
+}
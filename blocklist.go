@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBlocklistRefresh = 24 * time.Hour
+	// large lists of malware domains can run to tens of megabytes
+	blocklistReadLimit = 64 * 1024 * 1024
+)
+
+// remoteBlocklist is a set of domains to refuse to title, downloaded from
+// one or more URLs (in hosts-file format, or one domain per line) and
+// refreshed periodically
+type remoteBlocklist struct {
+	urls    []string
+	refresh time.Duration
+
+	sync.RWMutex
+	domains map[string]empty
+}
+
+// contains checks whether host, or any domain it's a subdomain of, is blocked
+func (b *remoteBlocklist) contains(host string) bool {
+	b.RLock()
+	defer b.RUnlock()
+	if len(b.domains) == 0 {
+		return false
+	}
+	for {
+		if _, ok := b.domains[host]; ok {
+			return true
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot == -1 {
+			return false
+		}
+		host = host[dot+1:]
+	}
+}
+
+// parseBlocklist parses a hosts file (`0.0.0.0 example.com`) or a plain list
+// of domains, skipping comments and blank lines
+func parseBlocklist(r io.Reader, domains map[string]empty) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, '#'); comment != -1 {
+			line = line[:comment]
+		}
+		f := strings.Fields(line)
+		var domain string
+		switch len(f) {
+		case 0:
+			continue
+		case 1:
+			domain = f[0]
+		default:
+			domain = f[1]
+		}
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		switch domain {
+		case "", "localhost", "localhost.localdomain", "local", "broadcasthost", "0.0.0.0":
+			continue
+		}
+		domains[domain] = empty{}
+	}
+	return scanner.Err()
+}
+
+func (irc *Bot) fetchBlocklist(url string, domains map[string]empty) error {
+	req, err := http.NewRequestWithContext(irc.ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad http code %d", resp.StatusCode)
+	}
+	return parseBlocklist(&io.LimitedReader{R: resp.Body, N: blocklistReadLimit}, domains)
+}
+
+// refreshBlocklists downloads the remote blocklists; if a list can't be
+// downloaded, the domains previously downloaded from it are kept
+func (irc *Bot) refreshBlocklists(previous map[string]map[string]empty) {
+	b := irc.remoteBlocklist
+	merged := make(map[string]empty)
+	for _, url := range b.urls {
+		domains := make(map[string]empty)
+		err := irc.fetchBlocklist(url, domains)
+		if irc.checkErr(err, "couldn't refresh blocklist "+url) {
+			domains = previous[url]
+		} else {
+			previous[url] = domains
+		}
+		for domain := range domains {
+			merged[domain] = empty{}
+		}
+	}
+	b.Lock()
+	b.domains = merged
+	b.Unlock()
+	irc.logDebug("blocklist", "loaded remote blocklists", "domains", len(merged))
+}
+
+func (irc *Bot) blocklistLoop() {
+	previous := make(map[string]map[string]empty)
+	for {
+		irc.refreshBlocklists(previous)
+		time.Sleep(irc.remoteBlocklist.refresh)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"io"
+	"math/bits"
+	"regexp"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	bodyReadChunk = 16 * 1024
+	// how far back into what we've already read to look for the start of a
+	// title that was cut off by the end of the last read
+	earlyExitOverlap = 4 * 1024
+	// buffers larger than this (e.g., after the read limits were raised)
+	// aren't kept for reuse
+	maxPooledBuffer = 4 * trustedReadLimit
+)
+
+// bodyBuffers are reused for reading pages, since allocating a buffer of up
+// to the read limit for every link makes a lot of garbage during bursts
+var bodyBuffers bufferPool
+
+// bufferPool keeps a pool of buffers for each size class (a power of two),
+// so that small reads don't take the large buffers and large reads don't
+// throw away the small ones
+type bufferPool struct {
+	pools [bits.UintSize]sync.Pool // indexed by log2 of the capacity
+	gets  atomic.Uint64
+	hits  atomic.Uint64 // gets satisfied by a pooled buffer
+}
+
+// bufferPoolStats is reported on /healthz
+type bufferPoolStats struct {
+	Gets uint64 `json:"gets"`
+	Hits uint64 `json:"hits"`
+}
+
+// sizeClass returns the smallest power of two that's at least size, and
+// its log2
+func sizeClass(size int) (class, classSize int) {
+	class = bits.Len(uint(max(size, 1) - 1))
+	return class, 1 << class
+}
+
+// get returns an empty buffer with a capacity of at least size
+func (p *bufferPool) get(size int) *[]byte {
+	p.gets.Add(1)
+	class, classSize := sizeClass(size)
+	if classSize > maxPooledBuffer {
+		buf := make([]byte, 0, size)
+		return &buf
+	}
+	if buf, ok := p.pools[class].Get().(*[]byte); ok {
+		p.hits.Add(1)
+		*buf = (*buf)[:0]
+		return buf
+	}
+	buf := make([]byte, 0, classSize)
+	return &buf
+}
+
+func (p *bufferPool) put(buf *[]byte) {
+	// (only buffers from get of a pooled size class)
+	if class, classSize := sizeClass(cap(*buf)); classSize == cap(*buf) && classSize <= maxPooledBuffer {
+		p.pools[class].Put(buf)
+	}
+}
+
+func (p *bufferPool) stats() bufferPoolStats {
+	return bufferPoolStats{Gets: p.gets.Load(), Hits: p.hits.Load()}
+}
+
+// readUntilMatch reads up to limit bytes of a page into buf (which must have
+// a capacity of at least limit), stopping as soon as re matches what it has
+// read (e.g., once it has seen the title) rather than reading the rest of
+// the page. truncated is whether it stopped at the limit.
+func readUntilMatch(r io.Reader, buf []byte, limit int, re *regexp.Regexp) (body []byte, truncated bool, err error) {
+	body = buf[:0]
+	for len(body) < limit {
+		previous := len(body)
+		var n int
+		n, err = r.Read(body[previous:min(previous+bodyReadChunk, limit)])
+		body = body[:previous+n]
+		if err == io.EOF {
+			return body, false, nil
+		} else if err != nil {
+			return body, false, err
+		}
+		if n > 0 && re.Match(body[max(0, previous-earlyExitOverlap):]) {
+			return body, false, nil
+		}
+	}
+	return body, true, nil
+}
+
+// readRest reads the rest of a page that readUntilMatch stopped reading
+// early, for annotations that need the whole page, so that the page is at
+// most limit bytes in all. complete is whether it got the whole page.
+func readRest(r io.Reader, body []byte, limit int) (page []byte, complete bool) {
+	if len(body) >= limit {
+		return body, false
+	}
+	rest, err := io.ReadAll(io.LimitReader(r, int64(limit-len(body)+1)))
+	page = append(body[:len(body):len(body)], rest...)
+	if err != nil || len(page) > limit {
+		return page[:min(len(page), limit)], false
+	}
+	return page, true
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// query parameters that only track where a link was shared from
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+	// AMP markers, for pages that serve AMP versions at the same path
+	"amp":        true,
+	"outputtype": true,
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// canonicalizeURL normalizes a web link so that trivially different links
+// to the same page are the same: it lowercases the host, removes the default
+// port, the fragment, and tracking parameters (utm_* etc.), and resolves
+// AMP cache links to the page they're a copy of. Other links are unchanged.
+func canonicalizeURL(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return urlStr
+	}
+	if resolved, ok := resolveAMP(u); ok {
+		return canonicalizeURL(resolved)
+	}
+	u.Host = strings.ToLower(u.Host)
+	if host, port, err := net.SplitHostPort(u.Host); err == nil && port == defaultPorts[u.Scheme] {
+		u.Host = host
+		if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]" // IPv6
+		}
+	}
+	u.Fragment, u.RawFragment = "", ""
+	if u.RawQuery != "" {
+		// filter the parameters as written, rather than re-encoding them,
+		// which would reorder them
+		var kept []string
+		for _, param := range strings.Split(u.RawQuery, "&") {
+			key, _, _ := strings.Cut(param, "=")
+			key = strings.ToLower(key)
+			if param == "" || trackingParams[key] || strings.HasPrefix(key, "utm_") {
+				continue
+			}
+			kept = append(kept, param)
+		}
+		u.RawQuery = strings.Join(kept, "&")
+		u.ForceQuery = false
+	}
+	result := u.String()
+	// fragments like #/room or #!/page are routes in single-page apps,
+	// and pick out a different page; keep them as they were written
+	if _, fragment, _ := strings.Cut(urlStr, "#"); strings.HasPrefix(fragment, "/") || strings.HasPrefix(fragment, "!") {
+		result += "#" + fragment
+	}
+	return result
+}
+
+// resolveAMP finds the original URL of a page in an AMP cache:
+// https://www.google.com/amp/s/example.com/page or
+// https://example-com.cdn.ampproject.org/c/s/example.com/page
+func resolveAMP(u *url.URL) (original string, ok bool) {
+	host := strings.ToLower(u.Hostname())
+	var rest string
+	switch {
+	case strings.HasSuffix(host, ".cdn.ampproject.org"):
+		// /c/ for pages, /v/ for viewer pages, /i/ for images
+		if len(u.Path) < 3 || u.Path[0] != '/' || u.Path[2] != '/' {
+			return
+		}
+		rest = u.Path[3:]
+	case domainMatch(host, "google.com") && strings.HasPrefix(u.Path, "/amp/"):
+		rest = strings.TrimPrefix(u.Path, "/amp/")
+	default:
+		return
+	}
+	scheme := "http://"
+	if after, found := strings.CutPrefix(rest, "s/"); found {
+		scheme, rest = "https://", after
+	}
+	if rest == "" {
+		return
+	}
+	original = scheme + rest
+	if u.RawQuery != "" {
+		original += "?" + u.RawQuery
+	}
+	return original, true
+}
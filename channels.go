@@ -0,0 +1,251 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// RFC 1459 defaults, if the server doesn't send PREFIX and CHANMODES
+	defaultPrefixModes = "ov"
+	defaultPrefixes    = "@+"
+	defaultChanModes   = "b,k,l,imnpst"
+)
+
+// channelTracker tracks the members of the channels we're in,
+// and their prefix modes (e.g., @ for channel operator)
+type channelTracker struct {
+	sync.Mutex
+	// map from casefolded channel name to a map from casefolded
+	// nick to the member's prefixes, e.g. "@+"
+	channels map[string]map[string]string
+}
+
+// prefixModes parses PREFIX from ISUPPORT, e.g. (qaohv)~&@%+
+func prefixModes(isupport map[string]string) (modes, prefixes string) {
+	prefix := isupport["PREFIX"]
+	if strings.HasPrefix(prefix, "(") {
+		if end := strings.IndexByte(prefix, ')'); end != -1 && len(prefix)-end-1 == end-1 {
+			return prefix[1:end], prefix[end+1:]
+		}
+	}
+	return defaultPrefixModes, defaultPrefixes
+}
+
+func (c *channelTracker) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.channels = make(map[string]map[string]string)
+}
+
+func (c *channelTracker) joined(channel, nick string, self bool) {
+	c.Lock()
+	defer c.Unlock()
+	channel = strings.ToLower(channel)
+	if self || c.channels[channel] == nil {
+		c.channels[channel] = make(map[string]string)
+	}
+	c.channels[channel][strings.ToLower(nick)] = ""
+}
+
+func (c *channelTracker) parted(channel, nick string, self bool) {
+	c.Lock()
+	defer c.Unlock()
+	channel = strings.ToLower(channel)
+	if self {
+		delete(c.channels, channel)
+	} else if members := c.channels[channel]; members != nil {
+		delete(members, strings.ToLower(nick))
+	}
+}
+
+func (c *channelTracker) quit(nick string) {
+	c.Lock()
+	defer c.Unlock()
+	nick = strings.ToLower(nick)
+	for _, members := range c.channels {
+		delete(members, nick)
+	}
+}
+
+func (c *channelTracker) renamed(oldNick, newNick string) {
+	c.Lock()
+	defer c.Unlock()
+	oldNick, newNick = strings.ToLower(oldNick), strings.ToLower(newNick)
+	for _, members := range c.channels {
+		if prefixes, ok := members[oldNick]; ok {
+			delete(members, oldNick)
+			members[newNick] = prefixes
+		}
+	}
+}
+
+// names processes the members list from RPL_NAMREPLY
+func (c *channelTracker) names(channel string, names []string, allPrefixes string) {
+	c.Lock()
+	defer c.Unlock()
+	members := c.channels[strings.ToLower(channel)]
+	if members == nil {
+		return
+	}
+	for _, name := range names {
+		nick := strings.TrimLeft(name, allPrefixes)
+		if nick != "" {
+			members[strings.ToLower(nick)] = name[:len(name)-len(nick)]
+		}
+	}
+}
+
+// setPrefix adds or removes a prefix (e.g. @) from a channel member
+func (c *channelTracker) setPrefix(channel, nick string, prefix byte, add bool) {
+	c.Lock()
+	defer c.Unlock()
+	members := c.channels[strings.ToLower(channel)]
+	if members == nil {
+		return
+	}
+	nick = strings.ToLower(nick)
+	prefixes, ok := members[nick]
+	if !ok {
+		return
+	}
+	prefixes = strings.ReplaceAll(prefixes, string(prefix), "")
+	if add {
+		prefixes += string(prefix)
+	}
+	members[nick] = prefixes
+}
+
+func (c *channelTracker) prefixes(channel, nick string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.channels[strings.ToLower(channel)][strings.ToLower(nick)]
+}
+
+// list returns the casefolded names of the channels we're in
+func (c *channelTracker) list() (result []string) {
+	c.Lock()
+	defer c.Unlock()
+	result = make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		result = append(result, channel)
+	}
+	return
+}
+
+func (c *channelTracker) isMember(channel, nick string) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.channels[strings.ToLower(channel)][strings.ToLower(nick)]
+	return ok
+}
+
+// isChannelOperator checks whether nick has channel operator
+// status (or higher, e.g. ~ or &) in channel
+func (irc *Bot) isChannelOperator(channel, nick string) bool {
+	modes, prefixes := prefixModes(irc.ISupport())
+	opIndex := strings.IndexByte(modes, 'o')
+	if opIndex == -1 {
+		return false
+	}
+	return strings.ContainsAny(irc.channels.prefixes(channel, nick), prefixes[:opIndex+1])
+}
+
+// handleChannelMode tracks changes to prefix modes, e.g. MODE #chan +o-v nick nick
+func (irc *Bot) handleChannelMode(e ircmsg.Message) {
+	if len(e.Params) < 2 || !strings.HasPrefix(e.Params[0], "#") {
+		return
+	}
+	isupport := irc.ISupport()
+	modes, prefixes := prefixModes(isupport)
+	chanModes := strings.Split(isupport["CHANMODES"], ",")
+	if len(chanModes) < 4 {
+		chanModes = strings.Split(defaultChanModes, ",")
+	}
+	channel := e.Params[0]
+	args := e.Params[2:]
+	add := true
+	for _, mode := range e.Params[1] {
+		var takesArg bool
+		switch {
+		case mode == '+' || mode == '-':
+			add = mode == '+'
+			continue
+		case strings.ContainsRune(modes, mode):
+			if len(args) != 0 {
+				irc.channels.setPrefix(channel, args[0], prefixes[strings.IndexRune(modes, mode)], add)
+			}
+			takesArg = true
+		case strings.ContainsRune(chanModes[0], mode) || strings.ContainsRune(chanModes[1], mode):
+			takesArg = true
+		case strings.ContainsRune(chanModes[2], mode):
+			takesArg = add
+		}
+		if takesArg && len(args) != 0 {
+			args = args[1:]
+		}
+	}
+}
+
+func (irc *Bot) setupChannelTracking() {
+	irc.channels.reset()
+	irc.AddConnectCallback(func(e ircmsg.Message) {
+		irc.channels.reset()
+	})
+	irc.AddCallback("JOIN", func(e ircmsg.Message) {
+		if len(e.Params) > 0 {
+			nick := e.Nick()
+			irc.channels.joined(e.Params[0], nick, nick == irc.CurrentNick())
+		}
+	})
+	irc.AddCallback("PART", func(e ircmsg.Message) {
+		if len(e.Params) > 0 {
+			nick := e.Nick()
+			irc.channels.parted(e.Params[0], nick, nick == irc.CurrentNick())
+		}
+	})
+	irc.AddCallback("KICK", func(e ircmsg.Message) {
+		// KICK <channel> <nick> [<reason>]
+		if len(e.Params) > 1 {
+			irc.channels.parted(e.Params[0], e.Params[1], e.Params[1] == irc.CurrentNick())
+		}
+	})
+	irc.AddCallback("QUIT", func(e ircmsg.Message) {
+		irc.channels.quit(e.Nick())
+	})
+	irc.AddCallback("NICK", func(e ircmsg.Message) {
+		if len(e.Params) > 0 {
+			irc.channels.renamed(e.Nick(), e.Params[0])
+		}
+	})
+	irc.AddCallback("MODE", irc.handleChannelMode)
+	irc.AddCallback(ircevent.RPL_NAMREPLY, func(e ircmsg.Message) {
+		// 353 <client> <symbol> <channel> :[prefix]<nick>{ [prefix]<nick>}
+		if len(e.Params) > 3 {
+			_, prefixes := prefixModes(irc.ISupport())
+			irc.channels.names(e.Params[2], strings.Fields(e.Params[3]), prefixes)
+		}
+	})
+}
+
+// joinChannel joins a channel at runtime, remembering it across restarts
+func (irc *Bot) joinChannel(channel, key string) {
+	if key == "" {
+		irc.Join(channel)
+	} else {
+		irc.Send("JOIN", channel, key)
+	}
+	irc.reportErr(irc.state.setJoined(channel, key, true), "couldn't save joined channels")
+}
+
+// partChannel parts a channel at runtime, remembering it across restarts
+func (irc *Bot) partChannel(channel string) {
+	irc.Part(channel)
+	irc.reportErr(irc.state.setJoined(channel, "", false), "couldn't save joined channels")
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// parseCommand checks whether a message is addressed to the bot
+// by its current nick (e.g., "titlebot: optout", or "titlebot_: optout"
+// while the configured nick is taken), returning the fields of the command
+func (irc *Bot) parseCommand(message string) (f []string) {
+	nick := irc.CurrentNick()
+	if !strings.HasPrefix(message, nick) {
+		return nil
+	}
+	command := strings.TrimPrefix(message, nick)
+	command = strings.TrimPrefix(command, ":")
+	return strings.Fields(command)
+}
+
+func messageAccount(e ircmsg.Message) (account string) {
+	if present, account := e.GetTag("account"); present && account != "*" {
+		return account
+	}
+	return ""
+}
+
+// optedOut checks whether the sender of a message has asked us not to
+// title their links
+func (irc *Bot) optedOut(e ircmsg.Message) bool {
+	account := messageAccount(e)
+	return account != "" && irc.state.isOptedOut(account)
+}
+
+// handleUserCommand handles commands that anyone can use
+func (irc *Bot) handleUserCommand(e ircmsg.Message, target, msgid string, f []string) (handled bool) {
+	switch strings.ToLower(f[0]) {
+	case "title":
+		// explicitly requested titles (e.g. in passive mode)
+		if irc.state.getChannelSettings(target).Disabled || irc.optedOut(e) {
+			return true
+		}
+		urls, spoilers := splitSpoilers(strings.Join(f[1:], " "), false)
+		if irc.skipSpoilers {
+			spoilers = nil
+		}
+		req := titleRequest{target: target, msgid: msgid, nick: e.Nick(), account: messageAccount(e), requested: true}
+		go irc.titleLinks(req, urls, spoilers)
+		return true
+	case "links":
+		irc.handleLinksCommand(e, target, msgid, f)
+		return true
+	case "find":
+		irc.handleFindCommand(e, target, msgid, f)
+		return true
+	case "top":
+		irc.handleTopCommand(e, target, msgid, f)
+		return true
+	case "save":
+		irc.handleSaveCommand(e, target, msgid, f)
+		return true
+	case "readlater":
+		irc.handleReadLaterCommand(e, target, msgid, f)
+		return true
+	case "optout", "optin":
+		optOut := strings.ToLower(f[0]) == "optout"
+		account := messageAccount(e)
+		if account == "" {
+			irc.sendReplyNotice(target, msgid, "you must be logged into an account to do that")
+			return true
+		}
+		if irc.reportErr(irc.state.setOptOut(account, optOut), "couldn't save opt-out") {
+			irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		} else if optOut {
+			irc.sendReplyNotice(target, msgid, "OK, I won't title your links (say optin to undo this)")
+		} else {
+			irc.sendReplyNotice(target, msgid, "OK, I'll title your links again")
+		}
+		return true
+	}
+	return false
+}
+
+// handleIgnoreCommand handles `ignore [<nick|account|nick!user@host>]`
+// and `unignore <nick|account|nick!user@host>`
+func (irc *Bot) handleIgnoreCommand(target, msgid string, f []string) {
+	ignore := strings.ToLower(f[0]) == "ignore"
+	if len(f) < 2 {
+		if ignore {
+			ignores := irc.state.getIgnores()
+			if len(ignores) == 0 {
+				irc.sendReplyNotice(target, msgid, "not ignoring anyone")
+			} else {
+				irc.sendReplyNotice(target, msgid, "ignoring: "+strings.Join(ignores, ", "))
+			}
+		}
+		return
+	}
+	changed, err := irc.state.setIgnore(f[1], ignore)
+	if ignore {
+		irc.replyListChange(target, msgid, changed, err, "now ignoring "+f[1], "already ignoring "+f[1])
+	} else {
+		irc.replyListChange(target, msgid, changed, err, "no longer ignoring "+f[1], "wasn't ignoring "+f[1])
+	}
+}
+
+// replyListChange reports the result of adding to or removing from a persistent list
+func (irc *Bot) replyListChange(target, msgid string, changed bool, err error, success, unchanged string) {
+	switch {
+	case irc.reportErr(err, "couldn't save state"):
+		irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+	case !changed:
+		irc.sendReplyNotice(target, msgid, unchanged)
+	default:
+		irc.sendReplyNotice(target, msgid, success)
+	}
+}
+
+// handleBlockCommand handles `block [<domain>]` and `unblock <domain>`
+func (irc *Bot) handleBlockCommand(target, msgid string, f []string) {
+	block := strings.ToLower(f[0]) == "block"
+	if len(f) < 2 {
+		if block {
+			blocked := append(irc.state.getBlockedDomains(), irc.blockedDomains...)
+			if len(blocked) == 0 {
+				irc.sendReplyNotice(target, msgid, "no domains are blocked")
+			} else {
+				irc.sendReplyNotice(target, msgid, "blocked domains: "+strings.Join(displayHosts(blocked), ", "))
+			}
+		}
+		return
+	}
+	changed, err := irc.state.setBlockedDomain(f[1], block)
+	if block {
+		irc.replyListChange(target, msgid, changed, err, "blocked "+f[1], f[1]+" was already blocked")
+	} else {
+		irc.replyListChange(target, msgid, changed, err, "unblocked "+f[1], f[1]+" wasn't blocked at runtime")
+	}
+}
+
+// handleAllowCommand handles `allow <#channel> [<domain>]` and `disallow <#channel> <domain>`;
+// once a channel has allowed domains, only links to those domains are titled there
+func (irc *Bot) handleAllowCommand(target, msgid string, f []string) {
+	allow := strings.ToLower(f[0]) == "allow"
+	if len(f) < 2 {
+		return
+	}
+	channel := f[1]
+	if len(f) < 3 {
+		if allow {
+			allowed := irc.state.getAllowedDomains(channel)
+			if len(allowed) == 0 {
+				irc.sendReplyNotice(target, msgid, fmt.Sprintf("all domains are allowed in %s", channel))
+			} else {
+				irc.sendReplyNotice(target, msgid, fmt.Sprintf("allowed domains in %s: %s", channel, strings.Join(displayHosts(allowed), ", ")))
+			}
+		}
+		return
+	}
+	changed, err := irc.state.setAllowedDomain(channel, f[2], allow)
+	if allow {
+		irc.replyListChange(target, msgid, changed, err,
+			fmt.Sprintf("allowed %s in %s", f[2], channel), fmt.Sprintf("%s was already allowed in %s", f[2], channel))
+	} else {
+		irc.replyListChange(target, msgid, changed, err,
+			fmt.Sprintf("disallowed %s in %s", f[2], channel), fmt.Sprintf("%s wasn't allowed in %s", f[2], channel))
+	}
+}
+
+// autoTitles checks whether we should title links posted in target
+// without being asked to
+func (irc *Bot) autoTitles(target string) bool {
+	settings := irc.state.getChannelSettings(target)
+	return !settings.Disabled && !settings.Passive
+}
+
+// handleChannelCommand handles commands that channel operators (and
+// channel managers) can use to control the bot in their own channel
+func (irc *Bot) handleChannelCommand(e ircmsg.Message, channel, msgid string, level permLevel, f []string) (handled bool) {
+	var update func(*channelSettings)
+	var reply string
+	var clearCache, stats bool
+	switch strings.ToLower(f[0]) {
+	case "enable":
+		update = func(s *channelSettings) { s.Disabled = false }
+		reply = "titling enabled in " + channel
+	case "disable":
+		update = func(s *channelSettings) { s.Disabled = true }
+		reply = "titling disabled in " + channel
+	case "passive":
+		passive := len(f) < 2 || strings.ToLower(f[1]) != "off"
+		update = func(s *channelSettings) { s.Passive = passive }
+		if passive {
+			reply = fmt.Sprintf("passive mode enabled in %s: say `%s: title <url>` to title a link", channel, irc.CurrentNick())
+		} else {
+			reply = "passive mode disabled in " + channel
+		}
+	case "linkblog":
+		linkblog := len(f) < 2 || strings.ToLower(f[1]) != "off"
+		update = func(s *channelSettings) { s.Linkblog = linkblog }
+		if linkblog {
+			reply = "linkblog enabled for " + channel
+			if url := irc.linkblogURL(channel); url != "" {
+				reply += ": " + url
+			}
+		} else {
+			reply = "linkblog disabled for " + channel
+		}
+	case "baredomains":
+		bareDomains := len(f) < 2 || strings.ToLower(f[1]) != "off"
+		update = func(s *channelSettings) { s.BareDomains = bareDomains }
+		if bareDomains {
+			reply = "links without http:// or https:// (like example.com/foo) will be titled in " + channel
+		} else {
+			reply = "links without http:// or https:// will no longer be titled in " + channel
+		}
+	case "errors":
+		errors := len(f) < 2 || strings.ToLower(f[1]) != "off"
+		update = func(s *channelSettings) { s.Errors = errors }
+		if errors {
+			reply = "links that can't be titled will get a brief error in " + channel
+		} else {
+			reply = "links that can't be titled will be ignored in " + channel
+		}
+	case "sitename":
+		siteName := len(f) < 2 || strings.ToLower(f[1]) != "off"
+		update = func(s *channelSettings) { s.SiteName = siteName }
+		if siteName {
+			reply = "titles will start with the name of the site (e.g. [The Verge]) in " + channel
+		} else {
+			reply = "titles will no longer start with the name of the site in " + channel
+		}
+	case "clearcache":
+		clearCache = true
+	case "stats":
+		stats = true
+	default:
+		return false
+	}
+	if level < permChannelManager && (relayedSender(e) || !irc.isChannelOperator(channel, e.Nick())) {
+		irc.sendReplyNotice(channel, msgid, "only channel operators can do that")
+		return true
+	}
+	if stats {
+		irc.sendReplyNotice(channel, msgid, irc.stats.summary())
+		return true
+	}
+	if clearCache {
+		irc.messageLinks.clear()
+		irc.sendReplyNotice(channel, msgid, "cleared the cache of recently seen links")
+		return true
+	}
+	if irc.reportErr(irc.state.updateChannelSettings(channel, update), "couldn't save channel settings", "channel", channel) {
+		irc.sendReplyNotice(channel, msgid, "sorry, something went wrong")
+	} else {
+		irc.sendReplyNotice(channel, msgid, reply)
+	}
+	return true
+}
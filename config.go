@@ -0,0 +1,229 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// buildVersion can be set at build time with
+// go build -ldflags "-X main.buildVersion=v1.2.3"
+var buildVersion string
+
+func titlebotVersion() string {
+	version := buildVersion
+	if version == "" {
+		version = "(unknown)"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			version = info.Main.Version
+		}
+	}
+	return fmt.Sprintf("titlebot %s (%s)", version, runtime.Version())
+}
+
+// parseFlags handles the command-line flags; everything else is configured
+// with environment variables, which -check-config validates
+func parseFlags() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExportCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	checkConfig := flag.Bool("check-config", false, "check the configuration in the environment and exit (nonzero if there are problems)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-version] [-check-config]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s export -channel <#channel> [-format csv|json] [-since YYYY-MM-DD] [-until YYYY-MM-DD] [-o file]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "titlebot is configured with TITLEBOT_* environment variables; see the README.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *showVersion {
+		fmt.Println(titlebotVersion())
+		os.Exit(0)
+	}
+	problems := configProblems()
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "config error: %s\n", problem)
+	}
+	if len(problems) != 0 {
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Println("config OK")
+		os.Exit(0)
+	}
+}
+
+// configProblems checks the environment variables for mistakes that would
+// otherwise only show up after connecting (or not at all)
+func configProblems() (problems []string) {
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	nick := os.Getenv("TITLEBOT_NICK")
+	if nick == "" {
+		problem("TITLEBOT_NICK is required")
+	} else if strings.ContainsAny(nick, " ,*?!@:") {
+		problem("invalid TITLEBOT_NICK %q", nick)
+	}
+	if altNick := os.Getenv("TITLEBOT_ALT_NICK"); strings.ContainsAny(altNick, " ,*?!@:") {
+		problem("invalid TITLEBOT_ALT_NICK %q", altNick)
+	}
+	if server := os.Getenv("TITLEBOT_SERVER"); server == "" {
+		problem("TITLEBOT_SERVER is required")
+	} else if _, port, err := net.SplitHostPort(server); err != nil || port == "" {
+		problem("invalid TITLEBOT_SERVER %q: must be host:port", server)
+	}
+	channels := parseChannelList(os.Getenv("TITLEBOT_CHANNELS"))
+	if len(channels) == 0 {
+		problem("TITLEBOT_CHANNELS is required")
+	}
+	for _, variable := range []string{"TITLEBOT_CHANNELS", "TITLEBOT_NOTICE_CHANNELS", "TITLEBOT_NSFW_SUPPRESS_CHANNELS"} {
+		for _, channel := range parseChannelList(os.Getenv(variable)) {
+			if !strings.HasPrefix(channel, "#") || strings.ContainsAny(channel, " \x07") {
+				problem("invalid channel %q in %s: channels are comma-delimited and start with #", channel, variable)
+			}
+		}
+	}
+	if (os.Getenv("TITLEBOT_SASL_LOGIN") == "") != (os.Getenv("TITLEBOT_SASL_PASSWORD") == "") {
+		problem("TITLEBOT_SASL_LOGIN and TITLEBOT_SASL_PASSWORD must be set together")
+	}
+	if jid := os.Getenv("TITLEBOT_XMPP_JID"); jid != "" {
+		if user, domain, _ := strings.Cut(jid, "@"); user == "" || domain == "" || strings.Contains(domain, "/") {
+			problem("invalid TITLEBOT_XMPP_JID %q: must be user@domain", jid)
+		}
+		if os.Getenv("TITLEBOT_XMPP_PASSWORD") == "" {
+			problem("TITLEBOT_XMPP_PASSWORD is required with TITLEBOT_XMPP_JID")
+		}
+		for _, room := range parseChannelList(os.Getenv("TITLEBOT_XMPP_ROOMS")) {
+			if !strings.Contains(room, "@") || strings.Contains(room, "/") {
+				problem("invalid room %q in TITLEBOT_XMPP_ROOMS: must be a room JID like room@conference.example.com", room)
+			}
+		}
+	}
+	if pubsubURL := os.Getenv("TITLEBOT_PUBSUB_URL"); pubsubURL != "" {
+		if _, err := newPubSub(pubsubURL, ""); err != nil {
+			problem("invalid TITLEBOT_PUBSUB_URL: %v", err)
+		}
+	}
+	if archive := os.Getenv("TITLEBOT_ARCHIVE"); archive != "" && !strings.EqualFold(archive, "wayback") {
+		if u, err := url.Parse(archive); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("invalid TITLEBOT_ARCHIVE %q: must be wayback or the URL of an ArchiveBox instance", archive)
+		}
+	}
+	if kind := os.Getenv("TITLEBOT_SHORTENER"); kind != "" {
+		if _, err := newShortener(kind, os.Getenv("TITLEBOT_SHORTENER_URL"), "", defaultShortenLength); err != nil {
+			problem("invalid TITLEBOT_SHORTENER or TITLEBOT_SHORTENER_URL: %v", err)
+		}
+	}
+	if service := os.Getenv("TITLEBOT_READLATER"); service != "" {
+		if _, err := newReadLaterAccount(service, os.Getenv("TITLEBOT_READLATER_URL"), os.Getenv("TITLEBOT_READLATER_TOKEN")); err != nil {
+			problem("invalid TITLEBOT_READLATER: %v", err)
+		}
+	}
+	if tz := os.Getenv("TITLEBOT_TIMEZONE"); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			problem("invalid TITLEBOT_TIMEZONE %q: must be an IANA time zone like Europe/Berlin", tz)
+		}
+	}
+	if gateway := os.Getenv("TITLEBOT_IPFS_GATEWAY"); gateway != "" {
+		if u, err := url.Parse(gateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("invalid TITLEBOT_IPFS_GATEWAY %q: must be an http or https URL", gateway)
+		}
+	}
+	if _, err := parseSchemes(os.Getenv("TITLEBOT_SCHEMES")); err != nil {
+		problem("invalid TITLEBOT_SCHEMES: %v", err)
+	}
+	if _, err := parseURLPatterns(os.Getenv("TITLEBOT_URL_PATTERNS")); err != nil {
+		problem("invalid TITLEBOT_URL_PATTERNS: %v", err)
+	}
+	if _, err := parseTitleCleanup(os.Getenv("TITLEBOT_TITLE_CLEANUP")); err != nil {
+		problem("invalid TITLEBOT_TITLE_CLEANUP: %v", err)
+	}
+	if _, err := parseACL(os.Getenv("TITLEBOT_ACL")); err != nil {
+		problem("invalid TITLEBOT_ACL: %v", err)
+	}
+	if level := os.Getenv("TITLEBOT_LOG_LEVEL"); level != "" {
+		if _, ok := parseLogLevel(level); !ok {
+			problem("invalid TITLEBOT_LOG_LEVEL %q: must be one of %s", level, strings.Join(logLevelNames, ", "))
+		}
+	}
+	if format := os.Getenv("TITLEBOT_LOG_FORMAT"); format != "" && !strings.EqualFold(format, "text") && !strings.EqualFold(format, "json") {
+		problem("invalid TITLEBOT_LOG_FORMAT %q: must be text or json", format)
+	}
+	if dsn := os.Getenv("TITLEBOT_SENTRY_DSN"); dsn != "" {
+		if _, err := sentry.NewDsn(dsn); err != nil {
+			problem("invalid TITLEBOT_SENTRY_DSN: %v", err)
+		}
+	}
+	if listen := os.Getenv("TITLEBOT_HTTP_LISTEN"); listen != "" {
+		if _, _, err := net.SplitHostPort(listen); err != nil {
+			problem("invalid TITLEBOT_HTTP_LISTEN %q: must be host:port", listen)
+		}
+	}
+	for _, variable := range []string{"TITLEBOT_RELAY_NICKS", "TITLEBOT_IGNORE_NICKS"} {
+		if re := os.Getenv(variable); re != "" {
+			if _, err := regexp.Compile(re); err != nil {
+				problem("invalid %s: %v", variable, err)
+			}
+		}
+	}
+	for _, variable := range []string{"TITLEBOT_LOG_MAX_SIZE", "TITLEBOT_LOG_MAX_BACKUPS", "TITLEBOT_CHANNEL_RATE_LIMIT",
+		"TITLEBOT_CHANNEL_BURST", "TITLEBOT_PRIVMSG_RATE_LIMIT", "TITLEBOT_ALERT_CONSECUTIVE_FAILURES", "TITLEBOT_ALERT_DOMAIN_ERRORS",
+		"TITLEBOT_SHORTEN_LENGTH", "TITLEBOT_HTTP_MAX_CONNS_PER_HOST", "TITLEBOT_HTTP_MAX_IDLE_PER_HOST", "TITLEBOT_TLS_SESSION_CACHE",
+		"TITLEBOT_HTTP_MAX_HEADER_BYTES", "TITLEBOT_HTTP_MAX_HEADERS", "TITLEBOT_SEND_BURST"} {
+		if value := os.Getenv(variable); value != "" {
+			if _, err := strconv.Atoi(value); err != nil {
+				problem("invalid %s %q: must be an integer", variable, value)
+			}
+		}
+	}
+	if rate := os.Getenv("TITLEBOT_SEND_RATE"); rate != "" {
+		if _, err := strconv.ParseFloat(rate, 64); err != nil {
+			problem("invalid TITLEBOT_SEND_RATE %q: must be a number", rate)
+		}
+	}
+	if os.Getenv("TITLEBOT_RECHECK_AFTER") != "" && os.Getenv("TITLEBOT_DATABASE") == "" {
+		problem("TITLEBOT_RECHECK_AFTER needs TITLEBOT_DATABASE (links are rechecked from the history)")
+	}
+	if notify := os.Getenv("TITLEBOT_RECHECK_NOTIFY"); notify != "" && !strings.EqualFold(notify, "channel") && !strings.EqualFold(notify, "alerts") {
+		problem("invalid TITLEBOT_RECHECK_NOTIFY %q: must be channel or alerts", notify)
+	}
+	if http2 := os.Getenv("TITLEBOT_HTTP2"); http2 != "" && http2 != "0" && http2 != "1" {
+		problem("invalid TITLEBOT_HTTP2 %q: must be 0 or 1", http2)
+	}
+	if standby := os.Getenv("TITLEBOT_HA_STANDBY"); standby != "" && !strings.EqualFold(standby, "quiet") && !strings.EqualFold(standby, "disconnected") {
+		problem("invalid TITLEBOT_HA_STANDBY %q: must be quiet or disconnected", standby)
+	}
+	for _, variable := range []string{"TITLEBOT_LOG_MAX_AGE", "TITLEBOT_BLOCKLIST_REFRESH", "TITLEBOT_RECONNECT_MAX_DELAY", "TITLEBOT_HA_TIMEOUT",
+		"TITLEBOT_ARCHIVE_INTERVAL", "TITLEBOT_HTTP_IDLE_TIMEOUT", "TITLEBOT_HTTP_HEADER_TIMEOUT", "TITLEBOT_RECHECK_AFTER"} {
+		if value := os.Getenv(variable); value != "" {
+			if _, err := time.ParseDuration(value); err != nil {
+				problem("invalid %s %q: must be a duration like 30s or 24h", variable, value)
+			}
+		}
+	}
+	return
+}
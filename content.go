@@ -0,0 +1,177 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+// friendly names for common types of content that isn't a web page
+var contentTypeNames = map[string]string{
+	"image/jpeg":                            "JPEG image",
+	"image/png":                             "PNG image",
+	"image/gif":                             "GIF image",
+	"image/webp":                            "WebP image",
+	"image/avif":                            "AVIF image",
+	"image/svg+xml":                         "SVG image",
+	"image/bmp":                             "BMP image",
+	"image/tiff":                            "TIFF image",
+	"video/mp4":                             "MP4 video",
+	"video/webm":                            "WebM video",
+	"video/x-matroska":                      "Matroska video",
+	"video/quicktime":                       "QuickTime video",
+	"audio/mpeg":                            "MP3 audio",
+	"audio/ogg":                             "Ogg audio",
+	"audio/flac":                            "FLAC audio",
+	"audio/x-flac":                          "FLAC audio",
+	"audio/wav":                             "WAV audio",
+	"audio/x-wav":                           "WAV audio",
+	"audio/mp4":                             "M4A audio",
+	"audio/mp3":                             "MP3 audio",
+	"audio/opus":                            "Opus audio",
+	"audio/vorbis":                          "Vorbis audio",
+	"application/ogg":                       "Ogg audio",
+	"text/calendar":                         "iCalendar file",
+	"application/x-bittorrent":              "torrent file",
+	"application/pdf":                       "PDF document",
+	"application/zip":                       "ZIP archive",
+	"application/gzip":                      "gzip archive",
+	"application/x-gzip":                    "gzip archive",
+	"application/x-tar":                     "tar archive",
+	"application/x-7z-compressed":           "7z archive",
+	"application/vnd.rar":                   "RAR archive",
+	"application/x-rar-compressed":          "RAR archive",
+	"application/x-xz":                      "xz archive",
+	"application/x-bzip2":                   "bzip2 archive",
+	"application/octet-stream":              "binary file",
+	"application/x-iso9660-image":           "disk image",
+	"application/x-msdownload":              "Windows executable",
+	"application/vnd.debian.binary-package": "Debian package",
+	"application/x-rpm":                     "RPM package",
+}
+
+// isPageType checks whether a media type is something we look for a title in
+func isPageType(mediaType string) bool {
+	switch {
+	case mediaType == "text/calendar":
+		return false
+	case mediaType == "", strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/xhtml+xml", mediaType == "application/xml", mediaType == "application/json":
+		return true
+	default:
+		return false
+	}
+}
+
+// describeContentType names a media type that isn't a web page, e.g. "JPEG image"
+func describeContentType(mediaType string) string {
+	if name, ok := contentTypeNames[mediaType]; ok {
+		return name
+	}
+	major, minor, _ := strings.Cut(mediaType, "/")
+	minor = strings.TrimPrefix(strings.TrimPrefix(minor, "x-"), "vnd.")
+	switch major {
+	case "image", "video", "audio":
+		return fmt.Sprintf("%s %s", strings.ToUpper(minor), major)
+	default:
+		return mediaType + " file"
+	}
+}
+
+// formatSize formats a size in bytes, e.g. "2.4 MB"
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	value := float64(size) / unit
+	for _, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		if value < unit || suffix == "TB" {
+			if value < 10 {
+				return fmt.Sprintf("%.1f %s", value, suffix)
+			}
+			return fmt.Sprintf("%.0f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return "" // unreachable
+}
+
+// dispositionFilename reads the filename from a Content-Disposition header,
+// decoding it if it's RFC 2231 (filename*=) or RFC 2047 (=?utf-8?...) encoded
+func dispositionFilename(header string) string {
+	if header == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	filename := params["filename"]
+	if strings.Contains(filename, "=?") {
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(filename); err == nil {
+			filename = decoded
+		}
+	}
+	// don't trust the server not to send a path
+	if i := strings.LastIndexAny(filename, `/\`); i != -1 {
+		filename = filename[i+1:]
+	}
+	return ircutils.SanitizeText(filename, titleCharLimit)
+}
+
+// describeContent describes a response that isn't a web page (e.g., a direct
+// link to an image or a download) by its type and size, since it has no title.
+// For some types, it reads up to byteLimit bytes of the body for details.
+func (irc *Bot) describeContent(url string, resp *http.Response, byteLimit int) (description string, ok bool) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	mediaType = strings.ToLower(mediaType)
+	if isPageType(mediaType) {
+		return "", false
+	}
+	details := []string{describeContentType(mediaType)}
+	switch mediaType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		head, _ := io.ReadAll(io.LimitReader(resp.Body, int64(byteLimit)))
+		if info, ok := describeImage(head); ok {
+			details = []string{info.String()}
+		}
+	case "audio/mpeg", "audio/mp3", "audio/flac", "audio/x-flac", "audio/ogg", "audio/opus", "audio/vorbis", "application/ogg":
+		head, _ := io.ReadAll(io.LimitReader(resp.Body, int64(byteLimit)))
+		if info, ok := describeAudio(head, resp.ContentLength); ok {
+			details = []string{info.String(), details[0]}
+		}
+	case "application/x-bittorrent":
+		head, _ := io.ReadAll(io.LimitReader(resp.Body, int64(byteLimit)))
+		if info, ok := parseTorrent(head); ok {
+			return info.String(), true
+		}
+	case "text/calendar":
+		head, _ := io.ReadAll(io.LimitReader(resp.Body, int64(byteLimit)))
+		if event, ok := parseICalendar(head, irc.timezone); ok {
+			// the size of an .ics file isn't interesting
+			return event.describe(irc.timezone), true
+		}
+	case "video/mp4", "video/quicktime", "video/webm", "video/x-matroska":
+		head, _ := io.ReadAll(io.LimitReader(resp.Body, int64(byteLimit)))
+		if info, ok := irc.describeVideo(url, head, byteLimit); ok {
+			details = append(details, info.String())
+		}
+	}
+	if resp.ContentLength > 0 {
+		details = append(details, formatSize(resp.ContentLength))
+	}
+	if filename := dispositionFilename(resp.Header.Get("Content-Disposition")); filename != "" {
+		details = append([]string{filename}, details...)
+	}
+	irc.logDebug("http", "describing content", "url", url, "type", mediaType)
+	return strings.Join(details, ", "), true
+}
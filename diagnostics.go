@@ -0,0 +1,118 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// handleDiagnosticSignal dumps the bot's internal state to the log on
+// SIGUSR1, for debugging a bot that has stopped responding
+func (irc *Bot) handleDiagnosticSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			irc.dumpDiagnostics()
+		}
+	}()
+}
+
+// dumpDiagnostics logs the connection state, title queue length, cache
+// sizes, and the stacks of all goroutines. It logs regardless of the log
+// level, since it was explicitly asked for.
+func (irc *Bot) dumpDiagnostics() {
+	irc.logger.Warn("diagnostic dump",
+		"connected", irc.Connected(),
+		"server", irc.Server,
+		"nick", irc.CurrentNick(),
+		"preferredNick", irc.PreferredNick(),
+		"channels", len(irc.channels.list()),
+		"uptime", time.Since(irc.stats.started).Round(time.Second).String(),
+		"shuttingDown", irc.ctx.Err() != nil,
+		"goroutines", runtime.NumGoroutine(),
+		"titleQueue", fmt.Sprintf("%d/%d", len(irc.titleQueue), cap(irc.titleQueue)),
+		"priorityQueue", fmt.Sprintf("%d/%d", len(irc.priorityQueue), cap(irc.priorityQueue)),
+		"bufferPool", fmt.Sprintf("%d/%d hits", bodyBuffers.hits.Load(), bodyBuffers.gets.Load()),
+		"messageLinks", irc.messageLinks.size(),
+		"lastSeen", irc.lastSeenSize(),
+		"readLimitDomains", irc.readLimits.size(),
+		"alertDomains", irc.failures.size(),
+		"privmsgLimiterKeys", irc.privmsgLimiter.size(),
+		"floodControlChannels", irc.floodControl.size(),
+		"sendQueue", irc.sendQueueSize(),
+		"blocklistDomains", irc.remoteBlocklist.size(),
+	)
+	irc.logger.Warn("goroutine stacks", "stacks", string(goroutineStacks()))
+}
+
+// goroutineStacks returns the stack traces of all goroutines
+func goroutineStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func (m *messageLinks) size() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.entries)
+}
+
+func (irc *Bot) lastSeenSize() int {
+	irc.lastSeenMutex.Lock()
+	defer irc.lastSeenMutex.Unlock()
+	return len(irc.lastSeen)
+}
+
+func (a *adaptiveReadLimits) size() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.domains)
+}
+
+func (f *failureTracker) size() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.domainErrors)
+}
+
+func (r *rateLimiter) size() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.events)
+}
+
+func (f *floodControl) size() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.buckets)
+}
+
+func (b *remoteBlocklist) size() int {
+	if b == nil {
+		return 0
+	}
+	b.RLock()
+	defer b.RUnlock()
+	return len(b.domains)
+}
+
+func (irc *Bot) sendQueueSize() string {
+	if irc.sendQueue == nil {
+		return "disabled"
+	}
+	waiting, dropped := irc.sendQueue.size()
+	return fmt.Sprintf("%d waiting, %d dropped", waiting, dropped)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// in channels with the errors setting, links that can't be titled get
+// a brief explanation (e.g. "⚠ 404 Not Found") so that users can tell
+// a dead link from one the bot ignored
+const errorPrefix = "⚠ "
+
+// errorSummary explains a failure to title a link, from its category (see
+// stats.go) and the handler's detail, if any. Links that were fetched but
+// had no title, or that we couldn't even request, get "".
+func errorSummary(failure, detail string) string {
+	if detail != "" {
+		return detail
+	}
+	switch failure {
+	case errNetwork:
+		return "couldn't connect"
+	case errStatus:
+		return "the server returned an error"
+	case errRead:
+		return "couldn't read the page"
+	case errParse:
+		return "the server sent a bad response"
+	}
+	return ""
+}
+
+// describeStatus describes an HTTP error status, e.g. "404 Not Found"
+// (using the standard reason, not the server's)
+func describeStatus(code int) string {
+	if text := http.StatusText(code); text != "" {
+		return fmt.Sprintf("%d %s", code, text)
+	}
+	return fmt.Sprintf("HTTP error %d", code)
+}
+
+// describeNetworkError describes why a request failed, e.g. "connection
+// timed out", without the details (addresses etc.) in the error itself
+func describeNetworkError(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var hostnameErr x509.HostnameError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var invalidCertErr x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "" // shutting down
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return "domain not found"
+	case errors.As(err, &dnsErr):
+		return "couldn't look up the domain"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "host unreachable"
+	case errors.As(err, &certErr), errors.As(err, &hostnameErr), errors.As(err, &unknownAuthorityErr), errors.As(err, &invalidCertErr):
+		return "invalid TLS certificate"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "connection timed out"
+	}
+	return "couldn't connect"
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const exportDateFormat = "2006-01-02"
+
+// exportedLink is a history entry as exported to JSON
+type exportedLink struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	Nick    string    `json:"nick"`
+	Account string    `json:"account,omitempty"`
+	URL     string    `json:"url"`
+	Title   string    `json:"title"`
+	Archive string    `json:"archive_url,omitempty"`
+}
+
+// export returns the links posted in channel between since and until
+// (either of which can be zero, for no limit), oldest first
+func (h *historyStore) export(channel string, since, until time.Time) ([]historyEntry, error) {
+	var sinceNanos, untilNanos int64 = 0, 1<<63 - 1
+	if !since.IsZero() {
+		sinceNanos = since.UnixNano()
+	}
+	if !until.IsZero() {
+		untilNanos = until.UnixNano()
+	}
+	rows, err := h.db.Query(`SELECT url, title, nick, account, time, archive_url FROM links
+		WHERE channel = ? AND time >= ? AND time < ? ORDER BY time`,
+		strings.ToLower(channel), sinceNanos, untilNanos)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanHistory(rows, channel)
+}
+
+// parseExportDates parses the optional date range of an export: links from
+// the start of `since` up to the end of `until` (both YYYY-MM-DD, in UTC)
+func parseExportDates(since, until string) (sinceTime, untilTime time.Time, err error) {
+	if since != "" {
+		if sinceTime, err = time.Parse(exportDateFormat, since); err != nil {
+			return
+		}
+	}
+	if until != "" {
+		if untilTime, err = time.Parse(exportDateFormat, until); err != nil {
+			return
+		}
+		untilTime = untilTime.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// writeExport writes history entries as CSV or JSON
+func writeExport(w io.Writer, format string, entries []historyEntry) error {
+	switch format {
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write([]string{"time", "channel", "nick", "account", "url", "title", "archive_url"})
+		for _, entry := range entries {
+			out.Write([]string{entry.time.UTC().Format(time.RFC3339), entry.channel, entry.nick, entry.account, entry.url, entry.title, entry.archiveURL})
+		}
+		out.Flush()
+		return out.Error()
+	case "json":
+		links := make([]exportedLink, len(entries))
+		for i, entry := range entries {
+			links[i] = exportedLink{
+				Time:    entry.time.UTC(),
+				Channel: entry.channel,
+				Nick:    entry.nick,
+				Account: entry.account,
+				URL:     entry.url,
+				Title:   entry.title,
+				Archive: entry.archiveURL,
+			}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		return encoder.Encode(links)
+	default:
+		return fmt.Errorf("unknown format %s: must be csv or json", format)
+	}
+}
+
+// handleExportCommand handles `export <#channel> <csv|json> [since] [until]`,
+// writing the channel's link history to a file in TITLEBOT_EXPORT_DIR
+func (irc *Bot) handleExportCommand(target, msgid string, f []string) {
+	if len(f) < 3 {
+		irc.sendReplyNotice(target, msgid, "usage: export <#channel> <csv|json> [since YYYY-MM-DD] [until YYYY-MM-DD]")
+		return
+	}
+	if irc.history == nil || irc.exportDir == "" {
+		irc.sendReplyNotice(target, msgid, "exports are disabled (they need TITLEBOT_DATABASE and TITLEBOT_EXPORT_DIR)")
+		return
+	}
+	channel, format := f[1], strings.ToLower(f[2])
+	if format != "csv" && format != "json" {
+		irc.sendReplyNotice(target, msgid, "format must be csv or json")
+		return
+	}
+	var since, until string
+	if len(f) > 3 {
+		since = f[3]
+	}
+	if len(f) > 4 {
+		until = f[4]
+	}
+	sinceTime, untilTime, err := parseExportDates(since, until)
+	if err != nil {
+		irc.sendReplyNotice(target, msgid, "dates must be YYYY-MM-DD")
+		return
+	}
+	entries, err := irc.history.export(channel, sinceTime, untilTime)
+	if irc.reportErr(err, "couldn't read link history", "channel", channel) {
+		irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s.%s", strings.Trim(strings.ToLower(channel), "#"), time.Now().UTC().Format("20060102-150405"), format)
+	path := filepath.Join(irc.exportDir, filepath.Base(name))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err == nil {
+		err = writeExport(file, format, entries)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if irc.reportErr(err, "couldn't write export", "path", path) {
+		irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		return
+	}
+	irc.sendReplyNotice(target, msgid, fmt.Sprintf("exported %d links from %s to %s", len(entries), channel, path))
+}
+
+// runExportCommand implements `titlebot export`, which writes a channel's
+// link history from TITLEBOT_DATABASE to stdout (or a file)
+func runExportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	channel := flags.String("channel", "", "the channel to export (required)")
+	format := flags.String("format", "csv", "csv or json")
+	since := flags.String("since", "", "only export links posted on or after this date (YYYY-MM-DD)")
+	until := flags.String("until", "", "only export links posted on or before this date (YYYY-MM-DD)")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	flags.Parse(args)
+
+	if *channel == "" {
+		flags.Usage()
+		return errors.New("-channel is required")
+	}
+	dbPath := os.Getenv("TITLEBOT_DATABASE")
+	if dbPath == "" {
+		return errors.New("TITLEBOT_DATABASE is required")
+	}
+	sinceTime, untilTime, err := parseExportDates(*since, *until)
+	if err != nil {
+		return fmt.Errorf("invalid date: %w", err)
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	history, err := newHistoryStore(db)
+	if err != nil {
+		return err
+	}
+	entries, err := history.export(*channel, sinceTime, untilTime)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return writeExport(os.Stdout, strings.ToLower(*format), entries)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = writeExport(file, strings.ToLower(*format), entries)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
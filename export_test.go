@@ -0,0 +1,38 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExportDates(t *testing.T) {
+	cases := []struct {
+		since, until                 string
+		expectedSince, expectedUntil time.Time
+		err                          bool
+	}{
+		{"", "", time.Time{}, time.Time{}, false},
+		{"2024-05-01", "", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Time{}, false},
+		// until is inclusive
+		{"", "2024-05-31", time.Time{}, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-05-01", "2024-12-31", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"yesterday", "", time.Time{}, time.Time{}, true},
+		{"", "2024-13-01", time.Time{}, time.Time{}, true},
+		{"2024/05/01", "", time.Time{}, time.Time{}, true},
+	}
+	for _, c := range cases {
+		since, until, err := parseExportDates(c.since, c.until)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseExportDates(%q, %q) should have failed", c.since, c.until)
+			}
+			continue
+		}
+		if err != nil || !since.Equal(c.expectedSince) || !until.Equal(c.expectedUntil) {
+			t.Errorf("parseExportDates(%q, %q) = %v, %v, %v; expected %v, %v", c.since, c.until, since, until, err, c.expectedSince, c.expectedUntil)
+		}
+	}
+}
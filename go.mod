@@ -1,5 +1,23 @@
 module github.com/slingamn/titlebot
 
-go 1.16
+go 1.22
 
-require github.com/ergochat/irc-go v0.3.0
+require (
+	github.com/ergochat/irc-go v0.3.0
+	github.com/getsentry/sentry-go v0.35.3
+	golang.org/x/net v0.33.0
+	modernc.org/sqlite v1.34.5
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+)
@@ -0,0 +1,165 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+const gopherTimeout = 15 * time.Second
+
+var errGopherAddress = errors.New("refusing to connect to a non-public address")
+
+// isGopher checks whether a link is a gopher:// URL
+func isGopher(urlStr string) bool {
+	return len(urlStr) >= 9 && strings.EqualFold(urlStr[:9], "gopher://")
+}
+
+// parseGopherURL splits a gopher URL (RFC 4266) into the address to
+// connect to, the item type, and the selector to send
+func parseGopherURL(urlStr string) (address string, itemType byte, selector string, err error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return
+	}
+	if u.Hostname() == "" {
+		return "", 0, "", fmt.Errorf("no host in gopher URL")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "70"
+	}
+	address = net.JoinHostPort(u.Hostname(), port)
+	// the path is /<item type><selector>; the root is a menu
+	itemType = '1'
+	if len(u.Path) >= 2 {
+		itemType = u.Path[1]
+		selector = u.Path[2:]
+	}
+	// the selector is a single line, so these could smuggle in more
+	// (e.g., commands to some other protocol's server)
+	if strings.ContainsAny(selector, "\r\n\t") {
+		return "", 0, "", fmt.Errorf("invalid characters in gopher selector")
+	}
+	return
+}
+
+// gopherDialControl refuses connections to private, loopback, link-local,
+// and other non-public addresses, checking the address after resolution
+// so that a gopher link can't be used to reach internal services
+func gopherDialControl(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := addrPort.Addr().Unmap(); !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return errGopherAddress
+	}
+	return nil
+}
+
+func (irc *Bot) titleGopher(urlStr string) (title, failure string) {
+	address, itemType, selector, err := parseGopherURL(urlStr)
+	if irc.checkErr(err, "invalid URL", "url", urlStr) {
+		return "", errRequest
+	}
+	switch itemType {
+	case '0', '1', '7':
+	default:
+		// binary files, images, etc. have nothing to title
+		irc.logDebug("http", "can't title: gopher item type", "url", urlStr, "type", string(itemType))
+		return "", errNoTitle
+	}
+
+	dialer := net.Dialer{Timeout: gopherTimeout, Control: gopherDialControl}
+	conn, err := dialer.DialContext(irc.ctx, "tcp", address)
+	if irc.checkErr(err, "gopher error", "url", urlStr) {
+		return "", errNetwork
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(gopherTimeout))
+	if _, err := io.WriteString(conn, selector+"\r\n"); irc.checkErr(err, "gopher error", "url", urlStr) {
+		return "", errNetwork
+	}
+
+	body := io.LimitReader(conn, int64(irc.settings.get().readLimit))
+	if itemType == '0' {
+		title = gopherTextTitle(body)
+	} else {
+		title = gopherMenuTitle(body)
+	}
+	if title == "" {
+		irc.logDebug("http", "can't title: empty gopher item", "url", urlStr)
+		return "", errNoTitle
+	}
+	return title, ""
+}
+
+// gopherTextTitle returns the first non-empty line of a text item
+func gopherTextTitle(body io.Reader) string {
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "." {
+			break // end of the item
+		}
+		if line != "" {
+			return ircutils.SanitizeText(line, titleCharLimit)
+		}
+	}
+	return ""
+}
+
+// gopherMenuTitle describes a menu by its first heading (the first
+// informational line) and how many items it links to
+func gopherMenuTitle(body io.Reader) string {
+	var heading string
+	items := 0
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "." {
+			break
+		}
+		if line == "" {
+			continue
+		}
+		display, _, _ := strings.Cut(line[1:], "\t")
+		switch line[0] {
+		case 'i':
+			if heading == "" {
+				heading = strings.TrimSpace(display)
+			}
+		case '3':
+			// error
+			if items == 0 && heading == "" {
+				return ""
+			}
+		default:
+			items++
+		}
+	}
+	heading = ircutils.SanitizeText(heading, titleCharLimit)
+	switch {
+	case heading != "" && items != 0:
+		return fmt.Sprintf("Gopher: %s (%d items)", heading, items)
+	case heading != "":
+		return "Gopher: " + heading
+	case items != 0:
+		return fmt.Sprintf("Gopher menu, %d items", items)
+	default:
+		return ""
+	}
+}
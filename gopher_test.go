@@ -0,0 +1,61 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseGopherURL(t *testing.T) {
+	cases := []struct {
+		url      string
+		address  string
+		itemType byte
+		selector string
+		err      bool
+	}{
+		{"gopher://example.com", "example.com:70", '1', "", false},
+		{"gopher://example.com:7070/0/about.txt", "example.com:7070", '0', "/about.txt", false},
+		{"gopher://example.com/1/menu%20two", "example.com:70", '1', "/menu two", false},
+		{"gopher:///0/about.txt", "", 0, "", true},
+		{"gopher://example.com:6379/0%0d%0aFLUSHALL", "", 0, "", true},
+		{"gopher://example.com/0foo%0abar", "", 0, "", true},
+		{"gopher://example.com/7search%09query", "", 0, "", true},
+	}
+	for _, c := range cases {
+		address, itemType, selector, err := parseGopherURL(c.url)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseGopherURL(%q) should have failed", c.url)
+			}
+		} else if err != nil || address != c.address || itemType != c.itemType || selector != c.selector {
+			t.Errorf("parseGopherURL(%q) = %q, %q, %q, %v", c.url, address, itemType, selector, err)
+		}
+	}
+}
+
+func TestGopherDialControl(t *testing.T) {
+	cases := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:70", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:70", true},
+		{"127.0.0.1:6379", false},
+		{"[::1]:70", false},
+		{"10.1.2.3:70", false},
+		{"172.16.0.1:25", false},
+		{"192.168.1.1:70", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:70", false},
+		{"[fd00::1]:70", false},
+		{"[::ffff:127.0.0.1]:70", false},
+		{"0.0.0.0:70", false},
+	}
+	for _, c := range cases {
+		if err := gopherDialControl("tcp", c.address, nil); (err == nil) != c.allowed {
+			t.Errorf("gopherDialControl(%q) = %v, expected allowed=%t", c.address, err, c.allowed)
+		}
+	}
+}
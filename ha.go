@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHATimeout = time.Minute
+	// after claiming an expired lease, wait this long and check that
+	// another standby didn't claim it at the same time
+	haClaimSettle = 2 * time.Second
+)
+
+// haLeaseFile is the content of the lock file shared by the instances
+type haLeaseFile struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// haLease coordinates a primary and one or more standby instances through a
+// lease in a shared file (e.g., on a network filesystem). The primary renews
+// the lease while it's connected to IRC; if it stops renewing (because its
+// host died, or it's been disconnected for longer than the timeout), a
+// standby takes over. A standby is either connected but quiet, or
+// not connected at all until it takes over.
+type haLease struct {
+	path    string
+	owner   string
+	timeout time.Duration
+	quiet   bool // standby stays connected, rather than disconnected
+
+	active       atomic.Bool
+	activateOnce sync.Once
+	activated    chan empty // closed when we first become the primary
+	releaseOnce  sync.Once
+}
+
+func newHALease(path string, timeout time.Duration, quiet bool) *haLease {
+	hostname, _ := os.Hostname()
+	return &haLease{
+		path:      path,
+		owner:     fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		timeout:   timeout,
+		quiet:     quiet,
+		activated: make(chan empty),
+	}
+}
+
+func (l *haLease) read() (lease haLeaseFile, err error) {
+	data, err := os.ReadFile(l.path)
+	if err == nil {
+		err = json.Unmarshal(data, &lease)
+	}
+	return
+}
+
+// tryAcquire claims or renews the lease, if it's free, expired, or ours
+func (l *haLease) tryAcquire() (acquired bool, err error) {
+	current, err := l.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	now := time.Now()
+	if current.Owner != "" && current.Owner != l.owner && now.Before(current.Expires) {
+		return false, nil
+	}
+	data, err := json.Marshal(haLeaseFile{Owner: l.owner, Expires: now.Add(l.timeout)})
+	if err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(l.path, data); err != nil {
+		return false, err
+	}
+	if current.Owner != l.owner {
+		// the last write wins if two standbys claimed the lease at once
+		time.Sleep(haClaimSettle)
+		if confirmed, err := l.read(); err != nil || confirmed.Owner != l.owner {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// release gives up the lease, if we hold it, so that a standby can take
+// over immediately instead of waiting for it to expire
+func (l *haLease) release() {
+	l.releaseOnce.Do(func() {
+		if current, err := l.read(); err == nil && current.Owner == l.owner {
+			os.Remove(l.path)
+		}
+		l.active.Store(false)
+	})
+}
+
+// waitActive blocks until we become the primary
+func (l *haLease) waitActive() {
+	<-l.activated
+}
+
+// isActive checks whether we should respond to anything: always true
+// without a standby configuration, otherwise only while we're the primary
+func (irc *Bot) isActive() bool {
+	return irc.ha == nil || irc.ha.active.Load()
+}
+
+// haLoop renews the lease while we're the primary and healthy, and
+// tries to take it over while we're a standby
+func (irc *Bot) haLoop() {
+	ha := irc.ha
+	for ; ; time.Sleep(ha.timeout / 4) {
+		if irc.ctx.Err() != nil {
+			return
+		}
+		active := ha.active.Load()
+		if active && irc.liveness.disconnectedFor() > ha.timeout {
+			// stop renewing the lease, so a standby takes over
+			continue
+		}
+		acquired, err := ha.tryAcquire()
+		if err != nil {
+			irc.reportErr(err, "couldn't access the HA lock file", "path", ha.path)
+			continue
+		}
+		switch {
+		case acquired && !active:
+			irc.logInfo("acquired the HA lease, becoming the primary", "owner", ha.owner)
+			ha.active.Store(true)
+			ha.activateOnce.Do(func() { close(ha.activated) })
+		case !acquired && active:
+			ha.active.Store(false)
+			if ha.quiet {
+				irc.logError("lost the HA lease, becoming a standby", "owner", ha.owner)
+			} else {
+				// exit, so that we're restarted as a disconnected standby
+				irc.logError("lost the HA lease, shutting down", "owner", ha.owner)
+				go irc.shutdown()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	defaultLinksCount = 5
+	maxLinksCount     = 10
+	findResultsCount  = 5
+	topCount          = 5
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS links (
+	id INTEGER PRIMARY KEY,
+	channel TEXT NOT NULL,
+	url TEXT NOT NULL,
+	title TEXT NOT NULL,
+	nick TEXT NOT NULL,
+	account TEXT NOT NULL,
+	time INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS links_channel_time ON links (channel, time);
+`
+
+// full-text index of the URLs and titles, for the find command
+const historyFTSSchema = `
+CREATE VIRTUAL TABLE links_fts USING fts5(url, title, content='links', content_rowid='id');
+CREATE TRIGGER links_fts_insert AFTER INSERT ON links BEGIN
+	INSERT INTO links_fts (rowid, url, title) VALUES (new.id, new.url, new.title);
+END;
+CREATE TRIGGER links_fts_delete AFTER DELETE ON links BEGIN
+	INSERT INTO links_fts (links_fts, rowid, url, title) VALUES ('delete', old.id, old.url, old.title);
+END;
+-- index any history recorded before the index existed:
+INSERT INTO links_fts (links_fts) VALUES ('rebuild');
+`
+
+// historyEntry is a link posted in a channel, and its title (if we found one)
+type historyEntry struct {
+	channel string
+	url     string
+	title   string
+	nick    string
+	account string
+	time    time.Time
+	// archived copy of the URL, if TITLEBOT_ARCHIVE is enabled
+	archiveURL string
+}
+
+// historyStore is the link history, in a SQLite database
+type historyStore struct {
+	db *sql.DB
+}
+
+func newHistoryStore(db *sql.DB) (*historyStore, error) {
+	if _, err := db.Exec(historySchema); err != nil {
+		return nil, err
+	}
+	if err := addColumnIfMissing(db, "links", "archive_url", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+	// whether the link has been rechecked (see recheck.go)
+	if err := addColumnIfMissing(db, "links", "rechecked", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	var hasFTS int
+	err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'links_fts'`).Scan(&hasFTS)
+	if err == nil && hasFTS == 0 {
+		_, err = db.Exec(historyFTSSchema)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &historyStore{db: db}, nil
+}
+
+// add records a link, returning its ID
+func (h *historyStore) add(entry historyEntry) (id int64, err error) {
+	result, err := h.db.Exec(`INSERT INTO links (channel, url, title, nick, account, time) VALUES (?, ?, ?, ?, ?, ?)`,
+		strings.ToLower(entry.channel), entry.url, entry.title, entry.nick, entry.account, entry.time.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// setArchiveURL records the archived copy of the link with the given ID
+func (h *historyStore) setArchiveURL(id int64, archiveURL string) error {
+	_, err := h.db.Exec(`UPDATE links SET archive_url = ? WHERE id = ?`, archiveURL, id)
+	return err
+}
+
+// lastArchiveURL returns the most recent archived copy of url since `since`, if any
+func (h *historyStore) lastArchiveURL(url string, since time.Time) (archiveURL string, err error) {
+	err = h.db.QueryRow(`SELECT archive_url FROM links WHERE url = ? AND archive_url != '' AND time >= ?
+		ORDER BY time DESC LIMIT 1`, url, since.UnixNano()).Scan(&archiveURL)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+// recent returns the last `limit` links posted in channel, oldest first
+func (h *historyStore) recent(channel string, limit int) (result []historyEntry, err error) {
+	rows, err := h.db.Query(`SELECT url, title, nick, account, time, archive_url FROM links WHERE channel = ? ORDER BY time DESC LIMIT ?`,
+		strings.ToLower(channel), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result, err = scanHistory(rows, channel)
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return
+}
+
+// topEntry is an entry in the results of the top command
+type topEntry struct {
+	name  string
+	count int
+}
+
+// topPosters returns the nicks that posted the most links in channel since `since`
+func (h *historyStore) topPosters(channel string, since time.Time, limit int) (result []topEntry, err error) {
+	rows, err := h.db.Query(`SELECT nick, count(*) AS total FROM links WHERE channel = ? AND time >= ?
+		GROUP BY lower(nick) ORDER BY total DESC LIMIT ?`,
+		strings.ToLower(channel), since.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry topEntry
+		if err = rows.Scan(&entry.name, &entry.count); err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+// topDomains returns the domains linked to the most in channel since `since`
+func (h *historyStore) topDomains(channel string, since time.Time, limit int) (result []topEntry, err error) {
+	rows, err := h.db.Query(`SELECT url FROM links WHERE channel = ? AND time >= ?`,
+		strings.ToLower(channel), since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var url string
+		if err = rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		if host, err := urlHost(url); err == nil && host != "" {
+			counts[strings.TrimPrefix(host, "www.")]++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for domain, count := range counts {
+		result = append(result, topEntry{name: domain, count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].count != result[j].count {
+			return result[i].count > result[j].count
+		}
+		return result[i].name < result[j].name
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// scanHistory reads the rows of a query selecting url, title, nick, account, time, and archive_url
+func scanHistory(rows *sql.Rows, channel string) (result []historyEntry, err error) {
+	for rows.Next() {
+		entry := historyEntry{channel: channel}
+		var nanos int64
+		if err = rows.Scan(&entry.url, &entry.title, &entry.nick, &entry.account, &nanos, &entry.archiveURL); err != nil {
+			return nil, err
+		}
+		entry.time = time.Unix(0, nanos)
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+// ftsQuery turns search terms into an FTS5 query matching all of them,
+// quoting them so that they can't be interpreted as FTS5 syntax
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// search returns the links posted in channel whose URL or title
+// contains all the terms, best matches first
+func (h *historyStore) search(channel string, terms []string, limit int) (result []historyEntry, err error) {
+	rows, err := h.db.Query(`SELECT links.url, links.title, links.nick, links.account, links.time, links.archive_url
+		FROM links_fts JOIN links ON links.id = links_fts.rowid
+		WHERE links_fts MATCH ? AND links.channel = ? ORDER BY rank LIMIT ?`,
+		ftsQuery(terms), strings.ToLower(channel), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanHistory(rows, channel)
+}
+
+// recordHistory adds a titled link to the history, if it's enabled,
+// returning its ID (or 0 if it wasn't recorded)
+func (irc *Bot) recordHistory(req titleRequest, url, title string) (id int64) {
+	if irc.history == nil || req.nick == "" || req.spoiler || !req.inChannel() {
+		return 0
+	}
+	entry := historyEntry{
+		channel: req.target,
+		url:     url,
+		title:   title,
+		nick:    req.nick,
+		account: req.account,
+		time:    time.Now(),
+	}
+	id, err := irc.history.add(entry)
+	irc.reportErr(err, "couldn't record link history", "url", url, "channel", req.target)
+	return id
+}
+
+func (irc *Bot) formatHistoryEntry(entry historyEntry) string {
+	line := fmt.Sprintf("[%s] <%s> %s", entry.time.UTC().Format("2006-01-02 15:04"), entry.nick, irc.shorten(displayURL(entry.url)))
+	if entry.title != "" {
+		line += " -- " + entry.title
+	}
+	if entry.archiveURL != "" {
+		line += " (archived: " + irc.shorten(entry.archiveURL) + ")"
+	}
+	return line
+}
+
+// noticeHistory sends history entries to nick privately
+func (irc *Bot) noticeHistory(nick string, entries []historyEntry) {
+	send := func() {
+		for _, entry := range entries {
+			irc.queueMessage(nil, "NOTICE", nick, irc.formatHistoryEntry(entry), false)
+		}
+	}
+	if irc.shortener != nil {
+		// shortening URLs makes requests, which mustn't hold up the event loop
+		go send()
+	} else {
+		send()
+	}
+}
+
+// historyChannel determines which channel's history a command refers to:
+// the channel it was sent in, or in a direct message, a channel named as
+// the first argument that the sender is in. It returns the remaining arguments.
+func (irc *Bot) historyChannel(e ircmsg.Message, target, msgid string, args []string) (channel string, rest []string, ok bool) {
+	if irc.history == nil {
+		irc.sendReplyNotice(target, msgid, "link history is not enabled")
+		return
+	}
+	if strings.HasPrefix(target, "#") {
+		return target, args, true
+	}
+	if len(args) == 0 || !strings.HasPrefix(args[0], "#") {
+		irc.sendReplyNotice(target, msgid, "which channel?")
+		return
+	}
+	if !irc.channels.isMember(args[0], e.Nick()) {
+		irc.sendReplyNotice(target, msgid, "you must be in "+args[0]+" to do that")
+		return
+	}
+	return args[0], args[1:], true
+}
+
+// handleLinksCommand handles `links [N]`, sending the last N links posted
+// in the channel to the user privately
+func (irc *Bot) handleLinksCommand(e ircmsg.Message, target, msgid string, f []string) {
+	channel, args, ok := irc.historyChannel(e, target, msgid, f[1:])
+	if !ok {
+		return
+	}
+	count := defaultLinksCount
+	if len(args) != 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil && n > 0 {
+			count = n
+		}
+	}
+	if count > maxLinksCount {
+		count = maxLinksCount
+	}
+	entries, err := irc.history.recent(channel, count)
+	if irc.reportErr(err, "couldn't read link history", "channel", channel) {
+		irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		return
+	}
+	if len(entries) == 0 {
+		irc.queueMessage(nil, "NOTICE", e.Nick(), "no links have been posted in "+channel, false)
+		return
+	}
+	irc.noticeHistory(e.Nick(), entries)
+}
+
+// handleFindCommand handles `find <terms>`, sending the links posted in the
+// channel that best match the terms to the user privately
+func (irc *Bot) handleFindCommand(e ircmsg.Message, target, msgid string, f []string) {
+	channel, terms, ok := irc.historyChannel(e, target, msgid, f[1:])
+	if !ok {
+		return
+	}
+	if len(terms) == 0 {
+		irc.sendReplyNotice(target, msgid, "find what?")
+		return
+	}
+	entries, err := irc.history.search(channel, terms, findResultsCount)
+	if irc.reportErr(err, "couldn't search link history", "channel", channel) {
+		irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		return
+	}
+	if len(entries) == 0 {
+		irc.queueMessage(nil, "NOTICE", e.Nick(), fmt.Sprintf("no links matching %s in %s", strings.Join(terms, " "), channel), false)
+		return
+	}
+	irc.noticeHistory(e.Nick(), entries)
+}
+
+// handleTopCommand handles `top [domains|posters] [week|month]`,
+// summarizing the channel's link history
+func (irc *Bot) handleTopCommand(e ircmsg.Message, target, msgid string, f []string) {
+	channel, args, ok := irc.historyChannel(e, target, msgid, f[1:])
+	if !ok {
+		return
+	}
+	kind, period := "domains", ""
+	for _, arg := range args {
+		switch arg = strings.ToLower(arg); arg {
+		case "domains", "posters":
+			kind = arg
+		case "week", "month":
+			period = arg
+		default:
+			irc.sendReplyNotice(target, msgid, "usage: top [domains|posters] [week|month]")
+			return
+		}
+	}
+	var since time.Time
+	description := "of all time"
+	switch period {
+	case "week":
+		since, description = time.Now().AddDate(0, 0, -7), "this week"
+	case "month":
+		since, description = time.Now().AddDate(0, -1, 0), "this month"
+	}
+	var entries []topEntry
+	var err error
+	if kind == "posters" {
+		entries, err = irc.history.topPosters(channel, since, topCount)
+	} else {
+		entries, err = irc.history.topDomains(channel, since, topCount)
+	}
+	if irc.reportErr(err, "couldn't read link history", "channel", channel) {
+		irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		return
+	}
+	if len(entries) == 0 {
+		irc.sendReplyNotice(target, msgid, fmt.Sprintf("no links have been posted in %s %s", channel, description))
+		return
+	}
+	results := make([]string, len(entries))
+	for i, entry := range entries {
+		name := entry.name
+		if kind == "domains" {
+			name = displayHost(name)
+		}
+		results[i] = fmt.Sprintf("%s (%d)", name, entry.count)
+	}
+	irc.sendReplyNotice(target, msgid, fmt.Sprintf("top %s in %s %s: %s", kind, channel, description, strings.Join(results, ", ")))
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// the optional HTTP listener (TITLEBOT_HTTP_LISTEN) serves endpoints
+// for monitoring the bot
+
+// healthStatus is the response to /healthz
+type healthStatus struct {
+	Connected   bool            `json:"connected"`
+	Nick        string          `json:"nick"`
+	Channels    []string        `json:"channels"`
+	Uptime      string          `json:"uptime"`
+	LastSuccess *time.Time      `json:"lastSuccessfulFetch"`
+	BufferPool  bufferPoolStats `json:"bufferPool"`
+}
+
+// handleHealthz reports whether the bot is connected to IRC (responding
+// with 503 if it isn't), the channels it's in, and when it last managed
+// to fetch a link
+func (irc *Bot) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{
+		Connected:  irc.registered(),
+		Nick:       irc.CurrentNick(),
+		Channels:   irc.channels.list(),
+		Uptime:     humanReadableDuration(time.Since(irc.stats.started)),
+		BufferPool: bodyBuffers.stats(),
+	}
+	sort.Strings(status.Channels)
+	if lastSuccess := irc.stats.lastSuccessfulFetch(); !lastSuccess.IsZero() {
+		status.LastSuccess = &lastSuccess
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Connected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// registered is whether we're connected and have finished registering
+// (Connected() is already true while we're still registering)
+func (irc *Bot) registered() bool {
+	return irc.Connected() && irc.CurrentNick() != ""
+}
+
+func (irc *Bot) serveHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", irc.handleHealthz)
+	if irc.statusPage {
+		mux.HandleFunc("/{$}", irc.handleStatusPage)
+	}
+	if irc.history != nil {
+		mux.HandleFunc("GET /linkblog/{channel}", irc.handleLinkblog)
+		mux.HandleFunc("GET /linkblog/{channel}/rss", irc.handleLinkblogRSS)
+		mux.HandleFunc("GET /linkblog/{channel}/atom", irc.handleLinkblogAtom)
+	}
+	if irc.announceToken != "" {
+		mux.HandleFunc("POST /announce", irc.handleAnnounce)
+	}
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	irc.logError("HTTP listener exited", "error", server.ListenAndServe())
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the listener starts before we connect, so /healthz has to say we aren't
+func TestHealthzBeforeConnecting(t *testing.T) {
+	irc := &Bot{stats: newBotStats()}
+	irc.channels.reset()
+	w := httptest.NewRecorder()
+	irc.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, expected %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var status healthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Connected || status.Nick != "" {
+		t.Errorf("got %+v before connecting", status)
+	}
+}
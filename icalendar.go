@@ -0,0 +1,130 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+// calendarEvent is the first event (VEVENT) of an iCalendar file
+type calendarEvent struct {
+	summary  string
+	start    time.Time
+	allDay   bool
+	location string
+}
+
+// unfoldICalendar splits iCalendar data into its content lines, joining
+// lines that were folded (continued on a line starting with whitespace)
+func unfoldICalendar(data string) (lines []string) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		if len(lines) != 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+		} else if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+// parseICalendarLine splits a content line into its name, parameters, and value
+func parseICalendarLine(line string) (name string, params map[string]string, value string) {
+	// the value starts at the first colon that isn't in a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon == -1 {
+		return
+	}
+	value = line[colon+1:]
+	fields := strings.Split(line[:colon], ";")
+	name = strings.ToUpper(fields[0])
+	params = make(map[string]string)
+	for _, param := range fields[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+	return
+}
+
+var icalendarUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, `;`, `\,`, `,`, `\n`, " ", `\N`, " ")
+
+// parseICalendarTime parses a DATE-TIME (in UTC, in the time zone named by
+// TZID, or floating, which we take to be in `local`) or a DATE
+func parseICalendarTime(value string, params map[string]string, local *time.Location) (t time.Time, allDay bool, ok bool) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, local)
+		return t, true, err == nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err == nil
+	}
+	location := local
+	if tzid := params["TZID"]; tzid != "" {
+		if loaded, err := time.LoadLocation(tzid); err == nil {
+			location = loaded
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, location)
+	return t, false, err == nil
+}
+
+// parseICalendar returns the first event in iCalendar data
+func parseICalendar(data []byte, local *time.Location) (event calendarEvent, ok bool) {
+	inEvent := false
+	nested := 0 // components in the event, e.g. a VALARM, whose properties aren't the event's
+	for _, line := range unfoldICalendar(string(data)) {
+		name, params, value := parseICalendarLine(line)
+		switch {
+		case !inEvent:
+			inEvent = name == "BEGIN" && strings.EqualFold(value, "VEVENT")
+		case name == "BEGIN":
+			nested++
+		case name == "END" && nested > 0:
+			nested--
+		case name == "END":
+			return event, event.summary != ""
+		case nested > 0:
+			continue
+		case name == "SUMMARY":
+			event.summary = ircutils.SanitizeText(icalendarUnescaper.Replace(value), titleCharLimit)
+		case name == "LOCATION":
+			event.location = ircutils.SanitizeText(icalendarUnescaper.Replace(value), titleCharLimit)
+		case name == "DTSTART":
+			if start, allDay, ok := parseICalendarTime(value, params, local); ok {
+				event.start, event.allDay = start, allDay
+			}
+		}
+	}
+	// we may not have read the whole event
+	return event, event.summary != ""
+}
+
+// describe describes the event, with its start time in `location`
+func (event calendarEvent) describe(location *time.Location) string {
+	details := []string{"Event: " + event.summary}
+	switch {
+	case event.start.IsZero():
+	case event.allDay:
+		details = append(details, event.start.Format("Mon 2 Jan 2006"))
+	default:
+		details = append(details, event.start.In(location).Format("Mon 2 Jan 2006 15:04 MST"))
+	}
+	if event.location != "" {
+		details = append(details, event.location)
+	}
+	return strings.Join(details, ", ")
+}
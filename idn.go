@@ -0,0 +1,119 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const homographWarning = "[lookalike domain? %s] "
+
+// scripts that are legitimately written together, e.g. in Japanese;
+// Latin is allowed with any of these
+var compatibleScripts = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Hangul"},
+	{"Latin", "Han", "Bopomofo"},
+}
+
+// decodeHost decodes the punycode labels (xn--) of a host to Unicode,
+// and checks whether any label mixes scripts, like a Cyrillic а in an
+// otherwise Latin name, as homograph spoofs do (whether the link was
+// written in punycode or in Unicode)
+func decodeHost(host string) (decoded string, mixed bool) {
+	if !strings.Contains(strings.ToLower(host), "xn--") && isASCII(host) {
+		return host, false
+	}
+	decoded, err := idna.Display.ToUnicode(host)
+	if err != nil {
+		return host, false
+	}
+	for _, label := range strings.Split(decoded, ".") {
+		if mixedScripts(label) {
+			mixed = true
+		}
+	}
+	return
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// mixedScripts checks whether the letters of a label are from more than
+// one script (other than in the usual combinations)
+func mixedScripts(label string) bool {
+	scripts := make(map[string]bool)
+	for _, r := range label {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for name, table := range unicode.Scripts {
+			if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+				scripts[name] = true
+				break
+			}
+		}
+	}
+	if len(scripts) <= 1 {
+		return false
+	}
+	for _, compatible := range compatibleScripts {
+		subset := true
+		for script := range scripts {
+			subset = subset && sliceContains(compatible, script)
+		}
+		if subset {
+			return false
+		}
+	}
+	return true
+}
+
+// displayHost formats a host for output: decoded to Unicode, unless it
+// mixes scripts, in which case the punycode is shown too
+func displayHost(host string) string {
+	decoded, mixed := decodeHost(host)
+	if mixed {
+		punycode, err := idna.Display.ToASCII(host)
+		if err != nil {
+			punycode = host
+		}
+		return fmt.Sprintf("%s (%s, mixed scripts)", decoded, punycode)
+	}
+	return decoded
+}
+
+func displayHosts(hosts []string) (result []string) {
+	result = make([]string, len(hosts))
+	for i, host := range hosts {
+		result[i] = displayHost(host)
+	}
+	return
+}
+
+// displayURL decodes the host of a URL for output (see displayHost)
+func displayURL(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Host == "" {
+		return urlStr
+	}
+	decoded, mixed := decodeHost(u.Hostname())
+	if mixed || decoded == u.Hostname() {
+		// a lookalike is less convincing in its punycode form
+		return urlStr
+	}
+	return strings.Replace(urlStr, u.Hostname(), decoded, 1)
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeHost(t *testing.T) {
+	cases := []struct {
+		host    string
+		decoded string
+		mixed   bool
+	}{
+		{"example.com", "example.com", false},
+		{"xn--bcher-kva.example", "bücher.example", false},
+		{"bücher.example", "bücher.example", false},
+		{"xn--80ak6aa92e.com", "аррӏе.com", false},
+		{"xn--pple-43d.com", "аpple.com", true},
+		// the same lookalike, written in Unicode rather than punycode
+		{"аpple.com", "аpple.com", true},
+		{"аpple.COM", "аpple.com", true},
+		{"日本語ひらがな.jp", "日本語ひらがな.jp", false},
+	}
+	for _, c := range cases {
+		if decoded, mixed := decodeHost(c.host); decoded != c.decoded || mixed != c.mixed {
+			t.Errorf("decodeHost(%q) = %q, %t; expected %q, %t", c.host, decoded, mixed, c.decoded, c.mixed)
+		}
+	}
+
+	if display := displayHost("аpple.com"); display != "аpple.com (xn--pple-43d.com, mixed scripts)" {
+		t.Errorf("displayHost(%q) = %q", "аpple.com", display)
+	}
+}
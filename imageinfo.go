@@ -0,0 +1,104 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// imageInfo is what we can learn about an image from its header
+type imageInfo struct {
+	format        string // e.g. "PNG"
+	width, height int
+	animated      bool
+}
+
+// describeImage reads the dimensions (and whether it's animated) from the
+// start of an image file, without decoding the image itself
+func describeImage(head []byte) (info imageInfo, ok bool) {
+	if config, format, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+		info = imageInfo{format: formatNames[format], width: config.Width, height: config.Height}
+		switch format {
+		case "gif":
+			info.animated = gifAnimated(head)
+		case "png":
+			info.animated = pngAnimated(head)
+		}
+		return info, true
+	}
+	return webpInfo(head)
+}
+
+var formatNames = map[string]string{
+	"gif":  "GIF",
+	"jpeg": "JPEG",
+	"png":  "PNG",
+}
+
+// gifAnimated checks for more than one frame: each frame is preceded
+// by a graphic control extension
+func gifAnimated(head []byte) bool {
+	return bytes.Contains(head, []byte("NETSCAPE2.0")) || bytes.Count(head, []byte{0x21, 0xF9, 0x04}) > 1
+}
+
+// pngAnimated checks for the animation control chunk of an APNG,
+// which comes before the image data
+func pngAnimated(head []byte) bool {
+	acTL := bytes.Index(head, []byte("acTL"))
+	idat := bytes.Index(head, []byte("IDAT"))
+	return acTL != -1 && (idat == -1 || acTL < idat)
+}
+
+// webpInfo parses the header of a WebP file (image/webp isn't in the
+// standard library): a RIFF container whose first chunk is VP8 (lossy),
+// VP8L (lossless), or VP8X (extended, which may be animated)
+func webpInfo(head []byte) (info imageInfo, ok bool) {
+	if len(head) < 30 || string(head[0:4]) != "RIFF" || string(head[8:12]) != "WEBP" {
+		return
+	}
+	info.format = "WebP"
+	chunk := head[20:]
+	switch string(head[12:16]) {
+	case "VP8 ":
+		// frame tag (3 bytes), start code (3 bytes), then 14-bit dimensions
+		if len(chunk) < 10 || !bytes.Equal(chunk[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return info, false
+		}
+		info.width = int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		info.height = int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+	case "VP8L":
+		// signature byte, then 14-bit width-1 and height-1
+		if len(chunk) < 5 || chunk[0] != 0x2f {
+			return info, false
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		info.width = int(bits&0x3fff) + 1
+		info.height = int((bits>>14)&0x3fff) + 1
+	case "VP8X":
+		// flags (with the animation bit), 3 reserved bytes, then 24-bit width-1 and height-1
+		if len(chunk) < 10 {
+			return info, false
+		}
+		info.animated = chunk[0]&0x02 != 0
+		info.width = int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16) + 1
+		info.height = int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16) + 1
+	default:
+		return info, false
+	}
+	return info, true
+}
+
+func (info imageInfo) String() string {
+	description := fmt.Sprintf("%s, %d×%d", info.format, info.width, info.height)
+	if info.animated {
+		description += ", animated"
+	}
+	return description
+}
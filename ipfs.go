@@ -0,0 +1,64 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const defaultIPFSGateway = "https://ipfs.io"
+
+var (
+	// CIDv0 (base58, starting Qm) or CIDv1 (base32, starting b)
+	ipfsCIDRe = regexp.MustCompile(`^(Qm[1-9A-HJ-NP-Za-km-z]{44}|b[a-z2-7]{50,})$`)
+)
+
+// ipfsPath recognizes a link to content on IPFS: an ipfs:// URI, a path
+// gateway link (https://gateway/ipfs/<cid>/...), or a subdomain gateway link
+// (https://<cid>.ipfs.gateway/...). It returns the CID and the path to the
+// content under it, if any.
+func ipfsPath(urlStr string) (cid, rest string, ok bool) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "ipfs":
+		// ipfs://<cid>/path; the CID is case-sensitive, so use the raw host
+		cid, rest = u.Host, u.EscapedPath()
+	case "http", "https":
+		if label, domain, found := strings.Cut(u.Hostname(), "."); found && strings.HasPrefix(domain, "ipfs.") {
+			cid, rest = label, u.EscapedPath()
+		} else if after, found := strings.CutPrefix(u.EscapedPath(), "/ipfs/"); found {
+			cid, rest, _ = strings.Cut(after, "/")
+			if rest != "" {
+				rest = "/" + rest
+			}
+		}
+	default:
+		return
+	}
+	if !ipfsCIDRe.MatchString(cid) {
+		return "", "", false
+	}
+	if u.RawQuery != "" {
+		rest += "?" + u.RawQuery
+	}
+	return cid, rest, true
+}
+
+// ipfsGatewayURL rewrites a link to IPFS content to use our gateway
+func (irc *Bot) ipfsGatewayURL(cid, rest string) string {
+	return irc.ipfsGateway + "/ipfs/" + cid + rest
+}
+
+// shortCID abbreviates a CID for display, e.g. bafybe…7xq4
+func shortCID(cid string) string {
+	if len(cid) <= 12 {
+		return cid
+	}
+	return cid[:6] + "…" + cid[len(cid)-4:]
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+// isIRCURL checks whether a link is an irc:// or ircs:// URL
+func isIRCURL(urlStr string) bool {
+	lower := strings.ToLower(urlStr)
+	return strings.HasPrefix(lower, "irc://") || strings.HasPrefix(lower, "ircs://")
+}
+
+// describeIRCURL describes what an irc:// URL points at, e.g.
+// "IRC: #golang on irc.libera.chat", without connecting to it
+func describeIRCURL(urlStr string) (description string, ok bool) {
+	u, err := url.Parse(urlStr)
+	if err != nil || u.Hostname() == "" {
+		return "", false
+	}
+	network := u.Hostname()
+	if port := u.Port(); port != "" && port != "6667" && port != "6697" {
+		network = u.Host
+	}
+	// the path is /<target>[,<flags>], where the target is a channel
+	// (with or without its #) or, with the isnick flag, a nickname
+	target, flags, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), ",")
+	if target == "" {
+		return ircutils.SanitizeText("IRC: "+network, titleCharLimit), true
+	}
+	isNick := false
+	for _, flag := range strings.Split(flags, ",") {
+		if strings.EqualFold(flag, "isnick") {
+			isNick = true
+		}
+	}
+	if !isNick && !strings.ContainsAny(target[:1], "#&+!") {
+		target = "#" + target
+	}
+	return ircutils.SanitizeText("IRC: "+target+" on "+network, titleCharLimit), true
+}
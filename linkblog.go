@@ -0,0 +1,228 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// how many links the linkblog page and feeds show
+const linkblogCount = 50
+
+var linkblogTemplate = template.Must(template.New("linkblog").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>links posted in {{.Channel}}</title>
+<link rel="alternate" type="application/rss+xml" href="{{.Path}}/rss">
+<link rel="alternate" type="application/atom+xml" href="{{.Path}}/atom">
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 60em; }
+li { margin-bottom: 0.5em; }
+.meta { color: #666; font-size: smaller; }
+</style>
+</head>
+<body>
+<h1>links posted in {{.Channel}}</h1>
+<p><a href="{{.Path}}/rss">RSS</a> · <a href="{{.Path}}/atom">Atom</a></p>
+<ul>
+{{range .Links}}<li><a href="{{.URL}}" rel="nofollow noreferrer">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a>
+<span class="meta">posted by {{.Nick}}, {{.Time.UTC.Format "2006-01-02 15:04"}} UTC{{if .Archive}} · <a href="{{.Archive}}" rel="nofollow noreferrer">archived</a>{{end}}</span></li>
+{{else}}<li>no links yet</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+type linkblogLink struct {
+	URL   string
+	Title string
+	Nick  string
+	Time  time.Time
+	// archived copy, if TITLEBOT_ARCHIVE is enabled
+	Archive string
+}
+
+type linkblogPage struct {
+	Channel string
+	Path    string
+	Links   []linkblogLink
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Link    atomLink   `xml:"link"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// linkblogPath returns the path of a channel's linkblog, e.g. /linkblog/chat
+func linkblogPath(channel string) string {
+	return "/linkblog/" + url.PathEscape(strings.TrimPrefix(strings.ToLower(channel), "#"))
+}
+
+// linkblogURL returns the public URL of a channel's linkblog,
+// if we know where the HTTP listener can be reached
+func (irc *Bot) linkblogURL(channel string) string {
+	if irc.publicURL == "" || irc.history == nil {
+		return ""
+	}
+	return strings.TrimSuffix(irc.publicURL, "/") + linkblogPath(channel)
+}
+
+// linkblogLinks returns the recent links of a channel that has opted in to
+// the linkblog (newest first), or ok=false if it hasn't
+func (irc *Bot) linkblogLinks(r *http.Request) (channel string, links []linkblogLink, ok bool) {
+	channel = "#" + r.PathValue("channel")
+	if !irc.state.getChannelSettings(channel).Linkblog {
+		return channel, nil, false
+	}
+	entries, err := irc.history.recent(channel, linkblogCount)
+	if irc.reportErr(err, "couldn't read link history", "channel", channel) {
+		return channel, nil, false
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		links = append(links, linkblogLink{URL: entry.url, Title: entry.title, Nick: entry.nick, Time: entry.time, Archive: entry.archiveURL})
+	}
+	return channel, links, true
+}
+
+// requestBaseURL guesses the URL the linkblog was reached at, for
+// the absolute links that feeds need
+func (irc *Bot) requestBaseURL(r *http.Request) string {
+	if irc.publicURL != "" {
+		return strings.TrimSuffix(irc.publicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (irc *Bot) handleLinkblog(w http.ResponseWriter, r *http.Request) {
+	channel, links, ok := irc.linkblogLinks(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page := linkblogPage{Channel: channel, Path: linkblogPath(channel), Links: links}
+	irc.checkErr(linkblogTemplate.Execute(w, page), "couldn't render the linkblog", "channel", channel)
+}
+
+func (irc *Bot) handleLinkblogRSS(w http.ResponseWriter, r *http.Request) {
+	channel, links, ok := irc.linkblogLinks(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	feed := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:       "links posted in " + channel,
+		Link:        irc.requestBaseURL(r) + linkblogPath(channel),
+		Description: "links posted in " + channel,
+	}}
+	for _, link := range links {
+		title := link.Title
+		if title == "" {
+			title = link.URL
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       title,
+			Link:        link.URL,
+			Description: "posted by " + link.Nick,
+			GUID:        rssGUID{ID: link.URL + "#" + link.Time.UTC().Format(time.RFC3339Nano)},
+			PubDate:     link.Time.UTC().Format(time.RFC1123Z),
+		})
+	}
+	irc.writeFeed(w, "application/rss+xml", feed)
+}
+
+func (irc *Bot) handleLinkblogAtom(w http.ResponseWriter, r *http.Request) {
+	channel, links, ok := irc.linkblogLinks(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	page := irc.requestBaseURL(r) + linkblogPath(channel)
+	feed := atomFeed{
+		Title:   "links posted in " + channel,
+		ID:      page,
+		Link:    atomLink{Href: page},
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(links) != 0 {
+		feed.Updated = links[0].Time.UTC().Format(time.RFC3339)
+	}
+	for _, link := range links {
+		title := link.Title
+		if title == "" {
+			title = link.URL
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   title,
+			ID:      link.URL + "#" + link.Time.UTC().Format(time.RFC3339Nano),
+			Link:    atomLink{Href: link.URL},
+			Updated: link.Time.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: link.Nick},
+		})
+	}
+	irc.writeFeed(w, "application/atom+xml", feed)
+}
+
+func (irc *Bot) writeFeed(w http.ResponseWriter, contentType string, feed interface{}) {
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	irc.checkErr(xml.NewEncoder(w).Encode(feed), "couldn't write feed")
+}
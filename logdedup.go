@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logDedupWindow = time.Minute
+
+// attributes that vary between otherwise identical messages, which are
+// left out when deciding whether messages are duplicates (except that
+// urls are compared by their host)
+var logDedupIgnoredAttrs = map[string]bool{
+	"url":      true,
+	"error":    true,
+	"duration": true,
+	"stack":    true,
+	"panic":    true,
+}
+
+// dedupHandler collapses floods of identical log messages: after a message
+// is logged, duplicates of it within logDedupWindow are counted instead,
+// then summarized as "<message> (repeated N times in the last minute)".
+// Debug messages are never collapsed.
+type dedupHandler struct {
+	slog.Handler
+	state *dedupState
+}
+
+type dedupState struct {
+	sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	handler    slog.Handler
+	record     slog.Record
+	first      time.Time
+	suppressed int
+}
+
+func newDedupHandler(handler slog.Handler) *dedupHandler {
+	h := &dedupHandler{
+		Handler: handler,
+		state:   &dedupState{entries: make(map[string]*dedupEntry)},
+	}
+	go h.state.flushLoop()
+	return h
+}
+
+// dedupKey identifies duplicate messages
+func dedupKey(r slog.Record) string {
+	var key strings.Builder
+	fmt.Fprintf(&key, "%s|%s", r.Level, r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "url" {
+			host, _ := urlHost(attr.Value.String())
+			fmt.Fprintf(&key, "|host=%s", host)
+		} else if !logDedupIgnoredAttrs[attr.Key] {
+			fmt.Fprintf(&key, "|%s=%s", attr.Key, attr.Value)
+		}
+		return true
+	})
+	return key.String()
+}
+
+func (h *dedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelInfo {
+		return h.Handler.Handle(ctx, r)
+	}
+	key := dedupKey(r)
+	now := time.Now()
+
+	h.state.Lock()
+	entry, ok := h.state.entries[key]
+	if ok && now.Sub(entry.first) < logDedupWindow {
+		entry.suppressed++
+		h.state.Unlock()
+		return nil
+	}
+	h.state.entries[key] = &dedupEntry{handler: h.Handler, record: r.Clone(), first: now}
+	h.state.Unlock()
+
+	if ok {
+		entry.summarize()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &dedupHandler{Handler: h.Handler.WithAttrs(attrs), state: h.state}
+}
+
+func (h *dedupHandler) WithGroup(name string) slog.Handler {
+	return &dedupHandler{Handler: h.Handler.WithGroup(name), state: h.state}
+}
+
+// summarize logs how many duplicates of the entry's message were suppressed
+func (e *dedupEntry) summarize() {
+	if e.suppressed == 0 {
+		return
+	}
+	summary := slog.NewRecord(time.Now(), e.record.Level,
+		fmt.Sprintf("%s (repeated %d times in the last minute)", e.record.Message, e.suppressed), 0)
+	e.record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "url" {
+			host, _ := urlHost(attr.Value.String())
+			summary.AddAttrs(slog.String("host", host))
+		} else if !logDedupIgnoredAttrs[attr.Key] {
+			summary.AddAttrs(attr)
+		}
+		return true
+	})
+	e.handler.Handle(context.Background(), summary)
+}
+
+// flushLoop summarizes the duplicates of messages whose window has expired,
+// so that they're reported even if the message doesn't recur
+func (s *dedupState) flushLoop() {
+	for range time.Tick(logDedupWindow / 4) {
+		now := time.Now()
+		var expired []*dedupEntry
+		s.Lock()
+		for key, entry := range s.entries {
+			if now.Sub(entry.first) >= logDedupWindow {
+				expired = append(expired, entry)
+				delete(s.entries, key)
+			}
+		}
+		s.Unlock()
+		for _, entry := range expired {
+			entry.summarize()
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogMaxSize    = 100 * 1024 * 1024
+	defaultLogMaxBackups = 5
+	logBackupTimeFormat  = "2006-01-02T15-04-05.000"
+)
+
+// rotatingFile is a log file that is rotated when it exceeds maxSize bytes
+// or (if maxAge is nonzero) becomes older than maxAge. The old file is renamed
+// to path.<timestamp>, keeping at most maxBackups of them.
+type rotatingFile struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := r.openNoMutex(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) openNoMutex() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	// an existing file is as old as its last modification, as far as we know
+	r.created = time.Now()
+	if r.size != 0 {
+		r.created = info.ModTime()
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (n int, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.size != 0 && (r.size+int64(len(p)) > r.maxSize || (r.maxAge != 0 && time.Since(r.created) > r.maxAge)) {
+		if err = r.rotateNoMutex(); err != nil {
+			return
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return
+}
+
+func (r *rotatingFile) rotateNoMutex() error {
+	r.file.Close()
+	backup := r.path + "." + time.Now().Format(logBackupTimeFormat)
+	if err := os.Rename(r.path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	r.removeOldBackups()
+	return r.openNoMutex()
+}
+
+// removeOldBackups deletes all but the newest maxBackups rotated files
+func (r *rotatingFile) removeOldBackups() {
+	backups, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+	prefix := r.path + "."
+	kept := backups[:0]
+	for _, backup := range backups {
+		if _, err := time.Parse(logBackupTimeFormat, strings.TrimPrefix(backup, prefix)); err == nil {
+			kept = append(kept, backup)
+		}
+	}
+	// the timestamps sort chronologically:
+	sort.Strings(kept)
+	for len(kept) > r.maxBackups {
+		os.Remove(kept[0])
+		kept = kept[1:]
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelInfo
+	levelDebug
+)
+
+var logLevelNames = []string{"error", "info", "debug"}
+
+func parseLogLevel(name string) (level logLevel, ok bool) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return logLevel(i), true
+		}
+	}
+	return levelInfo, false
+}
+
+// subsystems whose debug logging can be enabled individually:
+// irc is the raw protocol traffic, http is fetching and titling links,
+// filter is decisions not to title something, and blocklist is
+// remote blocklists and malicious link checks
+var logSubsystems = []string{"irc", "http", "filter", "blocklist"}
+
+type logConfig struct {
+	sync.Mutex
+	level      logLevel
+	subsystems map[string]bool
+}
+
+func (irc *Bot) logEnabled(level logLevel, subsystem string) bool {
+	irc.logConfig.Lock()
+	defer irc.logConfig.Unlock()
+	return level <= irc.logConfig.level || (subsystem != "" && irc.logConfig.subsystems[subsystem])
+}
+
+// newLogHandler creates the handler for structured logs written to w, as JSON
+// if format is "json" and otherwise as key=value text. Messages are filtered
+// by logEnabled before they reach the handler.
+func newLogHandler(w io.Writer, format string) slog.Handler {
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if strings.EqualFold(format, "json") {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+// logError, logInfo, and logDebug log a message with key-value attributes,
+// e.g. irc.logInfo("not titling", "url", url, "reason", "malicious")
+func (irc *Bot) logError(msg string, args ...interface{}) {
+	irc.logger.Error(msg, args...)
+}
+
+func (irc *Bot) logInfo(msg string, args ...interface{}) {
+	if irc.logEnabled(levelInfo, "") {
+		irc.logger.Info(msg, args...)
+	}
+}
+
+func (irc *Bot) logDebug(subsystem, msg string, args ...interface{}) {
+	if irc.logEnabled(levelDebug, subsystem) {
+		irc.logger.Debug(msg, append([]interface{}{"subsystem", subsystem}, args...)...)
+	}
+}
+
+// updateLogConfig changes the log level and/or subsystem toggles, keeping
+// the irc library's protocol logging in sync with them
+func (irc *Bot) updateLogConfig(f func(*logConfig)) {
+	irc.logConfig.Lock()
+	defer irc.logConfig.Unlock()
+	if irc.logConfig.subsystems == nil {
+		irc.logConfig.subsystems = make(map[string]bool)
+	}
+	f(&irc.logConfig)
+	irc.Debug = irc.logConfig.level == levelDebug || irc.logConfig.subsystems["irc"]
+}
+
+func (irc *Bot) setLogLevel(level logLevel) {
+	irc.updateLogConfig(func(c *logConfig) { c.level = level })
+}
+
+func (irc *Bot) describeLogConfig() string {
+	irc.logConfig.Lock()
+	defer irc.logConfig.Unlock()
+	var enabled []string
+	for _, subsystem := range logSubsystems {
+		if irc.logConfig.subsystems[subsystem] {
+			enabled = append(enabled, subsystem)
+		}
+	}
+	result := "log level: " + logLevelNames[irc.logConfig.level]
+	if len(enabled) != 0 {
+		result += "; debugging: " + strings.Join(enabled, ", ")
+	}
+	return result
+}
+
+// handleLogCommand handles `loglevel [error|info|debug] [+subsystem|-subsystem ...]`
+func (irc *Bot) handleLogCommand(target, msgid string, f []string) {
+	for _, arg := range f[1:] {
+		if level, ok := parseLogLevel(arg); ok {
+			irc.setLogLevel(level)
+			continue
+		}
+		enable := !strings.HasPrefix(arg, "-")
+		subsystem := strings.ToLower(strings.TrimLeft(arg, "+-"))
+		if !sliceContains(logSubsystems, subsystem) {
+			irc.sendReplyNotice(target, msgid, fmt.Sprintf("unknown log level or subsystem %s (levels: %s; subsystems: %s)",
+				arg, strings.Join(logLevelNames, ", "), strings.Join(logSubsystems, ", ")))
+			return
+		}
+		irc.updateLogConfig(func(c *logConfig) { c.subsystems[subsystem] = enable })
+	}
+	irc.sendReplyNotice(target, msgid, irc.describeLogConfig())
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// how often to try to regain the configured nick while we don't have it
+	nickRecoveryInterval = time.Minute
+	// how often to ask NickServ to GHOST whoever has it
+	nickGhostInterval = 10 * time.Minute
+	// how long to wait after GHOST before taking the nick
+	nickGhostDelay = 5 * time.Second
+)
+
+// nickRecovery tracks our attempts to get the configured nick back
+type nickRecovery struct {
+	sync.Mutex
+	altNick   string // tried first if the configured nick is taken at connect
+	fallbacks int    // number of mangled nicks tried during registration
+	lastGhost time.Time
+}
+
+// nextNick returns the nick to try during registration after the server
+// rejected `rejected`: the alternate nick, then nick_0, nick_1, etc.
+func (n *nickRecovery) nextNick(preferred, rejected string) string {
+	n.Lock()
+	defer n.Unlock()
+	if n.altNick != "" && !strings.EqualFold(rejected, n.altNick) && n.fallbacks == 0 {
+		return n.altNick
+	}
+	nick := fmt.Sprintf("%s_%d", preferred, n.fallbacks)
+	n.fallbacks++
+	return nick
+}
+
+// shouldGhost checks whether it's time to ask services to GHOST our nick
+func (n *nickRecovery) shouldGhost() bool {
+	n.Lock()
+	defer n.Unlock()
+	if time.Since(n.lastGhost) < nickGhostInterval {
+		return false
+	}
+	n.lastGhost = time.Now()
+	return true
+}
+
+func (n *nickRecovery) reset() {
+	n.Lock()
+	defer n.Unlock()
+	n.fallbacks = 0
+}
+
+// setupNickRecovery handles the configured nick being taken: during
+// registration, we fall back to the alternate nick (if any), then to
+// mangled versions of the configured nick; once connected, we keep trying
+// to take the configured nick back, asking NickServ to GHOST its holder
+// if we're logged in with SASL
+func (irc *Bot) setupNickRecovery() {
+	var replaceOnce sync.Once
+	dial := irc.DialContext
+	irc.DialContext = func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+		// irc-go installs its own handlers for these on the first Connect(),
+		// just before dialing; replace them with ours
+		replaceOnce.Do(func() {
+			for _, numeric := range []string{ircevent.ERR_NICKNAMEINUSE, ircevent.ERR_UNAVAILRESOURCE} {
+				irc.ClearCallback(numeric)
+				irc.AddCallback(numeric, irc.handleUnavailableNick)
+			}
+		})
+		return dial(ctx, network, addr)
+	}
+	irc.AddConnectCallback(func(e ircmsg.Message) {
+		irc.nickRecovery.reset()
+		irc.recoverNick()
+	})
+	// take the nick as soon as its holder leaves:
+	irc.AddCallback("QUIT", func(e ircmsg.Message) {
+		if strings.EqualFold(e.Nick(), irc.PreferredNick()) {
+			irc.recoverNick()
+		}
+	})
+	irc.AddCallback("NICK", func(e ircmsg.Message) {
+		if strings.EqualFold(e.Nick(), irc.PreferredNick()) && e.Nick() != irc.CurrentNick() {
+			irc.recoverNick()
+		}
+	})
+	go func() {
+		for range time.Tick(nickRecoveryInterval) {
+			irc.recoverNick()
+		}
+	}()
+}
+
+func (irc *Bot) handleUnavailableNick(e ircmsg.Message) {
+	// once we're registered, this is a failed attempt to recover the nick;
+	// keep the one we have
+	if irc.CurrentNick() != "" {
+		return
+	}
+	rejected := irc.PreferredNick()
+	if len(e.Params) > 1 {
+		rejected = e.Params[1]
+	}
+	irc.Send("NICK", irc.nickRecovery.nextNick(irc.PreferredNick(), rejected))
+}
+
+// recoverNick tries to change to the configured nick, if we don't have it
+func (irc *Bot) recoverNick() {
+	current, preferred := irc.CurrentNick(), irc.PreferredNick()
+	if !irc.Connected() || current == "" || strings.EqualFold(current, preferred) {
+		return
+	}
+	if irc.SASLLogin != "" && irc.nickRecovery.shouldGhost() {
+		irc.logInfo("asking services to release our nick", "nick", preferred, "current", current)
+		irc.Send("PRIVMSG", "NickServ", "GHOST "+preferred)
+		time.AfterFunc(nickGhostDelay, func() {
+			if irc.Connected() && !strings.EqualFold(irc.CurrentNick(), preferred) {
+				irc.Send("NICK", preferred)
+			}
+		})
+		return
+	}
+	irc.Send("NICK", preferred)
+}
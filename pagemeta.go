@@ -0,0 +1,42 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	metaTagRe  = regexp.MustCompile(`(?is)<meta\b[^>]*>`)
+	metaAttrRe = regexp.MustCompile(`(?is)\b(property|name|itemprop|content)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// metaContent returns the content of the first <meta> tag in a page whose
+// property, name, or itemprop is one of keys (e.g., og:type), or "" if
+// there isn't one
+func metaContent(page []byte, keys ...string) string {
+	for _, tag := range metaTagRe.FindAll(page, -1) {
+		var key, content string
+		var hasContent bool
+		for _, attr := range metaAttrRe.FindAllSubmatch(tag, -1) {
+			value := string(attr[2]) + string(attr[3]) + string(attr[4])
+			if strings.EqualFold(string(attr[1]), "content") {
+				content, hasContent = value, true
+			} else if key == "" {
+				key = value
+			}
+		}
+		if !hasContent {
+			continue
+		}
+		for _, wanted := range keys {
+			if strings.EqualFold(key, wanted) {
+				return strings.TrimSpace(html.UnescapeString(content))
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+// platform URIs (as copied from apps) don't have a //, so they're found with
+// their own patterns and converted to their canonical web URLs
+var platformURIPatterns = map[string]urlPattern{
+	// spotify:track:<id>, spotify:user:<user>:playlist:<id>
+	"spotify": {
+		re:      regexp.MustCompile(`(?i)\bspotify:[a-z]+:[A-Za-z0-9]+(?::[a-z]+:[A-Za-z0-9]+)*`),
+		convert: spotifyWebURL,
+	},
+	// matrix:r/<alias>:<server>, matrix:u/<user>:<server>,
+	// matrix:roomid/<id>:<server>[/e/<event>]
+	"matrix": {
+		re:      regexp.MustCompile(`(?i)\bmatrix:(?:r|u|roomid)/[^\s/?]+(?:/e/[^\s/?]+)?`),
+		convert: matrixWebURL,
+	},
+}
+
+func platformPatterns(schemes []string) (patterns []urlPattern) {
+	for _, scheme := range schemes {
+		if pattern, ok := platformURIPatterns[scheme]; ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return
+}
+
+func spotifyWebURL(uri string) string {
+	parts := strings.Split(uri, ":")[1:]
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = strings.ToLower(parts[i])
+	}
+	return "https://open.spotify.com/" + strings.Join(parts, "/")
+}
+
+var matrixSigils = map[string]string{"r": "#", "u": "@", "roomid": "!"}
+
+func matrixWebURL(uri string) string {
+	kind, rest, _ := strings.Cut(uri[len("matrix:"):], "/")
+	id, event, _ := strings.Cut(rest, "/e/")
+	fragment := matrixSigils[strings.ToLower(kind)] + id
+	if event != "" {
+		fragment += "/$" + event
+	}
+	return "https://matrix.to/#/" + fragment
+}
+
+// isMatrixTo checks whether a link is a matrix.to link
+func isMatrixTo(urlStr string) bool {
+	host, err := urlHost(urlStr)
+	return err == nil && host == "matrix.to"
+}
+
+// describeMatrixTo describes what a matrix.to link points at, e.g.
+// "Matrix: #room:example.org"; its page has no useful title
+func describeMatrixTo(urlStr string) (description string, ok bool) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "", false
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(u.Fragment, "/"), "?")
+	id, event, _ := strings.Cut(id, "/")
+	switch {
+	case strings.HasPrefix(id, "#"), strings.HasPrefix(id, "@"):
+		description = "Matrix: " + id
+	case strings.HasPrefix(id, "!"):
+		_, server, _ := strings.Cut(id, ":")
+		description = "Matrix: a room on " + server
+	default:
+		return "", false
+	}
+	if event != "" {
+		description += " (a message)"
+	}
+	return ircutils.SanitizeText(description, titleCharLimit), true
+}
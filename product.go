@@ -0,0 +1,248 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	currencyCodeRe = regexp.MustCompile(`^[A-Z]{3}$`)
+	jsonLDRe       = regexp.MustCompile(`(?is)<script\b[^>]*\btype\s*=\s*["']?application/ld\+json["']?[^>]*>(.*?)</script>`)
+
+	currencySymbols = map[string]string{
+		"USD": "$",
+		"EUR": "€",
+		"GBP": "£",
+		"JPY": "¥",
+		"INR": "₹",
+	}
+)
+
+// productInfo is what we know about a product from its page's structured
+// data (schema.org Product, Offer, and AggregateRating)
+type productInfo struct {
+	price        string
+	currency     string
+	availability string // e.g. "in stock"
+	rating       string // e.g. "4.6/5"
+	reviews      int
+}
+
+// schema.org ItemAvailability values, as they're shown
+var availabilities = map[string]string{
+	"instock":             "in stock",
+	"in stock":            "in stock",
+	"outofstock":          "out of stock",
+	"out of stock":        "out of stock",
+	"oos":                 "out of stock",
+	"soldout":             "sold out",
+	"discontinued":        "discontinued",
+	"preorder":            "pre-order",
+	"presale":             "pre-order",
+	"backorder":           "on backorder",
+	"limitedavailability": "limited availability",
+	"instoreonly":         "in store only",
+	"onlineonly":          "online only",
+}
+
+// String formats the product info for the end of a title, e.g.
+// "$129.99, in stock, rated 4.6/5 (1234 reviews)"
+func (p productInfo) String() string {
+	var parts []string
+	if symbol, ok := currencySymbols[p.currency]; ok && p.price != "" {
+		parts = append(parts, symbol+p.price)
+	} else if p.currency != "" && p.price != "" {
+		parts = append(parts, p.price+" "+p.currency)
+	} else if p.price != "" {
+		parts = append(parts, p.price)
+	}
+	if p.availability != "" {
+		parts = append(parts, p.availability)
+	}
+	if p.rating != "" {
+		rating := "rated " + p.rating
+		if p.reviews == 1 {
+			rating += " (1 review)"
+		} else if p.reviews > 1 {
+			rating += fmt.Sprintf(" (%d reviews)", p.reviews)
+		}
+		parts = append(parts, rating)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (p productInfo) empty() bool {
+	return p.price == "" && p.availability == "" && p.rating == ""
+}
+
+// findProduct finds a product's price, availability, and rating in a page,
+// from schema.org data in JSON-LD, or failing that from product: meta tags
+// (as in OpenGraph)
+func findProduct(page []byte) (product productInfo, ok bool) {
+	for _, match := range jsonLDRe.FindAllSubmatch(page, -1) {
+		var data interface{}
+		if json.Unmarshal(match[1], &data) != nil {
+			continue
+		}
+		if product, ok = productFromJSONLD(data); ok {
+			return
+		}
+	}
+	if price := metaContent(page, "product:price:amount", "og:price:amount", "price"); price != "" {
+		product.price = formatPrice(price)
+		product.currency = currencyCode(metaContent(page, "product:price:currency", "og:price:currency", "priceCurrency"))
+	}
+	product.availability = availability(metaContent(page, "product:availability", "og:availability", "availability"))
+	product.rating = formatRating(metaContent(page, "ratingValue"), metaContent(page, "bestRating"))
+	product.reviews, _ = strconv.Atoi(metaContent(page, "reviewCount", "ratingCount"))
+	return product, !product.empty()
+}
+
+// productFromJSONLD looks for a Product with an offer in JSON-LD data,
+// including in @graph lists and arrays of items
+func productFromJSONLD(data interface{}) (product productInfo, ok bool) {
+	switch data := data.(type) {
+	case []interface{}:
+		for _, item := range data {
+			if product, ok = productFromJSONLD(item); ok {
+				return
+			}
+		}
+	case map[string]interface{}:
+		if graph, found := data["@graph"]; found {
+			return productFromJSONLD(graph)
+		}
+		if !hasJSONLDType(data, "Product") {
+			return
+		}
+		product, _ = offerPrice(data["offers"])
+		if rating, found := data["aggregateRating"].(map[string]interface{}); found {
+			product.rating = formatRating(jsonLDString(rating["ratingValue"]), jsonLDString(rating["bestRating"]))
+			reviews := jsonLDString(rating["reviewCount"])
+			if reviews == "" {
+				reviews = jsonLDString(rating["ratingCount"])
+			}
+			product.reviews, _ = strconv.Atoi(reviews)
+		}
+		return product, !product.empty()
+	}
+	return
+}
+
+// offerPrice gets the price (and availability) from an Offer, the first
+// priced Offer in a list, or the lowest price of an AggregateOffer
+func offerPrice(offers interface{}) (product productInfo, ok bool) {
+	switch offers := offers.(type) {
+	case []interface{}:
+		for _, offer := range offers {
+			if product, ok = offerPrice(offer); ok {
+				return
+			}
+		}
+	case map[string]interface{}:
+		price := jsonLDString(offers["price"])
+		if price == "" {
+			price = jsonLDString(offers["lowPrice"])
+		}
+		if price == "" {
+			if spec, found := offers["priceSpecification"]; found {
+				return offerPrice(spec)
+			}
+			return
+		}
+		product.price = formatPrice(price)
+		product.currency = currencyCode(jsonLDString(offers["priceCurrency"]))
+		product.availability = availability(jsonLDString(offers["availability"]))
+		return product, product.price != ""
+	}
+	return
+}
+
+// hasJSONLDType checks an item's @type, which may be a list of types
+func hasJSONLDType(item map[string]interface{}, wanted string) bool {
+	switch itemType := item["@type"].(type) {
+	case string:
+		return strings.EqualFold(itemType, wanted) || strings.EqualFold(itemType, "http://schema.org/"+wanted) ||
+			strings.EqualFold(itemType, "https://schema.org/"+wanted)
+	case []interface{}:
+		for _, t := range itemType {
+			if s, ok := t.(string); ok && hasJSONLDType(map[string]interface{}{"@type": s}, wanted) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDString gets a string or number value as a string
+func jsonLDString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+// formatPrice normalizes a price for display: 129.9 as 129.90, 15.00 as
+// 15, and 1.299,00 (with a decimal comma) as 1299. It returns "" for
+// prices that aren't numbers.
+func formatPrice(price string) string {
+	if comma := strings.LastIndexByte(price, ','); comma != -1 {
+		dot := strings.LastIndexByte(price, '.')
+		if (dot == -1 && len(price)-comma == 3) || (dot != -1 && comma > dot) {
+			// a decimal comma, and maybe dots between the thousands
+			price = strings.ReplaceAll(price[:comma], ".", "") + "." + price[comma+1:]
+		}
+		price = strings.ReplaceAll(price, ",", "")
+	}
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil || value < 0 {
+		return ""
+	}
+	if value == float64(int64(value)) {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return fmt.Sprintf("%.2f", value)
+}
+
+// currencyCode checks for an ISO 4217 code like USD, returning "" otherwise
+func currencyCode(currency string) string {
+	currency = strings.ToUpper(currency)
+	if !currencyCodeRe.MatchString(currency) {
+		return ""
+	}
+	return currency
+}
+
+// availability shows a schema.org ItemAvailability (which may be a URL like
+// https://schema.org/InStock) or an OpenGraph availability like "oos"
+func availability(value string) string {
+	value = strings.ToLower(value)
+	if i := strings.LastIndexByte(value, '/'); i != -1 {
+		value = value[i+1:]
+	}
+	return availabilities[value]
+}
+
+// formatRating shows a rating out of the best possible rating (5 if not
+// given), e.g. 4.6/5; it returns "" if the rating isn't a number
+func formatRating(rating, best string) string {
+	value, err := strconv.ParseFloat(rating, 64)
+	if err != nil || value < 0 {
+		return ""
+	}
+	bestValue, err := strconv.ParseFloat(best, 64)
+	if err != nil || bestValue <= 0 {
+		bestValue = 5
+	}
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64) + "/" + strconv.FormatFloat(bestValue, 'f', -1, 64)
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPubSubTopic = "titlebot.links"
+	pubsubTimeout      = 30 * time.Second
+)
+
+// pubsub publishes linkRecords to a message bus: Redis pub/sub (redis://,
+// rediss://), NATS (nats://, tls://), or MQTT 3.1.1 at QoS 0 (mqtt://,
+// mqtts://). Each speaks just enough of its protocol to publish; records
+// are published in the background, on a single connection that's reopened
+// as needed.
+type pubsub struct {
+	url   *url.URL
+	topic string
+	queue chan linkRecord
+
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+func newPubSub(rawURL, topic string) (*pubsub, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss", "nats", "tls", "mqtt", "mqtts":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q: must be redis, rediss, nats, tls, mqtt, or mqtts", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("missing host")
+	}
+	if topic == "" {
+		topic = defaultPubSubTopic
+	}
+	return &pubsub{url: u, topic: topic, queue: make(chan linkRecord, webhookQueueSize)}, nil
+}
+
+func (p *pubsub) address() string {
+	if port := p.url.Port(); port != "" {
+		return p.url.Host
+	}
+	port := map[string]string{
+		"redis": "6379", "rediss": "6379",
+		"nats": "4222", "tls": "4222",
+		"mqtt": "1883", "mqtts": "8883",
+	}[p.url.Scheme]
+	return net.JoinHostPort(p.url.Hostname(), port)
+}
+
+func (p *pubsub) connect() (err error) {
+	dialer := &net.Dialer{Timeout: pubsubTimeout}
+	var conn net.Conn
+	switch p.url.Scheme {
+	case "rediss", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.address(), &tls.Config{ServerName: p.url.Hostname()})
+	default:
+		conn, err = dialer.Dial("tcp", p.address())
+	}
+	if err != nil {
+		return err
+	}
+	p.conn = conn
+	p.rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	conn.SetDeadline(time.Now().Add(pubsubTimeout))
+	switch p.url.Scheme {
+	case "redis", "rediss":
+		err = p.redisHandshake()
+	case "nats", "tls":
+		err = p.natsHandshake()
+	case "mqtt", "mqtts":
+		err = p.mqttHandshake()
+	}
+	if err != nil {
+		p.close()
+	}
+	return err
+}
+
+func (p *pubsub) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func (p *pubsub) publish(payload []byte) (err error) {
+	if p.conn == nil {
+		if err = p.connect(); err != nil {
+			return err
+		}
+	}
+	p.conn.SetDeadline(time.Now().Add(pubsubTimeout))
+	switch p.url.Scheme {
+	case "redis", "rediss":
+		err = p.redisPublish(payload)
+	case "nats", "tls":
+		err = p.natsPublish(payload)
+	case "mqtt", "mqtts":
+		err = p.mqttPublish(payload)
+	}
+	if err != nil {
+		p.close()
+	}
+	return err
+}
+
+// readLine reads a CRLF-terminated line (Redis and NATS replies)
+func (p *pubsub) readLine() (string, error) {
+	line, err := p.rw.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (p *pubsub) redisCommand(args ...string) error {
+	fmt.Fprintf(p.rw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(p.rw, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := p.rw.Flush(); err != nil {
+		return err
+	}
+	reply, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(reply, "-") {
+		return fmt.Errorf("redis error: %s", reply[1:])
+	}
+	return nil
+}
+
+func (p *pubsub) redisHandshake() error {
+	if p.url.User == nil {
+		return nil
+	}
+	password, _ := p.url.User.Password()
+	if username := p.url.User.Username(); username != "" {
+		return p.redisCommand("AUTH", username, password)
+	}
+	return p.redisCommand("AUTH", password)
+}
+
+func (p *pubsub) redisPublish(payload []byte) error {
+	return p.redisCommand("PUBLISH", p.topic, string(payload))
+}
+
+func (p *pubsub) natsHandshake() error {
+	// the server greets us with INFO
+	if info, err := p.readLine(); err != nil {
+		return err
+	} else if !strings.HasPrefix(info, "INFO ") {
+		return fmt.Errorf("unexpected NATS greeting %q", info)
+	}
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "titlebot", "lang": "go"}
+	if p.url.User != nil {
+		if password, ok := p.url.User.Password(); ok {
+			options["user"] = p.url.User.Username()
+			options["pass"] = password
+		} else {
+			options["auth_token"] = p.url.User.Username()
+		}
+	}
+	connect, _ := json.Marshal(options)
+	fmt.Fprintf(p.rw, "CONNECT %s\r\n", connect)
+	return p.natsPing()
+}
+
+// natsPing flushes what we've written and waits for the server to confirm
+// it, so that errors (or a dead connection) aren't silently ignored
+func (p *pubsub) natsPing() error {
+	p.rw.WriteString("PING\r\n")
+	if err := p.rw.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			p.rw.WriteString("PONG\r\n")
+			p.rw.Flush()
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS error: %s", strings.TrimSpace(line[4:]))
+		}
+	}
+}
+
+func (p *pubsub) natsPublish(payload []byte) error {
+	fmt.Fprintf(p.rw, "PUB %s %d\r\n", p.topic, len(payload))
+	p.rw.Write(payload)
+	p.rw.WriteString("\r\n")
+	return p.natsPing()
+}
+
+// mqttPacket writes an MQTT control packet with its variable-length
+// "remaining length" encoding
+func (p *pubsub) mqttPacket(packetType byte, body []byte) error {
+	p.rw.WriteByte(packetType)
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		p.rw.WriteByte(digit)
+		if length == 0 {
+			break
+		}
+	}
+	p.rw.Write(body)
+	return p.rw.Flush()
+}
+
+func mqttString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func (p *pubsub) mqttHandshake() error {
+	var flags byte = 0x02 // clean session
+	var username, password string
+	if p.url.User != nil {
+		username = p.url.User.Username()
+		flags |= 0x80
+		if pass, ok := p.url.User.Password(); ok {
+			password = pass
+			flags |= 0x40
+		}
+	}
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, flags)                 // protocol level 4 is MQTT 3.1.1
+	body = binary.BigEndian.AppendUint16(body, 0) // no keepalive; we don't need the broker to time us out
+	clientID := fmt.Sprintf("titlebot-%d", os.Getpid())
+	body = mqttString(body, clientID)
+	if flags&0x80 != 0 {
+		body = mqttString(body, username)
+	}
+	if flags&0x40 != 0 {
+		body = mqttString(body, password)
+	}
+	if err := p.mqttPacket(0x10, body); err != nil {
+		return err
+	}
+	// CONNACK: type, length 2, flags, return code
+	var connack [4]byte
+	if _, err := io.ReadFull(p.rw, connack[:]); err != nil {
+		return err
+	}
+	if connack[0] != 0x20 || connack[1] != 2 {
+		return errors.New("invalid MQTT CONNACK")
+	}
+	if connack[3] != 0 {
+		return fmt.Errorf("MQTT connection refused (code %d)", connack[3])
+	}
+	return nil
+}
+
+func (p *pubsub) mqttPublish(payload []byte) error {
+	// QoS 0, so there's no acknowledgement to wait for
+	return p.mqttPacket(0x30, append(mqttString(nil, p.topic), payload...))
+}
+
+func (irc *Bot) pubsubLoop() {
+	for record := range irc.pubsub.queue {
+		payload, err := json.Marshal(record)
+		if err != nil {
+			continue
+		}
+		err = irc.pubsub.publish(payload)
+		if err != nil {
+			// the connection may have gone stale since the last record
+			err = irc.pubsub.publish(payload)
+		}
+		irc.checkErr(err, "couldn't publish to pub/sub", "url", record.URL, "server", irc.pubsub.address())
+	}
+}
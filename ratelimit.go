@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sweep expired keys once the map gets this large
+	rateLimiterCleanupThreshold = 1024
+)
+
+// rateLimiter allows up to `limit` events per key within any
+// window of length `period`
+type rateLimiter struct {
+	sync.Mutex
+	limit  int
+	period time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		period: period,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// allow records an event for key and reports whether it is within the limit
+func (r *rateLimiter) allow(key string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-r.period)
+	if len(r.events) >= rateLimiterCleanupThreshold {
+		for k, times := range r.events {
+			if len(times) == 0 || times[len(times)-1].Before(cutoff) {
+				delete(r.events, k)
+			}
+		}
+	}
+
+	times := r.events[key]
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	times = times[i:]
+	if len(times) >= r.limit {
+		r.events[key] = times
+		return false
+	}
+	r.events[key] = append(times, now)
+	return true
+}
+
+// tokenBucket holds up to `capacity` tokens, refilled continuously
+// at `rate` tokens per second
+type tokenBucket struct {
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(capacity, rate float64) *tokenBucket {
+	return &tokenBucket{
+		capacity: capacity,
+		rate:     rate,
+		tokens:   capacity,
+		last:     time.Now(),
+	}
+}
+
+// take takes a token if at least `reserve` tokens would remain afterwards
+func (t *tokenBucket) take(now time.Time, reserve float64) bool {
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.capacity {
+		t.tokens = t.capacity
+	}
+	t.last = now
+	if t.tokens-1 < reserve {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// floodControl limits the rate of titles sent to each channel
+type floodControl struct {
+	sync.Mutex
+	perMinute int
+	burst     int
+	buckets   map[string]*tokenBucket
+}
+
+func newFloodControl(perMinute, burst int) *floodControl {
+	return &floodControl{
+		perMinute: perMinute,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// allow checks whether we can send a title to target (a channel or XMPP
+// room). Background titles are only sent while at least half the burst
+// capacity is available, leaving room for titles of links that were just
+// posted.
+func (f *floodControl) allow(target string, background bool) bool {
+	if f.perMinute < 0 {
+		return true
+	}
+	f.Lock()
+	defer f.Unlock()
+	key := strings.ToLower(target)
+	bucket, ok := f.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(float64(f.burst), float64(f.perMinute)/60)
+		f.buckets[key] = bucket
+	}
+	var reserve float64
+	if background {
+		reserve = float64(f.burst) / 2
+	}
+	return bucket.take(time.Now(), reserve)
+}
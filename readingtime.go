@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// a typical adult reading speed for prose
+const wordsPerMinute = 230
+
+var (
+	articleRe   = regexp.MustCompile(`(?is)<article\b[^>]*>(.*)</article>`)
+	pageBodyRe  = regexp.MustCompile(`(?is)<body\b[^>]*>(.*)`)
+	nonTextRe   = regexp.MustCompile(`(?is)<script\b.*?</script>|<style\b.*?</style>|<noscript\b.*?</noscript>|<template\b.*?</template>|<!--.*?-->`)
+	htmlTagRe   = regexp.MustCompile(`(?s)<[^>]*>`)
+	isArticleRe = regexp.MustCompile(`(?i)<article\b`)
+)
+
+// readingTime estimates how long an article takes to read, e.g. "~7 min
+// read", from the text of its <article> element (or else its body). Pages
+// that don't look like articles (by og:type or an <article> element), or
+// that take less than a minute, get "".
+func readingTime(page []byte) string {
+	if !strings.EqualFold(metaContent(page, "og:type"), "article") && !isArticleRe.Match(page) {
+		return ""
+	}
+	minutes := (articleWords(page) + wordsPerMinute/2) / wordsPerMinute
+	if minutes < 1 {
+		return ""
+	}
+	return fmt.Sprintf("~%d min read", minutes)
+}
+
+// articleWords counts the words in the text of a page's article
+func articleWords(page []byte) int {
+	text := page
+	if match := articleRe.FindSubmatch(page); match != nil {
+		text = match[1]
+	} else if match := pageBodyRe.FindSubmatch(page); match != nil {
+		text = match[1]
+	}
+	text = nonTextRe.ReplaceAll(text, nil)
+	text = htmlTagRe.ReplaceAll(text, []byte(" "))
+	if bytes.IndexByte(text, '&') != -1 {
+		return len(strings.Fields(html.UnescapeString(string(text))))
+	}
+	return len(bytes.Fields(text))
+}
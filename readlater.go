@@ -0,0 +1,206 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	readLaterTimeout = 15 * time.Second
+	// how much of a read-later service's response we read
+	readLaterResponseLimit = 64 * 1024
+)
+
+// readLaterAccount is an account on a read-later service that the save
+// command sends links to: linkding (Token is an API token) or Wallabag
+// (Token is client_id:client_secret:username:password)
+type readLaterAccount struct {
+	Service string `json:"service"`
+	URL     string `json:"url"`
+	Token   string `json:"token"`
+}
+
+func newReadLaterAccount(service, baseURL, token string) (result readLaterAccount, err error) {
+	service = strings.ToLower(service)
+	switch service {
+	case "linkding":
+	case "wallabag":
+		if len(strings.SplitN(token, ":", 4)) != 4 {
+			return result, errors.New("the Wallabag token must be client_id:client_secret:username:password")
+		}
+	default:
+		return result, fmt.Errorf("unknown read-later service %q: must be linkding or wallabag", service)
+	}
+	if u, err := url.Parse(baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return result, fmt.Errorf("invalid URL %q", baseURL)
+	}
+	if token == "" {
+		return result, errors.New("missing token")
+	}
+	return readLaterAccount{Service: service, URL: strings.TrimSuffix(baseURL, "/"), Token: token}, nil
+}
+
+// handleReadLaterCommand handles `readlater [off | <linkding|wallabag> <url> <token>]`,
+// registering the sender's own read-later account for the save command
+func (irc *Bot) handleReadLaterCommand(e ircmsg.Message, target, msgid string, f []string) {
+	account := messageAccount(e)
+	if account == "" {
+		irc.sendReplyNotice(target, msgid, "you must be logged into an account to do that")
+		return
+	}
+	switch {
+	case len(f) == 1:
+		if readLater, ok := irc.state.getReadLater(account); ok {
+			irc.sendReplyNotice(target, msgid, fmt.Sprintf("links you save go to %s at %s (say readlater off to undo this)", readLater.Service, readLater.URL))
+		} else if irc.readLater != nil {
+			irc.sendReplyNotice(target, msgid, "links you save go to the shared "+irc.readLater.Service+" account")
+		} else {
+			irc.sendReplyNotice(target, msgid, "usage (privately): readlater <linkding|wallabag> <url> <token>")
+		}
+	case len(f) == 2 && strings.ToLower(f[1]) == "off":
+		err := irc.state.setReadLater(account, readLaterAccount{})
+		if irc.reportErr(err, "couldn't save read-later account") {
+			irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		} else {
+			irc.sendReplyNotice(target, msgid, "OK, I've forgotten your read-later account")
+		}
+	case strings.HasPrefix(target, "#"):
+		irc.sendReplyNotice(target, msgid, "send that to me privately (and change your token, since it was posted in public)")
+	case len(f) == 4:
+		readLater, err := newReadLaterAccount(f[1], f[2], f[3])
+		if err != nil {
+			irc.sendReplyNotice(target, msgid, err.Error())
+			return
+		}
+		if irc.reportErr(irc.state.setReadLater(account, readLater), "couldn't save read-later account") {
+			irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+		} else {
+			irc.sendReplyNotice(target, msgid, fmt.Sprintf("OK, links you save will go to %s at %s", readLater.Service, readLater.URL))
+		}
+	default:
+		irc.sendReplyNotice(target, msgid, "usage: readlater <linkding|wallabag> <url> <token>, or readlater off")
+	}
+}
+
+// handleSaveCommand handles `save <url>` and `save last`, sending a link
+// (or the last one posted in the channel) to the sender's read-later account
+func (irc *Bot) handleSaveCommand(e ircmsg.Message, target, msgid string, f []string) {
+	if len(f) < 2 {
+		irc.sendReplyNotice(target, msgid, "usage: save <url|last>")
+		return
+	}
+	// the sender's own account, or else the shared one
+	readLater, ok := irc.state.getReadLater(messageAccount(e))
+	if !ok {
+		if irc.readLater == nil {
+			irc.sendReplyNotice(target, msgid, "you haven't set up read-later; send me: readlater <linkding|wallabag> <url> <token>")
+			return
+		}
+		readLater = *irc.readLater
+	}
+
+	link := f[1]
+	if strings.ToLower(link) == "last" {
+		channel, _, ok := irc.historyChannel(e, target, msgid, f[2:])
+		if !ok {
+			return
+		}
+		entries, err := irc.history.recent(channel, 1)
+		if irc.reportErr(err, "couldn't read link history", "channel", channel) {
+			irc.sendReplyNotice(target, msgid, "sorry, something went wrong")
+			return
+		}
+		if len(entries) == 0 {
+			irc.sendReplyNotice(target, msgid, "no links have been posted in "+channel)
+			return
+		}
+		link = entries[0].url
+	} else if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		irc.sendReplyNotice(target, msgid, "that doesn't look like a link")
+		return
+	}
+
+	go func() {
+		err := irc.saveForLater(readLater, link)
+		if irc.checkErr(err, "couldn't save link for later", "service", readLater.Service, "url", link) {
+			irc.sendReplyNotice(target, msgid, fmt.Sprintf("sorry, %s didn't accept the link", readLater.Service))
+		} else {
+			irc.sendReplyNotice(target, msgid, fmt.Sprintf("saved %s to %s", link, readLater.Service))
+		}
+	}()
+}
+
+func (irc *Bot) saveForLater(readLater readLaterAccount, link string) error {
+	ctx, cancel := context.WithTimeout(irc.ctx, readLaterTimeout)
+	defer cancel()
+	switch readLater.Service {
+	case "linkding":
+		return irc.readLaterPost(ctx, readLater.URL+"/api/bookmarks/", "Token "+readLater.Token,
+			map[string]interface{}{"url": link, "unread": true}, nil)
+	case "wallabag":
+		credentials := strings.SplitN(readLater.Token, ":", 4)
+		if len(credentials) != 4 {
+			return errors.New("invalid Wallabag credentials")
+		}
+		var token struct {
+			AccessToken string `json:"access_token"`
+		}
+		err := irc.readLaterPost(ctx, readLater.URL+"/oauth/v2/token", "", map[string]interface{}{
+			"grant_type":    "password",
+			"client_id":     credentials[0],
+			"client_secret": credentials[1],
+			"username":      credentials[2],
+			"password":      credentials[3],
+		}, &token)
+		if err != nil {
+			return err
+		}
+		return irc.readLaterPost(ctx, readLater.URL+"/api/entries.json", "Bearer "+token.AccessToken,
+			map[string]interface{}{"url": link}, nil)
+	default:
+		return fmt.Errorf("unknown read-later service %q", readLater.Service)
+	}
+}
+
+// readLaterPost POSTs a JSON body to a read-later service,
+// decoding the JSON response into `response` if it's non-nil
+func (irc *Bot) readLaterPost(ctx context.Context, endpoint, authorization string, body, response interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", req.URL.Host, resp.StatusCode)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, readLaterResponseLimit)).Decode(response)
+}
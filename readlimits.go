@@ -0,0 +1,169 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	readLimitSamples = 10 // how many recent fetches to remember per domain
+	// promote a domain to the larger read limit when, of at least
+	// readLimitMinPromote recent fetches with the normal limit, this
+	// fraction ran out of bytes before finding the title:
+	readLimitMinPromote   = 3
+	readLimitPromoteRatio = 0.8
+	// demote a domain when this many recent fetches with the larger
+	// limit all found the title within the normal limit:
+	readLimitMinDemote  = 5
+	maxReadLimitDomains = 4096
+)
+
+// fetchSample is the outcome of fetching a page from a domain
+type fetchSample struct {
+	large     bool // whether we used the larger read limit
+	found     bool // whether we found the title
+	truncated bool // whether we stopped reading at the limit
+	needed    int  // bytes we had to read to find the title
+}
+
+type domainReadStats struct {
+	large     bool // currently using the larger read limit
+	samples   []fetchSample
+	attempts  uint64
+	successes uint64
+}
+
+// adaptiveReadLimits decides which domains need the larger read limit
+// (e.g., sites that put a lot of JavaScript before the <title>), based on
+// recent fetches: domains start out on the larger limit if they're in
+// garbageJSDomains, and are moved between the limits as their pages change
+type adaptiveReadLimits struct {
+	sync.Mutex
+	domains map[string]*domainReadStats
+}
+
+func newAdaptiveReadLimits() *adaptiveReadLimits {
+	return &adaptiveReadLimits{domains: make(map[string]*domainReadStats)}
+}
+
+func (a *adaptiveReadLimits) getNoMutex(host string) *domainReadStats {
+	stats, ok := a.domains[host]
+	if !ok {
+		if len(a.domains) >= maxReadLimitDomains {
+			// forget an arbitrary domain
+			for domain := range a.domains {
+				delete(a.domains, domain)
+				break
+			}
+		}
+		stats = &domainReadStats{large: isGarbageJSDomain(host)}
+		a.domains[host] = stats
+	}
+	return stats
+}
+
+// large checks whether pages from host should be read with the larger limit
+func (a *adaptiveReadLimits) large(host string) bool {
+	a.Lock()
+	defer a.Unlock()
+	if stats, ok := a.domains[host]; ok {
+		return stats.large
+	}
+	return isGarbageJSDomain(host)
+}
+
+// record records the outcome of a fetch from host, returning whether this
+// changed the read limit for the domain (and if so, whether it's now large)
+func (a *adaptiveReadLimits) record(host string, sample fetchSample, normalLimit int) (changed, large bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	stats := a.getNoMutex(host)
+	stats.attempts++
+	if sample.found {
+		stats.successes++
+	}
+	if sample.large != stats.large {
+		// the limit changed while this fetch was in progress
+		return false, stats.large
+	}
+	stats.samples = append(stats.samples, sample)
+	if len(stats.samples) > readLimitSamples {
+		stats.samples = stats.samples[1:]
+	}
+
+	if !stats.large {
+		starved := 0
+		for _, s := range stats.samples {
+			if !s.found && s.truncated {
+				starved++
+			}
+		}
+		if len(stats.samples) >= readLimitMinPromote && float64(starved) >= readLimitPromoteRatio*float64(len(stats.samples)) {
+			changed = true
+		}
+	} else if len(stats.samples) >= readLimitMinDemote {
+		changed = true
+		for _, s := range stats.samples[len(stats.samples)-readLimitMinDemote:] {
+			if !s.found || s.needed > normalLimit {
+				changed = false
+				break
+			}
+		}
+	}
+	if changed {
+		stats.large = !stats.large
+		stats.samples = nil
+	}
+	return changed, stats.large
+}
+
+// successRate returns the fraction of fetches from host that found a title
+func (a *adaptiveReadLimits) successRate(host string) (rate float64, attempts uint64) {
+	a.Lock()
+	defer a.Unlock()
+	stats, ok := a.domains[host]
+	if !ok || stats.attempts == 0 {
+		return 0, 0
+	}
+	return float64(stats.successes) / float64(stats.attempts), stats.attempts
+}
+
+// recordReadLimitSample records the outcome of fetching url with the generic handler
+func (irc *Bot) recordReadLimitSample(url string, sample fetchSample) {
+	host, err := urlHost(url)
+	if err != nil {
+		return
+	}
+	if changed, large := irc.readLimits.record(host, sample, irc.settings.get().readLimit); changed {
+		if large {
+			irc.logInfo("titles need the larger read limit, promoting domain", "host", host)
+		} else {
+			irc.logInfo("titles fit in the normal read limit, demoting domain", "host", host)
+		}
+	}
+}
+
+// handleDomainCommand handles `domain <host>`, reporting how often we
+// find titles on the domain and which read limit it's using
+func (irc *Bot) handleDomainCommand(target, msgid string, f []string) {
+	if len(f) < 2 {
+		irc.sendReplyNotice(target, msgid, "usage: domain <host>")
+		return
+	}
+	host := strings.ToLower(f[1])
+	limit := "normal"
+	if irc.readLimits.large(host) {
+		limit = "large"
+	}
+	rate, attempts := irc.readLimits.successRate(host)
+	if attempts == 0 {
+		irc.sendReplyNotice(target, msgid, fmt.Sprintf("%s: no fetches yet; read limit: %s", displayHost(host), limit))
+		return
+	}
+	irc.sendReplyNotice(target, msgid, fmt.Sprintf("%s: found titles in %.0f%% of %d fetches; read limit: %s", displayHost(host), 100*rate, attempts, limit))
+}
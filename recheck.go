@@ -0,0 +1,139 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// how often to look for links that are due to be rechecked
+	recheckInterval = 10 * time.Minute
+	recheckBatch    = 50
+	// between rechecks, so that a batch isn't a burst of requests
+	recheckSpacing = 5 * time.Second
+	// links posted longer ago than this (plus the delay) aren't rechecked,
+	// e.g. the whole history when rechecking is first enabled
+	recheckWindow = 7 * 24 * time.Hour
+)
+
+// recheckEntry is a link in the history that's due to be rechecked
+type recheckEntry struct {
+	historyEntry
+	cutoff time.Time // rechecks the link's entries up to here
+}
+
+// dueForRecheck returns the links (one per channel) that were titled
+// between `since` and `until` and haven't been rechecked, oldest first
+func (h *historyStore) dueForRecheck(since, until time.Time, limit int) (result []recheckEntry, err error) {
+	rows, err := h.db.Query(`SELECT channel, url, title, nick, min(time),
+		(SELECT max(archive_url) FROM links AS archived WHERE archived.url = links.url) FROM links
+		WHERE rechecked = 0 AND title != '' AND time >= ? AND time <= ?
+		GROUP BY channel, url ORDER BY min(time) LIMIT ?`,
+		since.UnixNano(), until.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry := recheckEntry{cutoff: until}
+		var nanos int64
+		if err = rows.Scan(&entry.channel, &entry.url, &entry.title, &entry.nick, &nanos, &entry.archiveURL); err != nil {
+			return nil, err
+		}
+		entry.time = time.Unix(0, nanos)
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+// setRechecked records that a link posted in channel has been rechecked
+func (h *historyStore) setRechecked(channel, url string, until time.Time) error {
+	_, err := h.db.Exec(`UPDATE links SET rechecked = 1 WHERE channel = ? AND url = ? AND time <= ?`,
+		strings.ToLower(channel), url, until.UnixNano())
+	return err
+}
+
+// recheckLoop rechecks links some time (TITLEBOT_RECHECK_AFTER) after they
+// were titled, and reports the ones that have since died
+func (irc *Bot) recheckLoop() {
+	for {
+		select {
+		case <-time.After(recheckInterval):
+		case <-irc.ctx.Done():
+			return
+		}
+		if !irc.isActive() || !irc.Connected() {
+			continue
+		}
+		until := time.Now().Add(-irc.recheckAfter)
+		entries, err := irc.history.dueForRecheck(until.Add(-recheckWindow), until, recheckBatch)
+		if irc.reportErr(err, "couldn't find links to recheck") {
+			continue
+		}
+		for _, entry := range entries {
+			// (other schemes, like gopher, aren't rechecked)
+			if isWebURL(entry.url) {
+				if dead, reason := irc.linkDead(entry.url); dead {
+					irc.logInfo("link has died", "url", entry.url, "channel", entry.channel, "reason", reason)
+					irc.notifyDeadLink(entry.historyEntry, reason)
+				}
+			}
+			irc.reportErr(irc.history.setRechecked(entry.channel, entry.url, entry.cutoff), "couldn't record recheck", "url", entry.url)
+			select {
+			case <-time.After(recheckSpacing):
+			case <-irc.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// linkDead checks whether a link that used to work is now gone: the page
+// is 404 or 410, or the domain no longer exists. Other failures (timeouts,
+// server errors, etc.) may well be temporary, so they don't count.
+func (irc *Bot) linkDead(url string) (dead bool, reason string) {
+	req, err := http.NewRequestWithContext(irc.ctx, "GET", url, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if reason = describeNetworkError(err); reason == "domain not found" {
+			return true, reason
+		}
+		return false, ""
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, archiveResponseLimit))
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return true, describeStatus(resp.StatusCode)
+	}
+	return false, ""
+}
+
+// notifyDeadLink tells the channel where a link was posted (or the alert
+// targets, with TITLEBOT_RECHECK_NOTIFY=alerts) that it has died, pointing
+// to an archived copy
+func (irc *Bot) notifyDeadLink(entry historyEntry, reason string) {
+	message := fmt.Sprintf("dead link (%s): %s -- %s, posted by %s on %s",
+		reason, irc.shorten(displayURL(entry.url)), entry.title, entry.nick, entry.time.UTC().Format("2006-01-02"))
+	if entry.archiveURL != "" {
+		message += "; archived copy: " + irc.shorten(entry.archiveURL)
+	} else {
+		message += "; try the Wayback Machine: " + irc.shorten(waybackWebURL+entry.url)
+	}
+	if !irc.recheckAlerts {
+		irc.sendReplyNotice(entry.channel, "", message)
+		return
+	}
+	for _, target := range irc.alertTargets {
+		irc.queueMessage(nil, "PRIVMSG", target, entry.channel+": "+message, false)
+	}
+}
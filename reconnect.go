@@ -0,0 +1,141 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	reconnectMinDelay        = 5 * time.Second
+	defaultReconnectMaxDelay = 5 * time.Minute
+	// a connection that stayed up this long resets the backoff
+	reconnectStableAfter = 5 * time.Minute
+)
+
+// reconnectBackoff computes the delays between connection attempts:
+// they double with each attempt that doesn't lead to a stable connection,
+// up to maxDelay, and are jittered so that a fleet of bots doesn't
+// reconnect in lockstep after a netsplit
+type reconnectBackoff struct {
+	sync.Mutex
+	maxDelay    time.Duration
+	attempts    int       // attempts since the last stable connection
+	lastAttempt time.Time // when the last attempt started
+	connected   time.Time // zero while disconnected
+}
+
+// attempt records a connection attempt, returning how long to wait
+// after it before making the next one
+func (b *reconnectBackoff) attempt() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	b.lastAttempt = time.Now()
+	delay := b.delay()
+	b.attempts++
+	return delay
+}
+
+// retry returns how long to wait after the start of the last attempt before
+// making the next one, after a connection that was up: the session counts
+// as a failed attempt unless it was stable, and there's always a jittered
+// delay from now, so that the first retry isn't immediate
+func (b *reconnectBackoff) retry() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	return time.Since(b.lastAttempt) + b.delay()
+}
+
+// delay is the delay after the current attempt; b must be locked
+func (b *reconnectBackoff) delay() time.Duration {
+	delay := b.maxDelay
+	if b.attempts < 16 {
+		delay = min(reconnectMinDelay<<b.attempts, b.maxDelay)
+	}
+	// pick uniformly from [delay/2, delay):
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// connect records a successful registration, returning the number of
+// attempts it took since the last stable connection
+func (b *reconnectBackoff) connect() (attempts int) {
+	b.Lock()
+	defer b.Unlock()
+	b.connected = time.Now()
+	return b.attempts
+}
+
+// disconnect records a dropped connection, returning how long it was up
+func (b *reconnectBackoff) disconnect() (uptime time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	if !b.connected.IsZero() {
+		uptime = time.Since(b.connected)
+		if uptime >= reconnectStableAfter {
+			b.attempts = 0
+		}
+	}
+	b.connected = time.Time{}
+	return
+}
+
+// setupReconnect makes Loop() reconnect with exponential backoff after the
+// connection drops. SASL is redone by Connect(), and the connect callback
+// rejoins the configured channels plus the ones joined at runtime
+// (e.g., via INVITE), so nothing is lost across a netsplit.
+func (irc *Bot) setupReconnect() {
+	dialer := &net.Dialer{}
+	irc.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// Loop() waits until ReconnectFreq after the start of each attempt
+		// before making the next one (this runs on the Loop() goroutine):
+		irc.ReconnectFreq = irc.reconnect.attempt()
+		return dialer.DialContext(ctx, network, addr)
+	}
+	irc.AddConnectCallback(func(e ircmsg.Message) {
+		if attempts := irc.reconnect.connect(); attempts > 1 {
+			irc.logInfo("reconnected", "server", irc.Server, "attempts", attempts)
+		}
+	})
+	irc.AddDisconnectCallback(func(e ircmsg.Message) {
+		uptime := irc.reconnect.disconnect()
+		// Loop() reads this once we've returned, and measures it from the
+		// start of the attempt that led to this connection:
+		irc.ReconnectFreq = irc.reconnect.retry()
+		if irc.ctx.Err() == nil {
+			irc.logError("disconnected, reconnecting", "server", irc.Server, "uptime", uptime.Round(time.Second).String())
+		}
+	})
+}
+
+// connect makes the initial connection, retrying with the same backoff as
+// Loop() does when reconnecting (Loop() can only take over once a connection
+// has been made); it returns false if we shut down first
+func (irc *Bot) connect() bool {
+	for {
+		start := time.Now()
+		irc.ReconnectFreq = 0
+		err := irc.Connect()
+		if err == nil {
+			return true
+		} else if irc.ReconnectFreq == 0 {
+			// we didn't get as far as dialing: this is a configuration
+			// error, which won't go away by retrying
+			log.Fatal(err)
+		}
+		delay := time.Until(start.Add(irc.ReconnectFreq))
+		irc.logError("couldn't connect, retrying", "server", irc.Server, "error", err, "delay", delay.Round(time.Second).String())
+		select {
+		case <-time.After(delay):
+		case <-irc.ctx.Done():
+			return false
+		}
+	}
+}
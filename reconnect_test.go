@@ -0,0 +1,40 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	b := reconnectBackoff{maxDelay: time.Minute}
+	for i, expected := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		if delay := b.attempt(); delay < expected/2 || delay > expected {
+			t.Errorf("attempt %d: got a delay of %v, expected %v-%v", i+1, delay, expected/2, expected)
+		}
+	}
+
+	// a connection that drops right away counts as another failed attempt,
+	// and the retry is jittered even though the attempt started long ago
+	b.lastAttempt = time.Now().Add(-time.Hour)
+	b.connect()
+	b.disconnect()
+	if retry := time.Until(b.lastAttempt.Add(b.retry())); retry < 30*time.Second || retry > time.Minute {
+		t.Errorf("retrying in %v after an unstable connection", retry)
+	}
+
+	// a stable one resets the backoff, but the first retry is still delayed
+	b.attempt()
+	b.connect()
+	b.connected = time.Now().Add(-reconnectStableAfter)
+	b.lastAttempt = b.connected
+	b.disconnect()
+	if retry := time.Until(b.lastAttempt.Add(b.retry())); retry < reconnectMinDelay/2-time.Second || retry > reconnectMinDelay {
+		t.Errorf("retrying in %v after a stable connection", retry)
+	}
+	if delay := b.attempt(); delay > reconnectMinDelay {
+		t.Errorf("got a delay of %v after a stable connection", delay)
+	}
+}
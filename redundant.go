@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// separators between a page's title and the site name, e.g.
+	// "My Post | Example Blog"
+	titleSeparatorRe = regexp.MustCompile(`\s+[|\-–—·:»]\s+`)
+	// IDs in slugs, e.g. 12345-my-post or my-post-a1b2c3d4e5f6
+	slugIDRe = regexp.MustCompile(`^(?:[0-9]{4,}|[0-9a-f]{8,})$`)
+)
+
+// normalizeWords lowercases text and splits it into words, ignoring
+// punctuation, so that "Why Go's Great!" and why-gos-great compare equal
+func normalizeWords(text string) []string {
+	text = strings.ReplaceAll(strings.ToLower(text), "'", "")
+	text = strings.ReplaceAll(text, "’", "")
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// urlSlug returns the words of the last segment of a link's path, e.g.
+// "why go is great" for https://example.com/2024/05/why-go-is-great.html
+func urlSlug(urlStr string) (words []string) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil
+	}
+	segment := path.Base(strings.TrimRight(u.Path, "/"))
+	segment = strings.TrimSuffix(segment, path.Ext(segment))
+	for _, word := range normalizeWords(segment) {
+		if !slugIDRe.MatchString(word) {
+			words = append(words, word)
+		}
+	}
+	return
+}
+
+// titleRedundant checks whether a title would add nothing to the message
+// it's a reply to: because the link's slug is (substantially) the title, or
+// because the message already quotes it. Titles of a single word are
+// never redundant (e.g., "Home" and /home), since they're too easy to match.
+func titleRedundant(urlStr, title, message string) bool {
+	slug := urlSlug(urlStr)
+	messageText := " " + strings.Join(normalizeWords(stripURLs(message)), " ") + " "
+	// compare the whole title, and its longest part between separators
+	// (the rest is likely the site's name, which alone isn't redundant)
+	for _, part := range []string{title, longestTitlePart(title)} {
+		words := normalizeWords(part)
+		if len(words) < 2 {
+			continue
+		}
+		if wordsOverlap(words, slug) || strings.Contains(messageText, " "+strings.Join(words, " ")+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// longestTitlePart returns the longest of the parts of a title between
+// separators, e.g. "My Post" for "My Post | Blog"
+func longestTitlePart(title string) (longest string) {
+	for _, part := range titleSeparatorRe.Split(title, -1) {
+		if len(part) > len(longest) {
+			longest = part
+		}
+	}
+	return
+}
+
+// wordsOverlap checks whether at least 80% of the words in each of a and b
+// are in the other; slugs often leave out a word or two of the title
+func wordsOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	return overlap(a, b)*10 >= 8*len(a) && overlap(b, a)*10 >= 8*len(b)
+}
+
+// overlap counts the words of a that are in b
+func overlap(a, b []string) (count int) {
+	set := make(map[string]bool, len(b))
+	for _, word := range b {
+		set[word] = true
+	}
+	for _, word := range a {
+		if set[word] {
+			count++
+		}
+	}
+	return
+}
+
+// stripURLs removes the URLs from a message, leaving the text around them
+func stripURLs(message string) string {
+	spans := scanURLs(message)
+	for i := len(spans) - 1; i >= 0; i-- {
+		message = message[:spans[i][0]] + " " + message[spans[i][1]:]
+	}
+	return message
+}
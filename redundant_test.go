@@ -0,0 +1,52 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestURLSlug(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected []string
+	}{
+		{"https://example.com/2024/05/why-go-is-great.html", []string{"why", "go", "is", "great"}},
+		{"https://example.com/12345-my-post/", []string{"my", "post"}},
+		{"https://example.com/my-post-a1b2c3d4e5f6", []string{"my", "post"}},
+		{"https://example.com/", nil},
+		{"https://example.com", nil},
+	}
+	for _, c := range cases {
+		if slug := urlSlug(c.url); !reflect.DeepEqual(slug, c.expected) {
+			t.Errorf("urlSlug(%q) = %v, expected %v", c.url, slug, c.expected)
+		}
+	}
+}
+
+func TestTitleRedundant(t *testing.T) {
+	cases := []struct {
+		url, title, message string
+		expected            bool
+	}{
+		{"https://example.com/2024/05/why-go-is-great.html", "Why Go Is Great", "", true},
+		{"https://example.com/2024/05/why-go-is-great.html", "Why Go's Great!", "", false},
+		{"https://example.com/why-go-is-really-great", "Why Go Is Really So Great", "", true},
+		{"https://example.com/why-go-is-great", "Why Go Is Great | Example Blog", "", true},
+		{"https://example.com/p/123", "Some Interesting Findings", "Some interesting findings: https://example.com/p/123", true},
+		{"https://example.com/p/123", "Some Interesting Findings - Example Blog", "some interesting findings https://example.com/p/123", true},
+		// the site's name alone doesn't make the title redundant
+		{"https://example.com/p/123", "An Unrelated Post | Example Blog", "Example Blog has a new post: https://example.com/p/123", false},
+		{"https://example.com/example-blog", "An Unrelated Post | Example Blog", "", false},
+		// single words are never redundant
+		{"https://example.com/home", "Home", "", false},
+		{"https://example.com/p/123", "Something Else Entirely", "look at this https://example.com/p/123", false},
+	}
+	for _, c := range cases {
+		if result := titleRedundant(c.url, c.title, c.message); result != c.expected {
+			t.Errorf("titleRedundant(%q, %q, %q) = %t, expected %t", c.url, c.title, c.message, result, c.expected)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircfmt"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+var (
+	// common formats used by bridge bots to relay messages from other
+	// networks and protocols: `<nick> message`, `[nick] message`, and
+	// matterbridge's default `[protocol] <nick> message`
+	relayRe = regexp.MustCompile(`^(?:\[[^\]\s]+\]\s+)?[<\[]([^>\]\s]+)[>\]]\s+(.*)$`)
+
+	// bridges often insert invisible characters into relayed nicks,
+	// so that relaying a message doesn't highlight an IRC user
+	relayNickReplacer = strings.NewReplacer(
+		"\u200b", "", // ZERO WIDTH SPACE
+		"\u200c", "", // ZERO WIDTH NON-JOINER
+		"\u200d", "", // ZERO WIDTH JOINER
+		"\u2060", "", // WORD JOINER
+		"\ufeff", "", // ZERO WIDTH NO-BREAK SPACE
+	)
+)
+
+// unwrapRelay checks whether a message was sent by a configured bridge bot;
+// if so, it either rewrites the message to appear to come from the original
+// author, or returns ok == false to indicate that it should be ignored.
+// The rewritten message's source is `nick!relay@bridge`, and its account
+// is `nick@bridge`, so that the ignore list (which matches entries like
+// nick@bridge against it) and opt-outs work as usual (a real account name
+// can't contain '@').
+func (irc *Bot) unwrapRelay(e ircmsg.Message) (result ircmsg.Message, ok bool) {
+	if irc.relayNicksRe == nil || len(e.Params) < 2 {
+		return e, true
+	}
+	bridge := e.Nick()
+	if !irc.relayNicksRe.MatchString(bridge) {
+		return e, true
+	}
+	if irc.ignoreRelays {
+		return e, false
+	}
+	// relayed CTCP ACTIONs and similar are left as-is
+	match := relayRe.FindStringSubmatch(e.Params[1])
+	if match == nil {
+		return e, true
+	}
+	nick := relayNickReplacer.Replace(ircfmt.Strip(match[1]))
+	if nick == "" {
+		return e, true
+	}
+	params := append([]string(nil), e.Params...)
+	params[1] = match[2]
+	result = ircmsg.MakeMessage(e.AllTags(), fmt.Sprintf("%s!relay@%s", nick, bridge), e.Command, params...)
+	result.SetTag("account", fmt.Sprintf("%s@%s", nick, bridge))
+	// the bridge itself may be flagged as a bot, but the original author isn't
+	result.DeleteTag(botTagName)
+	return result, true
+}
+
+// relayedSender checks whether a message was rewritten by unwrapRelay. Its
+// author is whoever the bridge says it is, so it gets no permissions: no
+// ACL level, and no channel operator commands even if the nick is an op's.
+func relayedSender(e ircmsg.Message) bool {
+	return strings.Contains(messageAccount(e), "@")
+}
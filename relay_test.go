@@ -0,0 +1,145 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func testRelayBot(t *testing.T) *Bot {
+	t.Helper()
+	state, err := loadState(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	irc := &Bot{
+		relayNicksRe: regexp.MustCompile(`(?i)^(?:matterbridge|discord-relay)$`),
+		state:        state,
+		sendQueue:    newSendQueue(1000, 100),
+	}
+	irc.channels.reset()
+	return irc
+}
+
+func testParseLine(t *testing.T, line string) ircmsg.Message {
+	t.Helper()
+	e, err := ircmsg.ParseLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestUnwrapRelay(t *testing.T) {
+	irc := testRelayBot(t)
+	cases := []struct {
+		line    string
+		source  string
+		message string
+		account string // "" if it shouldn't be set
+	}{
+		{":matterbridge!mb@host PRIVMSG #chat :<alice> hello there", "alice!relay@matterbridge", "hello there", "alice@matterbridge"},
+		{":matterbridge!mb@host PRIVMSG #chat :[alice] hello", "alice!relay@matterbridge", "hello", "alice@matterbridge"},
+		// matterbridge's default format, e.g. from Discord or Matrix
+		{":matterbridge!mb@host PRIVMSG #chat :[discord] <alice> https://example.com", "alice!relay@matterbridge", "https://example.com", "alice@matterbridge"},
+		{":Discord-Relay!dr@host PRIVMSG #chat :[matrix] <al\u200bi\u200dce> hi", "alice!relay@Discord-Relay", "hi", "alice@Discord-Relay"},
+		{":matterbridge!mb@host PRIVMSG #chat :<\x02\x0304alice\x0f> hi", "alice!relay@matterbridge", "hi", "alice@matterbridge"},
+		// the bridge's own account, and bot tag, don't carry over
+		{"@account=bridgeaccount;bot :matterbridge!mb@host PRIVMSG #chat :<alice> hi", "alice!relay@matterbridge", "hi", "alice@matterbridge"},
+		// left as-is: no nick, an action, not a bridge
+		{":matterbridge!mb@host PRIVMSG #chat :just a message", "matterbridge!mb@host", "just a message", ""},
+		{":matterbridge!mb@host PRIVMSG #chat :<> hi", "matterbridge!mb@host", "<> hi", ""},
+		{":matterbridge!mb@host PRIVMSG #chat :\x01ACTION <alice> waves\x01", "matterbridge!mb@host", "\x01ACTION <alice> waves\x01", ""},
+		{":bob!b@host PRIVMSG #chat :<alice> hi", "bob!b@host", "<alice> hi", ""},
+	}
+	for _, c := range cases {
+		e, ok := irc.unwrapRelay(testParseLine(t, c.line))
+		if !ok || e.Source != c.source || e.Params[1] != c.message {
+			t.Errorf("unwrapRelay(%q) = %q, %q, %t; expected %q, %q", c.line, e.Source, e.Params[1], ok, c.source, c.message)
+		}
+		if c.account != "" {
+			if account := messageAccount(e); account != c.account {
+				t.Errorf("unwrapRelay(%q): got account %q, expected %q", c.line, account, c.account)
+			}
+			if present, _ := e.GetTag(botTagName); present {
+				t.Errorf("unwrapRelay(%q): the bot tag was kept", c.line)
+			}
+			if !relayedSender(e) {
+				t.Errorf("unwrapRelay(%q) isn't a relayed sender", c.line)
+			}
+		} else if relayedSender(e) {
+			t.Errorf("unwrapRelay(%q) is a relayed sender", c.line)
+		}
+	}
+
+	irc.ignoreRelays = true
+	if _, ok := irc.unwrapRelay(testParseLine(t, ":matterbridge!mb@host PRIVMSG #chat :<alice> hi")); ok {
+		t.Error("relayed message wasn't ignored with TITLEBOT_RELAY_MODE=ignore")
+	}
+}
+
+func TestRelayedIgnore(t *testing.T) {
+	irc := testRelayBot(t)
+	for _, entry := range []string{"alice@matterbridge", "*@discord-relay"} {
+		if _, err := irc.state.setIgnore(entry, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		line    string
+		ignored bool
+	}{
+		{":matterbridge!mb@host PRIVMSG #chat :<alice> hi", true},
+		{":matterbridge!mb@host PRIVMSG #chat :<bob> hi", false},
+		{":discord-relay!dr@host PRIVMSG #chat :<bob> hi", true},
+		{"@account=alice :alice!a@host PRIVMSG #chat :hi", false},
+	}
+	for _, c := range cases {
+		e, _ := irc.unwrapRelay(testParseLine(t, c.line))
+		if ignored := irc.ignoreSender(e); ignored != c.ignored {
+			t.Errorf("ignoreSender(%q) = %t, expected %t", c.line, ignored, c.ignored)
+		}
+	}
+}
+
+// relayed messages can claim to come from anyone, so they mustn't get the
+// permissions of the account or channel operator they claim to be
+func TestRelayedPermissions(t *testing.T) {
+	irc := testRelayBot(t)
+	var err error
+	if irc.ACL, err = parseACL("alice:admin,alice@matterbridge:admin"); err != nil {
+		t.Fatal(err)
+	}
+	irc.channels.joined("#chat", "matterbridge", false)
+	irc.channels.joined("#chat", "alice", false)
+	irc.channels.setPrefix("#chat", "alice", '@', true)
+
+	relayed, _ := irc.unwrapRelay(testParseLine(t, "@account=alice :matterbridge!mb@host PRIVMSG #chat :<alice> titlebot: disable"))
+	if level := irc.ACL.level(relayed); level != permNone {
+		t.Errorf("relayed message has permission level %d", level)
+	}
+	if !irc.handleChannelCommand(relayed, "#chat", "", irc.ACL.level(relayed), []string{"disable"}) {
+		t.Fatal("disable wasn't handled")
+	}
+	if irc.state.getChannelSettings("#chat").Disabled {
+		t.Error("a relayed message disabled titling as a channel operator")
+	}
+	if len(irc.sendQueue.replies) != 1 || irc.sendQueue.replies[0].text != "only channel operators can do that" {
+		t.Errorf("got replies %+v", irc.sendQueue.replies)
+	}
+
+	// but the real alice can
+	direct := testParseLine(t, ":alice!a@host PRIVMSG #chat :titlebot: disable")
+	irc.handleChannelCommand(direct, "#chat", "", irc.ACL.level(direct), []string{"disable"})
+	if !irc.state.getChannelSettings("#chat").Disabled {
+		t.Error("a channel operator couldn't disable titling")
+	}
+	admin := testParseLine(t, "@account=alice :alice!a@host PRIVMSG #chat :titlebot: quit")
+	if level := irc.ACL.level(admin); level != permAdmin {
+		t.Errorf("alice's account has permission level %d, expected admin", level)
+	}
+}
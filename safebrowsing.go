@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	safeBrowsingEndpoint = "https://safebrowsing.googleapis.com/v4/threatMatches:find?key=%s"
+	urlhausEndpoint      = "https://urlhaus-api.abuse.ch/v1/url/"
+	threatCheckReadLimit = 64 * 1024
+
+	maliciousWarning = "⚠ warning: this link has been reported as malicious (%s)" // 'WARNING SIGN' (U+26A0)
+)
+
+type safeBrowsingEntry struct {
+	URL string `json:"url"`
+}
+
+type safeBrowsingRequest struct {
+	Client struct {
+		ClientID      string `json:"clientId"`
+		ClientVersion string `json:"clientVersion"`
+	} `json:"client"`
+	ThreatInfo struct {
+		ThreatTypes      []string            `json:"threatTypes"`
+		PlatformTypes    []string            `json:"platformTypes"`
+		ThreatEntryTypes []string            `json:"threatEntryTypes"`
+		ThreatEntries    []safeBrowsingEntry `json:"threatEntries"`
+	} `json:"threatInfo"`
+}
+
+type safeBrowsingResponse struct {
+	Matches []struct {
+		ThreatType string `json:"threatType"`
+	} `json:"matches"`
+}
+
+// checkSafeBrowsing looks up a URL with the Google Safe Browsing Lookup API (v4),
+// returning a description of the threat if it's listed
+func (irc *Bot) checkSafeBrowsing(urlStr string) (threat string, err error) {
+	var sbreq safeBrowsingRequest
+	sbreq.Client.ClientID = "titlebot"
+	sbreq.Client.ClientVersion = "1.0"
+	sbreq.ThreatInfo.ThreatTypes = []string{"MALWARE", "SOCIAL_ENGINEERING", "UNWANTED_SOFTWARE", "POTENTIALLY_HARMFUL_APPLICATION"}
+	sbreq.ThreatInfo.PlatformTypes = []string{"ANY_PLATFORM"}
+	sbreq.ThreatInfo.ThreatEntryTypes = []string{"URL"}
+	sbreq.ThreatInfo.ThreatEntries = []safeBrowsingEntry{{URL: urlStr}}
+	reqBody, err := json.Marshal(&sbreq)
+	if err != nil {
+		return
+	}
+	endpoint := fmt.Sprintf(safeBrowsingEndpoint, url.QueryEscape(irc.safeBrowsingKey))
+	req, err := http.NewRequestWithContext(irc.ctx, "POST", endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad http code %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(&io.LimitedReader{R: resp.Body, N: threatCheckReadLimit})
+	if err != nil {
+		return
+	}
+	var sbresp safeBrowsingResponse
+	if err = json.Unmarshal(body, &sbresp); err != nil {
+		return
+	}
+	if len(sbresp.Matches) != 0 {
+		threat = "Safe Browsing: " + strings.ToLower(strings.ReplaceAll(sbresp.Matches[0].ThreatType, "_", " "))
+	}
+	return
+}
+
+type urlhausResponse struct {
+	QueryStatus string `json:"query_status"`
+	URLStatus   string `json:"url_status"`
+	Threat      string `json:"threat"`
+}
+
+// checkURLhaus looks up a URL in the abuse.ch URLhaus database
+func (irc *Bot) checkURLhaus(urlStr string) (threat string, err error) {
+	req, err := http.NewRequestWithContext(irc.ctx, "POST", urlhausEndpoint, strings.NewReader(url.Values{"url": {urlStr}}.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if irc.urlhausKey != "" {
+		req.Header.Set("Auth-Key", irc.urlhausKey)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad http code %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(&io.LimitedReader{R: resp.Body, N: threatCheckReadLimit})
+	if err != nil {
+		return
+	}
+	var uhresp urlhausResponse
+	if err = json.Unmarshal(body, &uhresp); err != nil {
+		return
+	}
+	// "ok" means the URL is in the database; "no_results" means it isn't
+	if uhresp.QueryStatus == "ok" && uhresp.URLStatus != "offline" {
+		threat = "URLhaus"
+		if uhresp.Threat != "" {
+			threat += ": " + strings.ReplaceAll(uhresp.Threat, "_", " ")
+		}
+	}
+	return
+}
+
+// checkMalicious checks a URL against the configured reputation services
+// before we fetch it. If a lookup fails, we assume the URL is OK.
+func (irc *Bot) checkMalicious(urlStr string) (threat string) {
+	if irc.safeBrowsingKey != "" {
+		threat, err := irc.checkSafeBrowsing(urlStr)
+		if !irc.checkErr(err, "error checking Safe Browsing") && threat != "" {
+			return threat
+		}
+	}
+	if irc.useURLhaus {
+		threat, err := irc.checkURLhaus(urlStr)
+		if !irc.checkErr(err, "error checking URLhaus") && threat != "" {
+			return threat
+		}
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// servers disconnect clients for excess flood at around this rate;
+	// see TITLEBOT_SEND_RATE and TITLEBOT_SEND_BURST
+	defaultSendRate  = 0.5 // messages per second
+	defaultSendBurst = 5
+	// once this many titles are waiting, titles that have waited longer
+	// than staleTitleAge are dropped rather than sent
+	sendQueueBackedUp = 16
+	staleTitleAge     = 30 * time.Second
+	// how often shutdown checks whether the queue has been flushed
+	sendQueueFlushPoll = 50 * time.Millisecond
+)
+
+// outgoing is a message waiting to be sent
+type outgoing struct {
+	tags    map[string]string
+	command string
+	target  string
+	text    string
+	queued  time.Time
+}
+
+// sendQueue paces our messages to the server so that a burst of titles
+// doesn't get the bot disconnected for flooding. Replies to commands and
+// alerts go ahead of titles, and titles are coalesced or dropped when the
+// queue backs up. (PONG and the like are sent by ircevent directly, so they
+// never wait behind us.)
+type sendQueue struct {
+	sync.Mutex
+	bucket  *tokenBucket
+	replies []outgoing
+	titles  []outgoing
+	wake    chan empty
+	dropped int
+	sending int        // taken from the queue but not yet written
+	stop    chan empty // closed by shutdown once the queue is flushed
+}
+
+func newSendQueue(rate float64, burst int) *sendQueue {
+	return &sendQueue{
+		bucket: newTokenBucket(float64(burst), rate),
+		wake:   make(chan empty, 1),
+		stop:   make(chan empty),
+	}
+}
+
+// add queues a message; titles that duplicate one already waiting for the
+// same target are coalesced into it
+func (q *sendQueue) add(msg outgoing, title bool) {
+	q.Lock()
+	if title {
+		for _, waiting := range q.titles {
+			if waiting.text == msg.text && strings.EqualFold(waiting.target, msg.target) {
+				q.dropped++
+				q.Unlock()
+				return
+			}
+		}
+		q.titles = append(q.titles, msg)
+	} else {
+		q.replies = append(q.replies, msg)
+	}
+	q.Unlock()
+	select {
+	case q.wake <- empty{}:
+	default:
+	}
+}
+
+// next waits until a message can be sent, returning ok=false once done is
+// closed; the caller must call sent after writing the message
+func (q *sendQueue) next(done <-chan empty) (msg outgoing, ok bool) {
+	for {
+		q.Lock()
+		now := time.Now()
+		if len(q.titles) > sendQueueBackedUp {
+			kept := q.titles[:0]
+			for _, title := range q.titles {
+				if now.Sub(title.queued) < staleTitleAge {
+					kept = append(kept, title)
+				}
+			}
+			q.dropped += len(q.titles) - len(kept)
+			q.titles = kept
+		}
+		var wait time.Duration
+		switch {
+		case len(q.replies) == 0 && len(q.titles) == 0:
+			wait = -1
+		case !q.bucket.take(now, 0):
+			wait = time.Duration((1 - q.bucket.tokens) / q.bucket.rate * float64(time.Second))
+		case len(q.replies) != 0:
+			msg, q.replies = q.replies[0], q.replies[1:]
+			q.sending++
+		default:
+			msg, q.titles = q.titles[0], q.titles[1:]
+			q.sending++
+		}
+		q.Unlock()
+		if wait == 0 {
+			return msg, true
+		}
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-q.wake:
+		case <-timer:
+		case <-done:
+			return
+		}
+	}
+}
+
+// sent records that a message returned by next has been written
+func (q *sendQueue) sent() {
+	q.Lock()
+	q.sending--
+	q.Unlock()
+}
+
+// size returns the number of messages not yet written and the number dropped
+func (q *sendQueue) size() (waiting, dropped int) {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.replies) + len(q.titles) + q.sending, q.dropped
+}
+
+// flush waits (until deadline) for the queued messages to be written
+func (q *sendQueue) flush(deadline time.Time) bool {
+	for {
+		if waiting, _ := q.size(); waiting == 0 {
+			return true
+		} else if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(sendQueueFlushPoll)
+	}
+}
+
+// sendLoop sends the messages from the send queue as the rate allows; it
+// keeps going during shutdown (which flushes the queue) until it's stopped
+func (irc *Bot) sendLoop() {
+	for {
+		msg, ok := irc.sendQueue.next(irc.sendQueue.stop)
+		if !ok {
+			return
+		}
+		irc.SendWithTags(msg.tags, msg.command, msg.target, msg.text)
+		irc.sendQueue.sent()
+	}
+}
+
+// queueMessage sends a message via the send queue, if there is one
+func (irc *Bot) queueMessage(tags map[string]string, command, target, text string, title bool) {
+	if irc.sendQueue == nil {
+		irc.SendWithTags(tags, command, target, text)
+		return
+	}
+	irc.sendQueue.add(outgoing{tags: tags, command: command, target: target, text: text, queued: time.Now()}, title)
+}
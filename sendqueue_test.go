@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// nextTexts takes n messages from the queue, which must be available now
+func nextTexts(t *testing.T, q *sendQueue, n int) (texts []string) {
+	t.Helper()
+	done := make(chan empty)
+	close(done)
+	for i := 0; i < n; i++ {
+		msg, ok := q.next(done)
+		if !ok {
+			t.Fatalf("expected %d messages, got %v", n, texts)
+		}
+		q.sent()
+		texts = append(texts, msg.text)
+	}
+	return
+}
+
+func TestSendQueueNext(t *testing.T) {
+	now := time.Now()
+	stale := now.Add(-2 * staleTitleAge)
+	type queued struct {
+		text, target string
+		title        bool
+		queued       time.Time
+	}
+	type testCase struct {
+		name     string
+		queue    []queued
+		expected []string
+		dropped  int
+	}
+	cases := []testCase{
+		{"empty", nil, nil, 0},
+		{"replies before titles",
+			[]queued{{"title 1", "#a", true, now}, {"reply", "#a", false, now}, {"title 2", "#b", true, now}},
+			[]string{"reply", "title 1", "title 2"}, 0},
+		{"duplicate titles are coalesced",
+			[]queued{{"title", "#a", true, now}, {"title", "#A", true, now}, {"title", "#b", true, now}, {"reply", "#a", false, now}, {"reply", "#a", false, now}},
+			[]string{"reply", "reply", "title", "title"}, 1},
+		{"stale titles are kept unless the queue is backed up",
+			[]queued{{"old", "#a", true, stale}, {"new", "#a", true, now}},
+			[]string{"old", "new"}, 0},
+	}
+	backedUp := []queued{{"reply", "#a", false, stale}}
+	for i := 0; i <= sendQueueBackedUp; i++ {
+		backedUp = append(backedUp, queued{string(rune('a' + i)), "#a", true, stale})
+	}
+	backedUp = append(backedUp, queued{"new", "#a", true, now})
+	cases = append(cases, testCase{"stale titles are dropped when the queue is backed up", backedUp, []string{"reply", "new"}, sendQueueBackedUp + 1})
+
+	for _, c := range cases {
+		q := newSendQueue(1000, 100)
+		for _, msg := range c.queue {
+			q.add(outgoing{command: "PRIVMSG", target: msg.target, text: msg.text, queued: msg.queued}, msg.title)
+		}
+		if texts := nextTexts(t, q, len(c.expected)); !reflect.DeepEqual(texts, c.expected) {
+			t.Errorf("%s: got %v, expected %v", c.name, texts, c.expected)
+		}
+		done := make(chan empty)
+		close(done)
+		if msg, ok := q.next(done); ok {
+			t.Errorf("%s: unexpected message %q", c.name, msg.text)
+		}
+		if waiting, dropped := q.size(); waiting != 0 || dropped != c.dropped {
+			t.Errorf("%s: %d waiting and %d dropped, expected 0 and %d", c.name, waiting, dropped, c.dropped)
+		}
+	}
+}
+
+func TestSendQueueRate(t *testing.T) {
+	q := newSendQueue(1000, 2)
+	for _, text := range []string{"a", "b", "c"} {
+		q.add(outgoing{text: text, queued: time.Now()}, false)
+	}
+	nextTexts(t, q, 2)
+	// the burst is used up, so the third waits for a token
+	done := make(chan empty)
+	close(done)
+	if msg, ok := q.next(done); ok {
+		t.Errorf("sent %q beyond the burst", msg.text)
+	}
+	if msg, ok := q.next(make(chan empty)); !ok || msg.text != "c" {
+		t.Errorf("got %q, %t; expected c after waiting", msg.text, ok)
+	}
+	if waiting, _ := q.size(); waiting != 1 {
+		t.Errorf("a message being sent should count as waiting, got %d", waiting)
+	}
+	q.sent()
+	if waiting, _ := q.size(); waiting != 0 {
+		t.Errorf("got %d waiting after sending everything", waiting)
+	}
+}
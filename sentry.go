@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+const sentryFlushTimeout = 2 * time.Second
+
+// initSentry enables reporting panics and unexpected errors to Sentry
+func initSentry(dsn, release string) error {
+	return sentry.Init(sentry.ClientOptions{
+		Dsn:     dsn,
+		Release: release,
+	})
+}
+
+// withSentryScope captures an event to Sentry (if it's enabled), with
+// the key-value attributes (as passed to checkErr) set as tags
+func (irc *Bot) withSentryScope(args []interface{}, capture func(*sentry.Hub)) {
+	if !irc.sentryEnabled {
+		return
+	}
+	hub := sentry.CurrentHub().Clone()
+	hub.WithScope(func(scope *sentry.Scope) {
+		for i := 0; i+1 < len(args); i += 2 {
+			scope.SetTag(fmt.Sprint(args[i]), fmt.Sprint(args[i+1]))
+		}
+		capture(hub)
+	})
+}
+
+// reportPanic reports a recovered panic; call it from the deferred function
+// that recovered, so that the stack trace is still available
+func (irc *Bot) reportPanic(r interface{}, args ...interface{}) {
+	irc.withSentryScope(args, func(hub *sentry.Hub) { hub.Recover(r) })
+}
+
+// reportErr is like checkErr, but for errors that indicate a problem with
+// the bot itself (e.g., it can't save its state), which are also reported
+func (irc *Bot) reportErr(err error, message string, args ...interface{}) (fatal bool) {
+	if !irc.checkErr(err, message, args...) {
+		return false
+	}
+	irc.withSentryScope(args, func(hub *sentry.Hub) {
+		hub.CaptureException(fmt.Errorf("%s: %w", message, err))
+	})
+	return true
+}
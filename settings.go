@@ -0,0 +1,172 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errInvalidSetting = errors.New("unknown setting")
+
+// maxReadLimit caps the read limits, since each title request may buffer
+// that much of a page in memory
+const maxReadLimit = 16 * 1024 * 1024
+
+// tunables are the settings that can be changed at runtime with the set command
+type tunables struct {
+	userAgent        string
+	readLimit        int // bytes to read from most pages
+	trustedReadLimit int // bytes to read from sites that need more (e.g., YouTube)
+	maxURLs          int // maximum number of links to title from one message
+}
+
+type runtimeSettings struct {
+	sync.Mutex
+	values tunables
+}
+
+func (s *runtimeSettings) get() tunables {
+	s.Lock()
+	defer s.Unlock()
+	return s.values
+}
+
+func (s *runtimeSettings) update(f func(*tunables)) {
+	s.Lock()
+	defer s.Unlock()
+	f(&s.values)
+}
+
+type settingDef struct {
+	show  func(irc *Bot) string
+	apply func(irc *Bot, value string) error
+}
+
+func parsePositiveInt(value string) (int, error) {
+	result, err := strconv.Atoi(value)
+	if err == nil && result <= 0 {
+		err = errors.New("value must be positive")
+	}
+	return result, err
+}
+
+// intSetting is a positive integer setting, at most max (unless it's 0)
+func intSetting(field func(*tunables) *int, max int) settingDef {
+	return settingDef{
+		show: func(irc *Bot) string {
+			values := irc.settings.get()
+			return strconv.Itoa(*field(&values))
+		},
+		apply: func(irc *Bot, value string) error {
+			n, err := parsePositiveInt(value)
+			if err != nil {
+				return err
+			} else if max != 0 && n > max {
+				return fmt.Errorf("value must be at most %d", max)
+			}
+			irc.settings.update(func(t *tunables) { *field(t) = n })
+			return nil
+		},
+	}
+}
+
+// settingDefs are the settings recognized by the set command, keyed by name
+var settingDefs = map[string]settingDef{
+	"useragent": {
+		show: func(irc *Bot) string { return irc.settings.get().userAgent },
+		apply: func(irc *Bot, value string) error {
+			irc.settings.update(func(t *tunables) { t.userAgent = value })
+			return nil
+		},
+	},
+	"readlimit":        intSetting(func(t *tunables) *int { return &t.readLimit }, maxReadLimit),
+	"trustedreadlimit": intSetting(func(t *tunables) *int { return &t.trustedReadLimit }, maxReadLimit),
+	"maxurls":          intSetting(func(t *tunables) *int { return &t.maxURLs }, 0),
+	"debug": {
+		show: func(irc *Bot) string {
+			if irc.logEnabled(levelDebug, "") {
+				return "on"
+			}
+			return "off"
+		},
+		apply: func(irc *Bot, value string) error {
+			switch strings.ToLower(value) {
+			case "on", "true", "1":
+				irc.setLogLevel(levelDebug)
+			case "off", "false", "0":
+				irc.setLogLevel(levelInfo)
+			default:
+				return errors.New("value must be on or off")
+			}
+			return nil
+		},
+	},
+}
+
+// applySetting changes a setting, without persisting it
+func (irc *Bot) applySetting(key, value string) error {
+	def, ok := settingDefs[key]
+	if !ok {
+		return errInvalidSetting
+	}
+	return def.apply(irc, value)
+}
+
+// loadSettings applies the settings persisted by the set command, remembering
+// the values they override so that `set <key> default` can restore them
+func (irc *Bot) loadSettings() {
+	irc.settingDefaults = make(map[string]string, len(settingDefs))
+	for key, def := range settingDefs {
+		irc.settingDefaults[key] = def.show(irc)
+	}
+	for key, value := range irc.state.getSettings() {
+		if err := irc.applySetting(key, value); err != nil {
+			irc.logError("ignoring persisted setting", "key", key, "value", value, "error", err)
+		}
+	}
+}
+
+func (irc *Bot) handleSetCommand(target, msgid string, f []string) {
+	if len(f) < 2 {
+		keys := make([]string, 0, len(settingDefs))
+		for key := range settingDefs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			keys[i] = fmt.Sprintf("%s=%s", key, settingDefs[key].show(irc))
+		}
+		irc.sendReplyNotice(target, msgid, "settings: "+strings.Join(keys, ", "))
+		return
+	}
+	key := strings.ToLower(f[1])
+	def, ok := settingDefs[key]
+	if !ok {
+		irc.sendReplyNotice(target, msgid, fmt.Sprintf("unknown setting %s", f[1]))
+		return
+	}
+	if len(f) < 3 {
+		irc.sendReplyNotice(target, msgid, fmt.Sprintf("%s=%s", key, def.show(irc)))
+		return
+	}
+	value := strings.Join(f[2:], " ")
+	persisted := value
+	if strings.ToLower(value) == "default" {
+		value, persisted = irc.settingDefaults[key], ""
+	}
+	if err := def.apply(irc, value); err != nil {
+		irc.sendReplyNotice(target, msgid, fmt.Sprintf("invalid value for %s: %v", key, err))
+		return
+	}
+	if irc.reportErr(irc.state.setSetting(key, persisted), "couldn't save settings", "key", key) {
+		irc.sendReplyNotice(target, msgid, fmt.Sprintf("set %s=%s, but couldn't save it", key, def.show(irc)))
+		return
+	}
+	irc.sendReplyNotice(target, msgid, fmt.Sprintf("set %s=%s", key, def.show(irc)))
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// URLs in our output longer than this are shortened
+	defaultShortenLength = 80
+	shortenerTimeout     = 5 * time.Second
+	shortenerCacheLimit  = 1024
+	// how much of a shortener's response we read
+	shortenerResponseLimit = 64 * 1024
+)
+
+// shortener shortens long URLs that we mention in our own output (e.g.,
+// archive links), using a self-hosted Shlink, YOURLS, or Kutt instance
+type shortener struct {
+	kind      string // shlink, yourls, or kutt
+	base      string // base URL of the instance
+	token     string // API key (for YOURLS, the signature token)
+	minLength int
+
+	sync.Mutex
+	cache map[string]string
+}
+
+func newShortener(kind, base, token string, minLength int) (*shortener, error) {
+	kind = strings.ToLower(kind)
+	switch kind {
+	case "shlink", "yourls", "kutt":
+	default:
+		return nil, fmt.Errorf("unknown shortener %q: must be shlink, yourls, or kutt", kind)
+	}
+	if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid shortener URL %q", base)
+	}
+	return &shortener{
+		kind:      kind,
+		base:      strings.TrimSuffix(base, "/"),
+		token:     token,
+		minLength: minLength,
+		cache:     make(map[string]string),
+	}, nil
+}
+
+// shorten returns a short URL for long, or long itself if it's short
+// enough already, shortening is disabled, or the shortener fails
+func (irc *Bot) shorten(long string) string {
+	s := irc.shortener
+	if s == nil || len(long) <= s.minLength {
+		return long
+	}
+	s.Lock()
+	short, ok := s.cache[long]
+	s.Unlock()
+	if ok {
+		return short
+	}
+	short, err := irc.requestShortURL(long)
+	if irc.checkErr(err, "couldn't shorten URL", "url", long) || short == "" {
+		return long
+	}
+	s.Lock()
+	if len(s.cache) >= shortenerCacheLimit {
+		clear(s.cache)
+	}
+	s.cache[long] = short
+	s.Unlock()
+	return short
+}
+
+func (irc *Bot) requestShortURL(long string) (short string, err error) {
+	s := irc.shortener
+	ctx, cancel := context.WithTimeout(irc.ctx, shortenerTimeout)
+	defer cancel()
+	var req *http.Request
+	var field string // the field of the JSON response with the short URL
+	switch s.kind {
+	case "shlink":
+		body, _ := json.Marshal(map[string]interface{}{"longUrl": long, "findIfExists": true})
+		req, err = http.NewRequestWithContext(ctx, "POST", s.base+"/rest/v3/short-urls", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Api-Key", s.token)
+		}
+		field = "shortUrl"
+	case "kutt":
+		body, _ := json.Marshal(map[string]interface{}{"target": long})
+		req, err = http.NewRequestWithContext(ctx, "POST", s.base+"/api/v2/links", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-API-KEY", s.token)
+		}
+		field = "link"
+	case "yourls":
+		query := url.Values{"signature": {s.token}, "action": {"shorturl"}, "format": {"json"}, "url": {long}}
+		req, err = http.NewRequestWithContext(ctx, "GET", s.base+"/yourls-api.php?"+query.Encode(), nil)
+		field = "shorturl"
+	}
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var result map[string]interface{}
+	// YOURLS reports an error for a URL it already has, but still returns its short URL
+	if err = json.NewDecoder(io.LimitReader(resp.Body, shortenerResponseLimit)).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response (status %d): %w", resp.StatusCode, err)
+	}
+	short, _ = result[field].(string)
+	if short == "" {
+		return "", fmt.Errorf("no short URL in response (status %d)", resp.StatusCode)
+	}
+	return short, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// how long to wait for in-flight titles to finish after cancelling them
+	shutdownDrainTimeout = 5 * time.Second
+	// how long to wait for the server to close the connection after QUIT
+	shutdownQuitTimeout = 10 * time.Second
+)
+
+// handleSignals shuts down gracefully on SIGINT or SIGTERM;
+// a second signal exits immediately
+func (irc *Bot) handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		irc.logInfo("shutting down", "signal", sig.String())
+		go irc.shutdown()
+		<-signals
+		irc.logError("exiting immediately on second signal")
+		os.Exit(1)
+	}()
+}
+
+// shutdown cancels in-flight fetches, waits for the titles being sent to
+// go out (including through the send queue), then sends QUIT, which causes
+// Loop() to return once the server closes the connection
+func (irc *Bot) shutdown() {
+	irc.shutdownOnce.Do(func() {
+		sdNotify("STOPPING=1")
+		irc.cancel()
+		if irc.ha != nil {
+			irc.ha.release()
+		}
+
+		deadline := time.Now().Add(shutdownDrainTimeout)
+		drained := make(chan empty)
+		go func() {
+			irc.inFlight.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-time.After(time.Until(deadline)):
+			irc.logError("timed out waiting for in-flight titles")
+		}
+		if irc.sendQueue != nil {
+			if !irc.sendQueue.flush(deadline) {
+				irc.logError("timed out waiting for the send queue")
+			}
+			close(irc.sendQueue.stop)
+		}
+
+		// QUIT is queued behind any notices that haven't been written yet
+		irc.Quit()
+		if !irc.Connected() {
+			// wake Loop() if it's waiting to reconnect, so that it sees the QUIT
+			irc.Reconnect()
+		}
+		time.AfterFunc(shutdownQuitTimeout, func() {
+			irc.logError("timed out waiting for the server to close the connection")
+			irc.closeStorage()
+			os.Exit(1)
+		})
+	})
+}
+
+// closeStorage closes the database, if there is one
+func (irc *Bot) closeStorage() {
+	if irc.db != nil {
+		irc.checkErr(irc.db.Close(), "couldn't close database")
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+// site names longer than this are probably descriptions
+const siteNameCharLimit = 64
+
+var headEndRe = regexp.MustCompile(`(?i)</head\s*>|<body\b`)
+
+// readSiteName finds the site name a page declares (OpenGraph's
+// og:site_name) in its <head>, reading more of the page (up to limit) if
+// it hasn't been read yet. It returns the page as read so far.
+func readSiteName(r io.Reader, body []byte, limit int) (siteName string, page []byte) {
+	page = body
+	if !headEndRe.Match(page) {
+		page, _ = readRest(r, page, limit)
+	}
+	head := page
+	if end := headEndRe.FindIndex(page); end != nil {
+		head = page[:end[0]]
+	}
+	siteName = metaContent(head, "og:site_name")
+	if len(siteName) > siteNameCharLimit {
+		return "", page
+	}
+	return ircutils.SanitizeText(siteName, siteNameCharLimit), page
+}
+
+// siteNamePrefix is the site name to show before a title, e.g. "[The
+// Verge] ", unless the title already names the site
+func siteNamePrefix(siteName, title string) string {
+	if siteName == "" || strings.Contains(strings.ToLower(title), strings.ToLower(siteName)) {
+		return ""
+	}
+	return "[" + siteName + "] "
+}
@@ -0,0 +1,466 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// persistentState is the state that titlebot keeps across restarts
+type persistentState struct {
+	// casefolded accounts of users who don't want their links titled
+	OptOut map[string]bool `json:"optout,omitempty"`
+	// nicks, accounts, or nick!user@host masks whose messages we ignore
+	Ignores []string `json:"ignores,omitempty"`
+	// domains never to title (including their subdomains)
+	BlockedDomains []string `json:"blockedDomains,omitempty"`
+	// map from casefolded channel name to the only domains to title there
+	AllowedDomains map[string][]string `json:"allowedDomains,omitempty"`
+	// map from casefolded channel name to settings changed by the channel's operators
+	Channels map[string]channelSettings `json:"channels,omitempty"`
+	// channels joined at runtime (with the join command or by invitation),
+	// in addition to TITLEBOT_CHANNELS, mapped from casefolded name
+	Joined map[string]joinedChannel `json:"joined,omitempty"`
+	// casefolded names of channels parted at runtime, which we no longer
+	// join even if they're in TITLEBOT_CHANNELS
+	Parted []string `json:"parted,omitempty"`
+	// settings changed at runtime with the set command, overriding the environment
+	Settings map[string]string `json:"settings,omitempty"`
+	// map from casefolded account to the read-later service it registered
+	ReadLater map[string]readLaterAccount `json:"readLater,omitempty"`
+}
+
+type joinedChannel struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+type channelSettings struct {
+	// don't title anything in the channel
+	Disabled bool `json:"disabled,omitempty"`
+	// only title links on request (`titlebot: title <url>`)
+	Passive bool `json:"passive,omitempty"`
+	// publish the channel's link history as a web page and feeds
+	Linkblog bool `json:"linkblog,omitempty"`
+	// also title links without a scheme, like example.com/foo
+	BareDomains bool `json:"bareDomains,omitempty"`
+	// say briefly why a link couldn't be titled, e.g. "⚠ 404 Not Found"
+	Errors bool `json:"errors,omitempty"`
+	// show the name the page gives its site, e.g. "[The Verge] title"
+	SiteName bool `json:"siteName,omitempty"`
+}
+
+// stateBackend is where the persistent state is stored
+type stateBackend interface {
+	load() (persistentState, error)
+	save(*persistentState) error
+}
+
+// stateStore holds the persistent state, saving it to the backend whenever
+// it changes. If the backend is nil, the state is in-memory only.
+type stateStore struct {
+	sync.Mutex
+	backend stateBackend
+	state   persistentState
+
+	// compiled from state.Ignores
+	ignoreMatchers []ignoreMatcher
+}
+
+func loadState(backend stateBackend) (*stateStore, error) {
+	s := &stateStore{backend: backend}
+	if backend != nil {
+		var err error
+		if s.state, err = backend.load(); err != nil {
+			return nil, err
+		}
+	}
+	if s.state.OptOut == nil {
+		s.state.OptOut = make(map[string]bool)
+	}
+	if s.state.AllowedDomains == nil {
+		s.state.AllowedDomains = make(map[string][]string)
+	}
+	if s.state.Channels == nil {
+		s.state.Channels = make(map[string]channelSettings)
+	}
+	if s.state.Joined == nil {
+		s.state.Joined = make(map[string]joinedChannel)
+	}
+	if s.state.Settings == nil {
+		s.state.Settings = make(map[string]string)
+	}
+	if s.state.ReadLater == nil {
+		s.state.ReadLater = make(map[string]readLaterAccount)
+	}
+	s.compileIgnoresNoMutex()
+	return s, nil
+}
+
+// clone returns a deep copy of the state, which can be changed without
+// affecting the original
+func (state *persistentState) clone() persistentState {
+	result := *state
+	result.OptOut = maps.Clone(state.OptOut)
+	result.Ignores = slices.Clone(state.Ignores)
+	result.BlockedDomains = slices.Clone(state.BlockedDomains)
+	result.AllowedDomains = make(map[string][]string, len(state.AllowedDomains))
+	for channel, domains := range state.AllowedDomains {
+		result.AllowedDomains[channel] = slices.Clone(domains)
+	}
+	result.Channels = maps.Clone(state.Channels)
+	result.Joined = maps.Clone(state.Joined)
+	result.Parted = slices.Clone(state.Parted)
+	result.Settings = maps.Clone(state.Settings)
+	result.ReadLater = maps.Clone(state.ReadLater)
+	return result
+}
+
+// update applies change to a copy of the state and saves it, only replacing
+// the state once it has been saved, so that a failed save leaves the state
+// as it was. change returns whether it changed anything; if not, nothing
+// is saved.
+func (s *stateStore) update(change func(*persistentState) bool) (changed bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+	state := s.state.clone()
+	if !change(&state) {
+		return false, nil
+	}
+	if s.backend != nil {
+		if err = s.backend.save(&state); err != nil {
+			return false, err
+		}
+	}
+	s.state = state
+	s.compileIgnoresNoMutex()
+	return true, nil
+}
+
+// jsonBackend stores the state as JSON in the file at `path`
+type jsonBackend struct {
+	path string
+}
+
+func (b *jsonBackend) load() (state persistentState, err error) {
+	data, err := os.ReadFile(b.path)
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+func (b *jsonBackend) save(state *persistentState) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.path, data)
+}
+
+// writeFileAtomic writes to a temporary file, then renames it over path,
+// so that a crash can't leave a truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *stateStore) isOptedOut(account string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.state.OptOut[strings.ToLower(account)]
+}
+
+func (s *stateStore) setOptOut(account string, optOut bool) error {
+	account = strings.ToLower(account)
+	_, err := s.update(func(state *persistentState) bool {
+		if optOut {
+			state.OptOut[account] = true
+		} else {
+			delete(state.OptOut, account)
+		}
+		return true
+	})
+	return err
+}
+
+// ignoreMatcher matches a sender against an entry on the ignore list
+type ignoreMatcher struct {
+	mask *regexp.Regexp // for nick!user@host masks
+	name string         // for a casefolded nick or account
+}
+
+func compileIgnore(entry string) (m ignoreMatcher) {
+	if !strings.ContainsAny(entry, "!@") {
+		m.name = strings.ToLower(entry)
+		return
+	}
+	var buf strings.Builder
+	buf.WriteString("(?i)^")
+	for _, r := range entry {
+		switch r {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	m.mask = regexp.MustCompile(buf.String())
+	return
+}
+
+func (m *ignoreMatcher) matches(source, nick, account string) bool {
+	if m.mask != nil {
+		// relayed users have accounts like nick@bridge (see unwrapRelay),
+		// which entries like nick@bridge or *@bridge match
+		if strings.Contains(account, "@") && m.mask.MatchString(account) {
+			return true
+		}
+		return m.mask.MatchString(source)
+	}
+	return m.name == strings.ToLower(nick) || (account != "" && m.name == strings.ToLower(account))
+}
+
+func (s *stateStore) compileIgnoresNoMutex() {
+	s.ignoreMatchers = make([]ignoreMatcher, len(s.state.Ignores))
+	for i, entry := range s.state.Ignores {
+		s.ignoreMatchers[i] = compileIgnore(entry)
+	}
+}
+
+// isIgnored checks a message's sender (nick!user@host, and account
+// if known) against the ignore list
+func (s *stateStore) isIgnored(source, nick, account string) bool {
+	s.Lock()
+	defer s.Unlock()
+	for i := range s.ignoreMatchers {
+		if s.ignoreMatchers[i].matches(source, nick, account) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stateStore) getIgnores() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.state.Ignores...)
+}
+
+// setIgnore adds or removes an entry from the ignore list,
+// returning whether anything changed
+func (s *stateStore) setIgnore(entry string, ignore bool) (changed bool, err error) {
+	return s.update(func(state *persistentState) (changed bool) {
+		state.Ignores, changed = addOrRemove(state.Ignores, entry, ignore)
+		return
+	})
+}
+
+// addOrRemove adds or removes a (case-insensitive) entry from list,
+// returning the new list and whether it changed
+func addOrRemove(list []string, entry string, add bool) (result []string, changed bool) {
+	i := -1
+	for j, existing := range list {
+		if strings.EqualFold(existing, entry) {
+			i = j
+			break
+		}
+	}
+	if add && i == -1 {
+		return append(list, entry), true
+	} else if !add && i != -1 {
+		return append(list[:i], list[i+1:]...), true
+	}
+	return list, false
+}
+
+func domainListMatch(host string, domains []string) bool {
+	for _, domain := range domains {
+		if domainMatch(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainAllowed checks a (lowercase) host against the runtime blocklist,
+// and against the allowlist for channel, if it has one
+func (s *stateStore) domainAllowed(channel, host string) bool {
+	s.Lock()
+	defer s.Unlock()
+	if domainListMatch(host, s.state.BlockedDomains) {
+		return false
+	}
+	if allowed := s.state.AllowedDomains[strings.ToLower(channel)]; len(allowed) != 0 {
+		return domainListMatch(host, allowed)
+	}
+	return true
+}
+
+func (s *stateStore) getBlockedDomains() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.state.BlockedDomains...)
+}
+
+func (s *stateStore) setBlockedDomain(domain string, block bool) (changed bool, err error) {
+	return s.update(func(state *persistentState) (changed bool) {
+		state.BlockedDomains, changed = addOrRemove(state.BlockedDomains, strings.ToLower(domain), block)
+		return
+	})
+}
+
+func (s *stateStore) getAllowedDomains(channel string) []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.state.AllowedDomains[strings.ToLower(channel)]...)
+}
+
+func (s *stateStore) setAllowedDomain(channel, domain string, allow bool) (changed bool, err error) {
+	channel = strings.ToLower(channel)
+	return s.update(func(state *persistentState) bool {
+		allowed, changed := addOrRemove(state.AllowedDomains[channel], strings.ToLower(domain), allow)
+		if !changed {
+			return false
+		}
+		if len(allowed) == 0 {
+			delete(state.AllowedDomains, channel)
+		} else {
+			state.AllowedDomains[channel] = allowed
+		}
+		return true
+	})
+}
+
+func (s *stateStore) getChannelSettings(channel string) channelSettings {
+	s.Lock()
+	defer s.Unlock()
+	return s.state.Channels[strings.ToLower(channel)]
+}
+
+// updateChannelSettings applies update to a channel's settings and saves them
+func (s *stateStore) updateChannelSettings(channel string, update func(*channelSettings)) error {
+	channel = strings.ToLower(channel)
+	_, err := s.update(func(state *persistentState) bool {
+		settings := state.Channels[channel]
+		update(&settings)
+		if settings == (channelSettings{}) {
+			delete(state.Channels, channel)
+		} else {
+			state.Channels[channel] = settings
+		}
+		return true
+	})
+	return err
+}
+
+// autojoinChannels returns the channels to join on connect:
+// the configured channels, plus those joined at runtime,
+// minus those parted at runtime
+func (s *stateStore) autojoinChannels(configured []string) (result []joinedChannel) {
+	s.Lock()
+	defer s.Unlock()
+	seen := make(map[string]empty)
+	for _, name := range configured {
+		cfname := strings.ToLower(name)
+		if _, ok := seen[cfname]; !ok && !sliceContains(s.state.Parted, cfname) {
+			seen[cfname] = empty{}
+			result = append(result, joinedChannel{Name: name})
+		}
+	}
+	for cfname, channel := range s.state.Joined {
+		if _, ok := seen[cfname]; !ok {
+			seen[cfname] = empty{}
+			result = append(result, channel)
+		}
+	}
+	return
+}
+
+// setJoined records that we joined or parted a channel at runtime
+func (s *stateStore) setJoined(name, key string, joined bool) error {
+	cfname := strings.ToLower(name)
+	_, err := s.update(func(state *persistentState) bool {
+		state.Parted, _ = addOrRemove(state.Parted, cfname, !joined)
+		if joined {
+			state.Joined[cfname] = joinedChannel{Name: name, Key: key}
+		} else {
+			delete(state.Joined, cfname)
+		}
+		return true
+	})
+	return err
+}
+
+func (s *stateStore) getSettings() map[string]string {
+	s.Lock()
+	defer s.Unlock()
+	result := make(map[string]string, len(s.state.Settings))
+	for key, value := range s.state.Settings {
+		result[key] = value
+	}
+	return result
+}
+
+// setSetting persists a runtime setting; an empty value removes the override
+func (s *stateStore) setSetting(key, value string) error {
+	_, err := s.update(func(state *persistentState) bool {
+		if value == "" {
+			delete(state.Settings, key)
+		} else {
+			state.Settings[key] = value
+		}
+		return true
+	})
+	return err
+}
+
+func (s *stateStore) getReadLater(account string) (result readLaterAccount, ok bool) {
+	s.Lock()
+	defer s.Unlock()
+	result, ok = s.state.ReadLater[strings.ToLower(account)]
+	return
+}
+
+// setReadLater registers an account's read-later service; a zero
+// readLaterAccount removes the registration
+func (s *stateStore) setReadLater(account string, readLater readLaterAccount) error {
+	account = strings.ToLower(account)
+	_, err := s.update(func(state *persistentState) bool {
+		if readLater == (readLaterAccount{}) {
+			delete(state.ReadLater, account)
+		} else {
+			state.ReadLater[account] = readLater
+		}
+		return true
+	})
+	return err
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingBackend fails to save while fail is set
+type failingBackend struct {
+	fail  bool
+	saved persistentState
+}
+
+func (b *failingBackend) load() (persistentState, error) {
+	return persistentState{}, nil
+}
+
+func (b *failingBackend) save(state *persistentState) error {
+	if b.fail {
+		return errors.New("disk full")
+	}
+	b.saved = state.clone()
+	return nil
+}
+
+func TestStateUnchangedAfterFailedSave(t *testing.T) {
+	backend := &failingBackend{}
+	s, err := loadState(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.setIgnore("spammer", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.setAllowedDomain("#chat", "example.com", true); err != nil {
+		t.Fatal(err)
+	}
+
+	backend.fail = true
+	if changed, err := s.setIgnore("otherbot", true); err == nil || changed {
+		t.Errorf("setIgnore = %t, %v; expected the save to fail", changed, err)
+	}
+	if s.isIgnored("otherbot!bot@example.com", "otherbot", "") {
+		t.Error("the ignore was applied even though it wasn't saved")
+	}
+	if _, err := s.setIgnore("spammer", false); err == nil {
+		t.Error("expected the save to fail")
+	}
+	if !s.isIgnored("spammer!spam@example.com", "spammer", "") {
+		t.Error("the ignore was removed even though that wasn't saved")
+	}
+	if _, err := s.setAllowedDomain("#chat", "example.com", false); err == nil {
+		t.Error("expected the save to fail")
+	}
+	if allowed := s.getAllowedDomains("#chat"); len(allowed) != 1 || allowed[0] != "example.com" {
+		t.Errorf("got allowed domains %v after a failed save", allowed)
+	}
+	if err := s.updateChannelSettings("#chat", func(c *channelSettings) { c.Disabled = true }); err == nil {
+		t.Error("expected the save to fail")
+	}
+	if s.getChannelSettings("#chat").Disabled {
+		t.Error("the channel setting was applied even though it wasn't saved")
+	}
+
+	backend.fail = false
+	if _, err := s.setIgnore("otherbot", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.saved.Ignores) != 2 || !s.isIgnored("otherbot!bot@example.com", "otherbot", "") {
+		t.Errorf("got ignores %v after saving", backend.saved.Ignores)
+	}
+}
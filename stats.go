@@ -0,0 +1,163 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// categories of fetch errors, for the stats command
+const (
+	errRequest = "request" // couldn't build the request
+	errNetwork = "network" // connection or protocol errors
+	errStatus  = "status"  // non-200 HTTP responses
+	errRead    = "read"    // errors reading the body
+	errParse   = "parse"   // bad API responses
+	errNoTitle = "notitle" // page had no usable title
+)
+
+// how many recently sent titles to remember, for the status page
+const recentTitlesLimit = 20
+
+type recentTitle struct {
+	Time    time.Time
+	Channel string
+	URL     string
+	Title   string
+}
+
+// botStats collects counters for the stats command
+type botStats struct {
+	sync.Mutex
+	started     time.Time
+	titlesSent  uint64
+	linksSeen   uint64
+	cacheHits   uint64
+	saturation  uint64 // links dropped by the title queue
+	lastSuccess time.Time
+	fetchErrors map[string]uint64
+	handlers    map[string]uint64
+	recent      []recentTitle // oldest first
+}
+
+func newBotStats() *botStats {
+	return &botStats{
+		started:     time.Now(),
+		fetchErrors: make(map[string]uint64),
+		handlers:    make(map[string]uint64),
+	}
+}
+
+// titleSent records a title sent to target; only titles sent to channels
+// (and not hidden as spoilers) are remembered for the status page
+func (s *botStats) titleSent(target, url, title string, public bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.titlesSent++
+	if public {
+		if len(s.recent) >= recentTitlesLimit {
+			s.recent = s.recent[1:]
+		}
+		s.recent = append(s.recent, recentTitle{Time: time.Now(), Channel: target, URL: url, Title: title})
+	}
+}
+
+// recentTitles returns the recently sent titles, newest first
+func (s *botStats) recentTitles() (result []recentTitle) {
+	s.Lock()
+	defer s.Unlock()
+	result = make([]recentTitle, len(s.recent))
+	for i, entry := range s.recent {
+		result[len(s.recent)-1-i] = entry
+	}
+	return
+}
+
+// linksFiltered records a lookup of `seen` links in the recently seen
+// links cache, of which `fresh` were new
+func (s *botStats) linksFiltered(seen, fresh int) {
+	s.Lock()
+	s.linksSeen += uint64(seen)
+	s.cacheHits += uint64(seen - fresh)
+	s.Unlock()
+}
+
+func (s *botStats) saturated() {
+	s.Lock()
+	s.saturation++
+	s.Unlock()
+}
+
+func (s *botStats) fetchError(category string) {
+	s.Lock()
+	s.fetchErrors[category]++
+	s.Unlock()
+}
+
+func (s *botStats) fetchSucceeded() {
+	s.Lock()
+	s.lastSuccess = time.Now()
+	s.Unlock()
+}
+
+func (s *botStats) lastSuccessfulFetch() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.lastSuccess
+}
+
+// errorCounts returns a copy of the fetch error counters, by category
+func (s *botStats) errorCounts() map[string]uint64 {
+	s.Lock()
+	defer s.Unlock()
+	result := make(map[string]uint64, len(s.fetchErrors)+1)
+	for category, count := range s.fetchErrors {
+		result[category] = count
+	}
+	result["saturated"] = s.saturation
+	return result
+}
+
+func (s *botStats) handlerUsed(handler string) {
+	s.Lock()
+	s.handlers[handler]++
+	s.Unlock()
+}
+
+func formatCounts(counts map[string]uint64) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s %d", key, counts[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// summary renders the stats as a single line suitable for IRC
+func (s *botStats) summary() string {
+	s.Lock()
+	defer s.Unlock()
+
+	hitRate := "n/a"
+	if s.linksSeen != 0 {
+		hitRate = fmt.Sprintf("%.1f%%", 100*float64(s.cacheHits)/float64(s.linksSeen))
+	}
+	return fmt.Sprintf(
+		"up %s; %d titles sent; cache hit rate %s (%d/%d); fetch errors: %s; %d links dropped by the title queue; handlers: %s",
+		humanReadableDuration(time.Since(s.started)), s.titlesSent,
+		hitRate, s.cacheHits, s.linksSeen,
+		formatCounts(s.fetchErrors), s.saturation, formatCounts(s.handlers),
+	)
+}
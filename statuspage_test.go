@@ -0,0 +1,27 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPublicRecentTitles(t *testing.T) {
+	state, err := loadState(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	irc := &Bot{state: state, stats: newBotStats()}
+	if err := irc.state.updateChannelSettings("#public", func(s *channelSettings) { s.Linkblog = true }); err != nil {
+		t.Fatal(err)
+	}
+	irc.stats.titleSent("#public", "https://example.com/1", "One", true)
+	irc.stats.titleSent("#private", "https://example.com/2", "Two", true)
+	irc.stats.titleSent("#public", "https://example.com/3", "Three", true)
+
+	recent := irc.publicRecentTitles()
+	if len(recent) != 2 || recent[0].Title != "Three" || recent[1].Title != "One" {
+		t.Errorf("got recent titles %+v, expected only the ones from #public", recent)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"database/sql"
+
+	_ "modernc.org/sqlite"
+)
+
+// openDatabase opens the SQLite database that holds the link history
+// and the persistent state
+func openDatabase(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite doesn't support concurrent writers:
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(`PRAGMA journal_mode = WAL`); err == nil {
+		_, err = db.Exec(stateSchema)
+	}
+	for _, column := range stateColumnMigrations {
+		if err == nil {
+			err = addColumnIfMissing(db, column.table, column.column, column.definition)
+		}
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS optouts (account TEXT PRIMARY KEY);
+CREATE TABLE IF NOT EXISTS ignores (entry TEXT PRIMARY KEY);
+CREATE TABLE IF NOT EXISTS blocked_domains (domain TEXT PRIMARY KEY);
+CREATE TABLE IF NOT EXISTS allowed_domains (
+	channel TEXT NOT NULL,
+	domain TEXT NOT NULL,
+	PRIMARY KEY (channel, domain)
+);
+CREATE TABLE IF NOT EXISTS channel_settings (
+	channel TEXT PRIMARY KEY,
+	disabled INTEGER NOT NULL,
+	passive INTEGER NOT NULL,
+	linkblog INTEGER NOT NULL DEFAULT 0,
+	bare_domains INTEGER NOT NULL DEFAULT 0,
+	errors INTEGER NOT NULL DEFAULT 0,
+	site_name INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS joined_channels (
+	channel TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	key TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS parted_channels (channel TEXT PRIMARY KEY);
+CREATE TABLE IF NOT EXISTS settings (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE IF NOT EXISTS read_later (
+	account TEXT PRIMARY KEY,
+	service TEXT NOT NULL,
+	url TEXT NOT NULL,
+	token TEXT NOT NULL
+);
+`
+
+// columns added to the state tables after they were first created
+var stateColumnMigrations = []struct {
+	table, column, definition string
+}{
+	{"channel_settings", "linkblog", "INTEGER NOT NULL DEFAULT 0"},
+	{"channel_settings", "bare_domains", "INTEGER NOT NULL DEFAULT 0"},
+	{"channel_settings", "errors", "INTEGER NOT NULL DEFAULT 0"},
+	{"channel_settings", "site_name", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// addColumnIfMissing adds a column to a table created by an older version
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	var count int
+	err := db.QueryRow(`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	}
+	return err
+}
+
+// sqliteBackend stores the state in tables of the database. The state is
+// small and changes rarely, so saving it simply rewrites all the tables.
+type sqliteBackend struct {
+	db *sql.DB
+}
+
+// queryRows runs a query, calling scan for each row
+func queryRows(db *sql.DB, query string, scan func(*sql.Rows) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (b *sqliteBackend) load() (state persistentState, err error) {
+	state.OptOut = make(map[string]bool)
+	state.AllowedDomains = make(map[string][]string)
+	state.Channels = make(map[string]channelSettings)
+	state.Joined = make(map[string]joinedChannel)
+	state.Settings = make(map[string]string)
+	state.ReadLater = make(map[string]readLaterAccount)
+
+	var str, str2 string
+	queries := []struct {
+		query string
+		scan  func(*sql.Rows) error
+	}{
+		{`SELECT account FROM optouts`, func(rows *sql.Rows) error {
+			err := rows.Scan(&str)
+			state.OptOut[str] = true
+			return err
+		}},
+		{`SELECT entry FROM ignores ORDER BY rowid`, func(rows *sql.Rows) error {
+			err := rows.Scan(&str)
+			state.Ignores = append(state.Ignores, str)
+			return err
+		}},
+		{`SELECT domain FROM blocked_domains ORDER BY rowid`, func(rows *sql.Rows) error {
+			err := rows.Scan(&str)
+			state.BlockedDomains = append(state.BlockedDomains, str)
+			return err
+		}},
+		{`SELECT channel, domain FROM allowed_domains ORDER BY rowid`, func(rows *sql.Rows) error {
+			err := rows.Scan(&str, &str2)
+			state.AllowedDomains[str] = append(state.AllowedDomains[str], str2)
+			return err
+		}},
+		{`SELECT channel, disabled, passive, linkblog, bare_domains, errors, site_name FROM channel_settings`, func(rows *sql.Rows) error {
+			var settings channelSettings
+			err := rows.Scan(&str, &settings.Disabled, &settings.Passive, &settings.Linkblog, &settings.BareDomains, &settings.Errors, &settings.SiteName)
+			state.Channels[str] = settings
+			return err
+		}},
+		{`SELECT channel, name, key FROM joined_channels`, func(rows *sql.Rows) error {
+			var joined joinedChannel
+			err := rows.Scan(&str, &joined.Name, &joined.Key)
+			state.Joined[str] = joined
+			return err
+		}},
+		{`SELECT channel FROM parted_channels ORDER BY rowid`, func(rows *sql.Rows) error {
+			err := rows.Scan(&str)
+			state.Parted = append(state.Parted, str)
+			return err
+		}},
+		{`SELECT key, value FROM settings`, func(rows *sql.Rows) error {
+			err := rows.Scan(&str, &str2)
+			state.Settings[str] = str2
+			return err
+		}},
+		{`SELECT account, service, url, token FROM read_later`, func(rows *sql.Rows) error {
+			var readLater readLaterAccount
+			err := rows.Scan(&str, &readLater.Service, &readLater.URL, &readLater.Token)
+			state.ReadLater[str] = readLater
+			return err
+		}},
+	}
+	for _, q := range queries {
+		if err = queryRows(b.db, q.query, q.scan); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (b *sqliteBackend) save(state *persistentState) (err error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	exec := func(query string, args ...interface{}) {
+		if err == nil {
+			_, err = tx.Exec(query, args...)
+		}
+	}
+	for _, table := range []string{"optouts", "ignores", "blocked_domains", "allowed_domains",
+		"channel_settings", "joined_channels", "parted_channels", "settings", "read_later"} {
+		exec(`DELETE FROM ` + table)
+	}
+	for account, optOut := range state.OptOut {
+		if optOut {
+			exec(`INSERT INTO optouts (account) VALUES (?)`, account)
+		}
+	}
+	for _, entry := range state.Ignores {
+		exec(`INSERT INTO ignores (entry) VALUES (?)`, entry)
+	}
+	for _, domain := range state.BlockedDomains {
+		exec(`INSERT INTO blocked_domains (domain) VALUES (?)`, domain)
+	}
+	for channel, domains := range state.AllowedDomains {
+		for _, domain := range domains {
+			exec(`INSERT INTO allowed_domains (channel, domain) VALUES (?, ?)`, channel, domain)
+		}
+	}
+	for channel, settings := range state.Channels {
+		exec(`INSERT INTO channel_settings (channel, disabled, passive, linkblog, bare_domains, errors, site_name) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			channel, settings.Disabled, settings.Passive, settings.Linkblog, settings.BareDomains, settings.Errors, settings.SiteName)
+	}
+	for channel, joined := range state.Joined {
+		exec(`INSERT INTO joined_channels (channel, name, key) VALUES (?, ?, ?)`, channel, joined.Name, joined.Key)
+	}
+	for _, channel := range state.Parted {
+		exec(`INSERT INTO parted_channels (channel) VALUES (?)`, channel)
+	}
+	for key, value := range state.Settings {
+		exec(`INSERT INTO settings (key, value) VALUES (?, ?)`, key, value)
+	}
+	for account, readLater := range state.ReadLater {
+		exec(`INSERT INTO read_later (account, service, url, token) VALUES (?, ?, ?, ?)`,
+			account, readLater.Service, readLater.URL, readLater.Token)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isEmpty checks whether nothing has been saved to the database yet
+func (b *sqliteBackend) isEmpty() (bool, error) {
+	state, err := b.load()
+	if err != nil {
+		return false, err
+	}
+	return len(state.OptOut) == 0 && len(state.Ignores) == 0 && len(state.BlockedDomains) == 0 &&
+		len(state.AllowedDomains) == 0 && len(state.Channels) == 0 && len(state.Joined) == 0 &&
+		len(state.Parted) == 0 && len(state.Settings) == 0 && len(state.ReadLater) == 0, nil
+}
+
+// openState loads the persistent state from the database if there is one,
+// importing it from the JSON state file the first time the database is used;
+// otherwise, from the state file (if any)
+func openState(db *sql.DB, stateFile string) (*stateStore, error) {
+	if db == nil {
+		if stateFile == "" {
+			return loadState(nil)
+		}
+		return loadState(&jsonBackend{path: stateFile})
+	}
+	backend := &sqliteBackend{db: db}
+	if stateFile != "" {
+		empty, err := backend.isEmpty()
+		if err != nil {
+			return nil, err
+		}
+		if empty {
+			legacy, err := loadState(&jsonBackend{path: stateFile})
+			if err != nil {
+				return nil, err
+			}
+			if err = backend.save(&legacy.state); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return loadState(backend)
+}
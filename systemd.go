@@ -0,0 +1,133 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	watchdogPingToken = "titlebot-watchdog"
+	// keep petting the watchdog for this long after a disconnection,
+	// to give the reconnect logic a chance to work
+	watchdogReconnectGrace = 10 * time.Minute
+)
+
+// sdNotify sends a state (e.g. READY=1) to systemd, if we're running
+// under systemd with Type=notify
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// abstract namespace sockets:
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the interval at which systemd expects to hear
+// from us (WatchdogSec= in the unit file), or 0 if the watchdog is disabled
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// liveness tracks whether the IRC connection is responsive
+type liveness struct {
+	sync.Mutex
+	lastPong     time.Time
+	disconnected time.Time // zero while connected
+}
+
+func (l *liveness) pong() {
+	l.Lock()
+	defer l.Unlock()
+	l.lastPong = time.Now()
+	l.disconnected = time.Time{}
+}
+
+func (l *liveness) disconnect() {
+	l.Lock()
+	defer l.Unlock()
+	if l.disconnected.IsZero() {
+		l.disconnected = time.Now()
+	}
+}
+
+// disconnectedFor returns how long we've been disconnected, or 0 if we're connected
+func (l *liveness) disconnectedFor() time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	if l.disconnected.IsZero() {
+		return 0
+	}
+	return time.Since(l.disconnected)
+}
+
+// healthy checks whether the server has answered a PING within `window`,
+// or we're still within the grace period for reconnecting
+func (l *liveness) healthy(window time.Duration) bool {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	if !l.disconnected.IsZero() {
+		return now.Sub(l.disconnected) < watchdogReconnectGrace
+	}
+	return now.Sub(l.lastPong) < window
+}
+
+// setupSystemd tells systemd when we're ready and, if the watchdog is
+// enabled, pets it as long as the server keeps answering our PINGs
+func (irc *Bot) setupSystemd() {
+	irc.AddConnectCallback(func(e ircmsg.Message) {
+		irc.liveness.pong()
+		irc.checkErr(sdNotify("READY=1\nSTATUS=connected to "+irc.Server), "couldn't notify systemd")
+	})
+	irc.AddDisconnectCallback(func(e ircmsg.Message) {
+		irc.liveness.disconnect()
+		sdNotify("STATUS=reconnecting to " + irc.Server)
+	})
+	irc.AddCallback("PONG", func(e ircmsg.Message) {
+		irc.liveness.pong()
+	})
+
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		// ping the server and the watchdog at twice the required frequency,
+		// so that a single slow PONG doesn't get us killed
+		for range time.Tick(interval / 2) {
+			if irc.Connected() {
+				irc.Send("PING", watchdogPingToken)
+			}
+			if irc.liveness.healthy(interval) {
+				sdNotify("WATCHDOG=1")
+			} else {
+				irc.logError("IRC connection is unresponsive, not notifying the systemd watchdog")
+			}
+		}
+	}()
+}
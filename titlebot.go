@@ -8,24 +8,31 @@ package main
 // Tweets. It is configured via environment variables (see newBot for a list).
 
 import (
+	"context"
 	"crypto/tls"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"html"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ergochat/irc-go/ircevent"
 	"github.com/ergochat/irc-go/ircmsg"
 	"github.com/ergochat/irc-go/ircutils"
+	"github.com/getsentry/sentry-go"
 )
 
 type empty struct{}
@@ -43,14 +50,40 @@ const (
 	defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.81 Safari/537.36"
 
 	replyTagName = "+draft/reply"
+	botTagName   = "bot"
+	// client-only tag on a PRIVMSG that replaces an earlier message,
+	// whose value is the msgid of the original message
+	editTagName = "+draft/edit"
+
+	messageLinksTTL   = time.Hour
+	messageLinksLimit = 4096
+
+	chathistoryCap = "draft/chathistory"
+	// maximum number of missed messages to fetch per channel on reconnect:
+	chathistoryLimit = 100
+	// maximum number of missed messages with links to title per channel:
+	catchupMessageLimit = 8
+	catchupPrefix       = "[while I was away] "
+
+	// titles of spoiler links are hidden by rendering them black-on-black
+	spoilerPrefix = "spoiler: \x0301,01"
+	nsfwPrefix    = "[NSFW] "
+
+	defaultPrivmsgRateLimit = 6  // per user, per privmsgRatePeriod
+	defaultChannelRateLimit = 12 // titles per minute, per channel
+	defaultChannelBurst     = 6
+	privmsgRatePeriod       = time.Minute
 )
 
 var (
-	urlRe   = regexp.MustCompile(`\b(?i)(https?://.*?)(\s|$)`)
 	tweetRe = regexp.MustCompile(`\b(?i)https://(mobile\.?)?twitter.com/.*/status/([0-9]+)`)
 	// <title>bar</title>, <title data-react-helmet="true">qux</title>
 	genericTitleRe = regexp.MustCompile(`(?is)<\s*title\b.*?>(.*?)<`)
 	youtubeTitleRe = regexp.MustCompile(`\{"title":\{"runs":\[\{"text":"(.*?)"\}`)
+	// ||https://example.com|| marks a single link as a spoiler,
+	// [spoiler] or [nsfw] at the start of a message marks all of them
+	spoilerRe       = regexp.MustCompile(`\|\|(.+?)\|\|`)
+	spoilerMarkerRe = regexp.MustCompile(`(?i)^\s*\[(spoiler|nsfw)\]`)
 
 	httpClient = &http.Client{
 		Timeout: 15 * time.Second,
@@ -60,35 +93,184 @@ var (
 type Bot struct {
 	ircevent.Connection
 	TwitterBearerToken string
-	Owner              string
-	semaphore          chan empty
-	userAgent          string
+	ACL                accessList
+	titleQueue         chan titleJob // links waiting for a worker
+	priorityQueue      chan titleJob // explicitly requested links waiting for a worker
+	// cancelled on shutdown, aborting in-flight requests:
+	ctx              context.Context
+	cancel           context.CancelFunc
+	inFlight         sync.WaitGroup // titles being fetched or sent
+	shutdownOnce     sync.Once
+	liveness         liveness
+	reconnect        reconnectBackoff
+	nickRecovery     nickRecovery
+	db               *sql.DB
+	stats            *botStats
+	readLimits       *adaptiveReadLimits
+	history          *historyStore
+	failures         *failureTracker
+	alertTargets     []string
+	recheckAfter     time.Duration // 0 if links aren't rechecked
+	titleCleanup     []titleCleanupRule
+	recheckAlerts    bool // report dead links to the alert targets instead of the channel
+	httpListen       string
+	statusPage       bool
+	announceToken    string
+	publicURL        string
+	exportDir        string
+	webhook          *webhook
+	pubsub           *pubsub
+	archiver         *archiver
+	shortener        *shortener
+	readLater        *readLaterAccount // shared by users who haven't registered their own
+	timezone         *time.Location    // for displaying times, e.g. of events
+	ipfsGateway      string            // base URL, without a trailing slash
+	xmpp             *xmppClient
+	ha               *haLease // nil unless running with a standby
+	sentryEnabled    bool
+	configChannels   []string
+	channels         channelTracker
+	settings         runtimeSettings
+	settingDefaults  map[string]string
+	logConfig        logConfig
+	logger           *slog.Logger
+	titleTopicOnJoin bool
+	noticeChannels   map[string]empty
+	messageLinks     messageLinks
+	floodControl     *floodControl
+	sendQueue        *sendQueue // nil if outgoing messages aren't paced
+	blockedDomains   []string
+	remoteBlocklist  *remoteBlocklist
+	safeBrowsingKey  string
+	useURLhaus       bool
+	urlhausKey       string
+	skipMalicious    bool
+	nsfwDomains      []string
+	nsfwSuppress     map[string]empty
+	titlePrivmsgs    bool
+	state            *stateStore
+	skipSpoilers     bool
+	readingTime      bool // annotate articles with an estimated reading time
+	productInfo      bool // annotate products with their price, availability, and rating
+	ignoreNicksRe    *regexp.Regexp
+	relayNicksRe     *regexp.Regexp
+	ignoreRelays     bool
+	output           outputHistory
+	privmsgLimiter   *rateLimiter
+
+	lastSeenMutex sync.Mutex
+	// map from lowercased channel name to the server-time of the
+	// last message we saw there, for CHATHISTORY catch-up
+	lastSeen map[string]string
 }
 
-func (b *Bot) tryAcquireSemaphore() bool {
-	select {
-	case b.semaphore <- empty{}:
-		return true
-	default:
-		return false
+type messageLinksEntry struct {
+	urls      []string
+	createdAt time.Time
+}
+
+// messageLinks remembers which links were posted in recent messages (by msgid),
+// so that when a message is edited, we only title the links the edit added
+type messageLinks struct {
+	sync.Mutex
+	entries map[string]messageLinksEntry
+}
+
+// filter takes the links in a message (or in an edit of the message `editOf`),
+// records them, and returns the ones that haven't been seen in the original
+func (m *messageLinks) filter(msgid, editOf string, urls []string) (result []string) {
+	key := msgid
+	if editOf != "" {
+		key = editOf
+	}
+	if key == "" {
+		return urls
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	if m.entries == nil {
+		m.entries = make(map[string]messageLinksEntry)
 	}
+	if len(m.entries) >= messageLinksLimit {
+		m.expire(now)
+	}
+
+	entry, ok := m.entries[key]
+	if !ok {
+		entry.createdAt = now
+	}
+	for _, url := range urls {
+		if !sliceContains(entry.urls, url) {
+			result = append(result, url)
+			entry.urls = append(entry.urls, url)
+		}
+	}
+	if len(m.entries) < messageLinksLimit || ok {
+		m.entries[key] = entry
+	}
+	return
 }
 
-func (b *Bot) releaseSemaphore() {
-	<-b.semaphore
+func (m *messageLinks) clear() {
+	m.Lock()
+	defer m.Unlock()
+	m.entries = nil
 }
 
-func findURL(str string) (urls []string) {
-	matches := urlRe.FindAllStringSubmatch(str, -1)
-	if matches == nil {
-		return
+func (m *messageLinks) forget(msgid string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.entries, msgid)
+}
+
+func (m *messageLinks) expire(now time.Time) {
+	for msgid, entry := range m.entries {
+		if now.Sub(entry.createdAt) > messageLinksTTL {
+			delete(m.entries, msgid)
+		}
 	}
-	urls = make([]string, 0, len(matches))
-	for _, submatch := range matches {
-		if len(submatch) > 2 {
-			urls = append(urls, submatch[1])
+}
+
+func sliceContains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
 		}
 	}
+	return false
+}
+
+// urlMatch is a link found in a message, at offset start
+type urlMatch struct {
+	start int
+	url   string
+}
+
+func findURL(str string) (urls []string) {
+	return findLinks(str, false)
+}
+
+// findLinks finds the URLs in a message, and the links matching linkPatterns
+// and (optionally) bare domains, in the order they appear
+func findLinks(str string, bareDomains bool) (urls []string) {
+	spans := scanURLs(str)
+	matches := make([]urlMatch, len(spans))
+	for i, span := range spans {
+		matches[i] = urlMatch{span[0], str[span[0]:span[1]]}
+	}
+	if linkPatterns != nil {
+		matches = append(matches, findPatternURLs(str, spans)...)
+	}
+	if bareDomains {
+		matches = append(matches, findBareDomains(str, spans)...)
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	for _, m := range matches {
+		urls = append(urls, m.url)
+	}
 	return
 }
 
@@ -100,45 +282,239 @@ func extractTweetID(url string) (twid string) {
 	return
 }
 
-func (irc *Bot) titleAll(target, msgid string, urls []string) {
-	if len(urls) > maxUrlsPerMessage {
-		urls = urls[:maxUrlsPerMessage]
+// splitSpoilers finds the links in a message, separating out
+// the ones the poster marked as spoilers (which are never bare domains)
+func splitSpoilers(message string, bareDomains bool) (urls, spoilers []string) {
+	if spoilerMarkerRe.MatchString(message) {
+		return nil, findURL(message)
 	}
-	for _, url := range urls {
-		irc.title(target, msgid, url)
+	for _, match := range spoilerRe.FindAllStringSubmatch(message, -1) {
+		spoilers = append(spoilers, findURL(match[1])...)
 	}
+	if spoilers != nil {
+		message = spoilerRe.ReplaceAllString(message, " ")
+	}
+	urls = findLinks(message, bareDomains)
+	return
 }
 
-func (irc *Bot) title(target, msgid, url string) {
-	if !irc.tryAcquireSemaphore() {
-		irc.Log.Printf("concurrency limit exceeded, not titling %s\n", url)
-		return
+// extractLinks returns the links in a message to target that should be titled,
+// and the ones that should be titled as spoilers (see messageLinks for editOf)
+func (irc *Bot) extractLinks(target, msgid, editOf, message string) (urls, spoilers []string) {
+	urls, spoilers = splitSpoilers(message, irc.state.getChannelSettings(target).BareDomains)
+	// so that an edit that, e.g., only removes tracking parameters isn't a new link
+	for i := range urls {
+		urls[i] = canonicalizeURL(urls[i])
+	}
+	for i := range spoilers {
+		spoilers[i] = canonicalizeURL(spoilers[i])
+	}
+	seen := len(urls) + len(spoilers)
+	urls = irc.messageLinks.filter(msgid, editOf, urls)
+	spoilers = irc.messageLinks.filter(msgid, editOf, spoilers)
+	irc.stats.linksFiltered(seen, len(urls)+len(spoilers))
+	if irc.skipSpoilers {
+		spoilers = nil
+	}
+	return
+}
+
+// titleRequest describes where and how to send the titles of a message's links
+type titleRequest struct {
+	target string
+	msgid  string // msgid of the message to reply to, if any
+	prefix string // prepended to each title
+	// background titles (e.g., catch-up after a reconnect) are the
+	// first to be dropped by channel flood control
+	background bool
+	// the poster, for the link history
+	nick, account string
+	spoiler       bool // not recorded in the history
+	// the message the links were in, if any: titles that only repeat it
+	// (or the link itself) aren't sent
+	message string
+	// explicitly requested (e.g., with the title command), so titled ahead
+	// of links that were merely posted
+	requested bool
+	// set for messages from XMPP rooms, in which case target is the room JID
+	xmpp *xmppClient
+}
+
+// inChannel checks whether the titles are for a channel (or an XMPP room)
+// rather than a direct message
+func (req *titleRequest) inChannel() bool {
+	return req.xmpp != nil || strings.HasPrefix(req.target, "#")
+}
+
+func (irc *Bot) titleLinks(req titleRequest, urls, spoilers []string) {
+	irc.titleAll(req, urls)
+	req.prefix += spoilerPrefix
+	req.spoiler = true
+	irc.titleAll(req, spoilers)
+}
+
+// titleAll titles the URLs from a single message concurrently, sending the
+// titles in the order the URLs appeared in: each as soon as it and the
+// ones before it are ready
+func (irc *Bot) titleAll(req titleRequest, urls []string) {
+	if maxURLs := irc.settings.get().maxURLs; len(urls) > maxURLs {
+		urls = urls[:maxURLs]
+	}
+	irc.inFlight.Add(1)
+	defer irc.inFlight.Done()
+	results := make([]titleResult, len(urls))
+	ready := make([]chan empty, len(urls))
+	for i, url := range urls {
+		ready[i] = make(chan empty)
+		go func(i int, url string) {
+			defer close(ready[i])
+			results[i] = irc.queueTitle(req, url)
+		}(i, url)
+	}
+	for i := range urls {
+		<-ready[i]
+		irc.deliverTitle(req, results[i])
+	}
+}
+
+// titleResult is the outcome of titling a link, to be sent once the titles
+// of any links before it in the same message have been
+type titleResult struct {
+	url, title, handler string
+	warning             string // sent instead of a title, for malicious links (or errors)
+	nsfw                bool
+	redundant           bool // the title just repeats the link or the message
+	historyID           int64
+}
+
+// titleNow titles a link (see title, which queues it for a worker),
+// returning the title to send with deliverTitle, if any
+func (irc *Bot) titleNow(req titleRequest, url string) (result titleResult) {
+	url = canonicalizeURL(url)
+	if irc.ctx.Err() != nil {
+		return // shutting down
 	}
-	defer irc.releaseSemaphore()
 
 	defer func() {
 		if r := recover(); r != nil {
-			irc.Log.Printf("Caught panic in callback: %v\n%s", r, debug.Stack())
+			irc.logError("caught panic while titling", "url", url, "target", req.target, "panic", r, "stack", string(debug.Stack()))
+			irc.reportPanic(r, "url", url, "channel", req.target)
 		}
 	}()
 
+	host, err := urlHost(url)
+	if err != nil || !irc.domainAllowed(req.target, host) {
+		irc.logDebug("filter", "not titling: domain not allowed", "url", url, "target", req.target)
+		return
+	}
+	nsfw := domainListMatch(host, irc.nsfwDomains)
+	if nsfw && irc.suppressesNSFW(req.target) {
+		irc.logDebug("filter", "not titling: NSFW titles are suppressed", "url", url, "target", req.target)
+		return
+	}
+
 	start := time.Now()
 	defer func() {
-		if irc.Debug {
-			irc.Log.Printf("Titled %s in %v\n", url, time.Since(start))
-		}
+		irc.logDebug("http", "titled", "url", url, "target", req.target, "duration", time.Since(start))
 	}()
 
+	if threat := irc.checkMalicious(url); threat != "" {
+		irc.logInfo("not titling: reported as malicious", "url", url, "target", req.target, "threat", threat)
+		if !irc.skipMalicious {
+			result.warning = fmt.Sprintf(maliciousWarning, threat)
+		}
+		return
+	}
+
+	// handler is only set for API handlers, whose failures are tracked as
+	// a whole rather than by domain
+	var title, siteName, failure, detail, handler string
+	settings := irc.state.getChannelSettings(req.target)
 	if twid := extractTweetID(url); twid != "" {
-		irc.titleTwitter(target, msgid, twid)
+		handler = "twitter"
+		irc.stats.handlerUsed(handler)
+		title, failure = irc.titleTwitter(twid)
+	} else if isMatrixTo(url) {
+		irc.stats.handlerUsed("matrix")
+		if title, _ = describeMatrixTo(url); title == "" {
+			failure = errNoTitle
+		}
+	} else if isIRCURL(url) {
+		irc.stats.handlerUsed("irc")
+		if title, _ = describeIRCURL(url); title == "" {
+			failure = errNoTitle
+		}
+	} else if cid, rest, ok := ipfsPath(url); ok {
+		// title the content at our gateway, which may be up when the
+		// one in the link isn't
+		if title, siteName, failure, detail = irc.titleGeneric(irc.ipfsGatewayURL(cid, rest), settings.SiteName); title != "" {
+			title = fmt.Sprintf("%s (IPFS %s)", title, shortCID(cid))
+		}
+	} else if isGopher(url) {
+		irc.stats.handlerUsed("gopher")
+		title, failure = irc.titleGopher(url)
+	} else {
+		title, siteName, failure, detail = irc.titleGeneric(url, settings.SiteName)
+	}
+	if failure != "" {
+		irc.stats.fetchError(failure)
+	} else {
+		irc.stats.fetchSucceeded()
+	}
+	irc.recordFetchResult(handler, host, failure)
+	if title != "" {
+		title = cleanTitle(irc.titleCleanup, host, title)
+	}
+	historyID := irc.recordHistory(req, url, title)
+	if title == "" {
+		if failure != "" && !req.background && settings.Errors {
+			if summary := errorSummary(failure, detail); summary != "" {
+				result.warning = errorPrefix + summary
+			}
+		}
+		return
+	}
+	// warnings make a title worth sending even if it's redundant
+	_, mixed := decodeHost(host)
+	redundant := !req.requested && !nsfw && !mixed && titleRedundant(url, title, req.message)
+	title = siteNamePrefix(siteName, title) + title
+	if nsfw {
+		title = nsfwPrefix + title
+	}
+	if mixed {
+		title = fmt.Sprintf(homographWarning, displayHost(host)) + title
+	}
+	return titleResult{url: url, title: title, handler: handler, nsfw: nsfw, redundant: redundant, historyID: historyID}
+}
+
+// deliverTitle sends the result of titleNow, subject to flood control
+func (irc *Bot) deliverTitle(req titleRequest, result titleResult) {
+	if result.warning == "" && result.title == "" {
+		return
+	}
+	if req.inChannel() && !irc.floodControl.allow(req.target, req.background) {
+		irc.logDebug("filter", "not titling: flood control", "url", result.url, "target", req.target)
+		return
+	}
+	if result.warning != "" {
+		irc.sendTitle(req, result.warning)
+		return
+	}
+	if result.redundant {
+		irc.logDebug("filter", "not sending title: it repeats the link or the message", "url", result.url, "target", req.target)
 	} else {
-		irc.titleGeneric(target, msgid, url)
+		irc.sendTitle(req, req.prefix+result.title)
+		irc.stats.titleSent(req.target, result.url, result.title, req.inChannel() && !req.spoiler)
 	}
+	irc.recordLink(req, result.url, result.title, result.handler, result.nsfw)
+	irc.archiveLink(req, result.url, result.historyID)
 }
 
-func (irc *Bot) checkErr(err error, message string) (fatal bool) {
+// checkErr logs err (if it's non-nil) with the message and any
+// additional key-value attributes
+func (irc *Bot) checkErr(err error, message string, args ...interface{}) (fatal bool) {
 	if err != nil {
-		irc.Log.Printf("%s: %v", message, err)
+		irc.logError(message, append(args, "error", err)...)
 		return true
 	}
 	return false
@@ -162,38 +538,40 @@ type Tweet struct {
 	}
 }
 
-func (irc *Bot) titleTwitter(target, msgid, twid string) {
+// titleTwitter and titleGeneric return the title, or if they couldn't
+// find it, the category of the error (see stats.go)
+func (irc *Bot) titleTwitter(twid string) (title, failure string) {
 	if irc.TwitterBearerToken == "" {
-		irc.Log.Printf("set TITLEBOT_TWITTER_BEARER_TOKEN to read tweets\n")
+		irc.logError("set TITLEBOT_TWITTER_BEARER_TOKEN to read tweets", "handler", "twitter")
 		return
 	}
 	url := fmt.Sprintf("https://api.twitter.com/2/tweets/%s?tweet.fields=created_at&expansions=author_id&user.fields=verified", twid)
-	req, err := http.NewRequest("GET", url, nil)
-	if irc.checkErr(err, "NewRequest error in titleTwitter") {
-		return
+	req, err := http.NewRequestWithContext(irc.ctx, "GET", url, nil)
+	if irc.checkErr(err, "NewRequest error", "handler", "twitter", "tweet", twid) {
+		return "", errRequest
 	}
 	headers := map[string][]string{
 		"Authorization": {fmt.Sprintf("Bearer %s", irc.TwitterBearerToken)},
 	}
 	req.Header = headers
 	resp, err := httpClient.Do(req)
-	if irc.checkErr(err, "http error in titleTwitter") {
-		return
+	if irc.checkErr(err, "http error", "handler", "twitter", "tweet", twid) {
+		return "", errNetwork
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		irc.Log.Printf("bad http code in titleTwitter: %d\n", resp.StatusCode)
-		return
+		irc.logError("bad http code", "handler", "twitter", "tweet", twid, "status", resp.StatusCode)
+		return "", errStatus
 	}
-	br := io.LimitedReader{R: resp.Body, N: trustedReadLimit}
+	br := io.LimitedReader{R: resp.Body, N: int64(irc.settings.get().trustedReadLimit)}
 	body, err := io.ReadAll(&br)
-	if irc.checkErr(err, "error reading tweet") {
-		return
+	if irc.checkErr(err, "error reading tweet", "handler", "twitter", "tweet", twid) {
+		return "", errRead
 	}
 	var tweet Tweet
 	err = json.Unmarshal(body, &tweet)
-	if irc.checkErr(err, "error deserializing tweet") {
-		return
+	if irc.checkErr(err, "error deserializing tweet", "handler", "twitter", "tweet", twid) {
+		return "", errParse
 	}
 	var author string
 	var verified bool
@@ -205,8 +583,8 @@ func (irc *Bot) titleTwitter(target, msgid, twid string) {
 		}
 	}
 	ts, err := time.Parse(IRCv3TimestampFormat, tweet.Data.CreatedAt)
-	if irc.checkErr(err, "invalid time created in tweet") {
-		return
+	if irc.checkErr(err, "invalid time created in tweet", "handler", "twitter", "tweet", twid) {
+		return "", errParse
 	}
 	maybeCheckmark := ""
 	if verified {
@@ -215,8 +593,7 @@ func (irc *Bot) titleTwitter(target, msgid, twid string) {
 	timeStr := displayTwitterTime(ts)
 	// https://stackoverflow.com/questions/30704063/the-twitter-api-seems-to-escape-ampersand-but-nothing-else
 	safeText := ircutils.SanitizeText(html.UnescapeString(tweet.Data.Text), titleCharLimit)
-	message := fmt.Sprintf("(@%s%s, %s) %s", author, maybeCheckmark, timeStr, safeText)
-	irc.sendReplyNotice(target, msgid, message)
+	return fmt.Sprintf("(@%s%s, %s) %s", author, maybeCheckmark, timeStr, safeText), ""
 }
 
 func displayTwitterTime(then time.Time) string {
@@ -261,53 +638,91 @@ func humanReadableDuration(d time.Duration) string {
 	return out.String()
 }
 
-func (irc *Bot) titleGeneric(target, msgid, url string) {
-	byteLimit, titleRe, err := irc.analyzeURL(url)
-	if irc.checkErr(err, "invalid URL") {
-		return
+// titleGeneric titles a web page, and if wantSiteName is set, finds the
+// name the page gives its site; if it fails, detail is a brief description
+// of the failure for users, e.g. "404 Not Found"
+func (irc *Bot) titleGeneric(url string, wantSiteName bool) (title, siteName, failure, detail string) {
+	byteLimit, titleRe, handler, err := irc.analyzeURL(url)
+	if irc.checkErr(err, "invalid URL", "url", url) {
+		return "", "", errRequest, ""
 	}
-	req, err := http.NewRequest("GET", url, nil)
-	if irc.checkErr(err, "NewRequest error in titleTwitter") {
-		return
+	irc.stats.handlerUsed(handler)
+	req, err := http.NewRequestWithContext(irc.ctx, "GET", url, nil)
+	if irc.checkErr(err, "NewRequest error", "url", url, "handler", handler) {
+		return "", "", errRequest, ""
 	}
 	headers := map[string][]string{
-		"User-Agent": {irc.userAgent},
+		"User-Agent": {irc.settings.get().userAgent},
 	}
 	req.Header = headers
 
 	resp, err := httpClient.Do(req)
-	if irc.checkErr(err, "http error in titleGeneric") {
-		return
+	if irc.checkErr(err, "http error", "url", url, "handler", handler) {
+		return "", "", errNetwork, describeNetworkError(err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		if irc.Debug {
-			irc.Log.Printf("Can't title %s : HTTP code %d\n", url, resp.StatusCode)
-		}
-		return
+		irc.logDebug("http", "can't title: bad http code", "url", url, "handler", handler, "status", resp.StatusCode)
+		return "", "", errStatus, describeStatus(resp.StatusCode)
 	}
-	br := io.LimitedReader{R: resp.Body, N: int64(byteLimit)}
-	body, err := io.ReadAll(&br)
+	if description, ok := irc.describeContent(url, resp, byteLimit); ok {
+		return description, "", "", ""
+	}
+	buf := bodyBuffers.get(byteLimit)
+	defer bodyBuffers.put(buf)
+	body, truncated, err := readUntilMatch(resp.Body, *buf, byteLimit, titleRe)
 	// ErrUnexpectedEOF is OK if we didn't get the whole page
 	if !(err == nil || err == io.ErrUnexpectedEOF) {
-		irc.Log.Printf("couldn't read in titleGeneric: %v\n", err)
-		return
+		irc.logError("couldn't read page", "url", url, "handler", handler, "error", err)
+		return "", "", errRead, ""
 	}
-	success := false
-	titleMatch := titleRe.FindSubmatch(body)
-	if len(titleMatch) == 2 {
-		title := string(titleMatch[1])
+	titleMatch := titleRe.FindSubmatchIndex(body)
+	if len(titleMatch) == 4 {
+		title = string(body[titleMatch[2]:titleMatch[3]])
 		title = html.UnescapeString(title)
 		title = strings.TrimSpace(title)
 		title = ircutils.SanitizeText(title, titleCharLimit)
-		if len(title) != 0 {
-			success = true
-			irc.sendReplyNotice(target, msgid, title)
+	}
+	if handler == "generic" {
+		sample := fetchSample{
+			large:     byteLimit > irc.settings.get().readLimit,
+			found:     title != "",
+			truncated: truncated,
+		}
+		if titleMatch != nil {
+			sample.needed = titleMatch[1]
+		}
+		irc.recordReadLimitSample(url, sample)
+	}
+	if title == "" {
+		irc.logDebug("http", "can't title: title not found", "url", url, "handler", handler)
+		return "", "", errNoTitle, ""
+	}
+	if wantSiteName {
+		siteName, body = readSiteName(resp.Body, body, byteLimit)
+	}
+	if handler == "generic" && (irc.readingTime || irc.productInfo) {
+		page, complete := readRest(resp.Body, body, irc.settings.get().trustedReadLimit)
+		title = irc.annotateTitle(title, page, complete)
+	}
+	return title, siteName, "", ""
+}
+
+// annotateTitle adds what we can tell from the rest of the page to its
+// title: the price, availability, and rating of a product, or how long an
+// article takes to read (which needs the complete page)
+func (irc *Bot) annotateTitle(title string, page []byte, complete bool) string {
+	if irc.productInfo {
+		if product, ok := findProduct(page); ok {
+			return title + " — " + product.String()
 		}
 	}
-	if !success && irc.Debug {
-		irc.Log.Printf("Can't title %s : title not found\n", url)
+	if irc.readingTime && complete {
+		if estimate := readingTime(page); estimate != "" {
+			return title + " (" + estimate + ")"
+		}
 	}
+	return title
 }
 
 func domainMatch(host, domain string) bool {
@@ -328,6 +743,25 @@ var garbageJSDomains = []string{
 	"github.com",
 }
 
+// domainAllowed checks host against the configured and runtime blocklists,
+// and against the allowlist for target, if it has one
+func (irc *Bot) domainAllowed(target, host string) bool {
+	for _, domain := range irc.blockedDomains {
+		if domainMatch(host, domain) {
+			return false
+		}
+	}
+	if irc.remoteBlocklist != nil && irc.remoteBlocklist.contains(host) {
+		return false
+	}
+	return irc.state.domainAllowed(target, host)
+}
+
+func (irc *Bot) suppressesNSFW(channel string) bool {
+	_, ok := irc.nsfwSuppress[strings.ToLower(channel)]
+	return ok
+}
+
 func isGarbageJSDomain(host string) bool {
 	for _, domain := range garbageJSDomains {
 		if domainMatch(host, domain) {
@@ -337,7 +771,8 @@ func isGarbageJSDomain(host string) bool {
 	return false
 }
 
-func (irc *Bot) analyzeURL(urlStr string) (byteLimit int, titleRe *regexp.Regexp, err error) {
+// urlHost returns the lowercased hostname of a URL, without the port
+func urlHost(urlStr string) (hostLower string, err error) {
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return
@@ -346,58 +781,216 @@ func (irc *Bot) analyzeURL(urlStr string) (byteLimit int, titleRe *regexp.Regexp
 	if splitHost, _, err := net.SplitHostPort(host); err == nil {
 		host = splitHost
 	}
-	hostLower := strings.ToLower(host)
+	return strings.ToLower(host), nil
+}
+
+func (irc *Bot) analyzeURL(urlStr string) (byteLimit int, titleRe *regexp.Regexp, handler string, err error) {
+	hostLower, err := urlHost(urlStr)
+	if err != nil {
+		return
+	}
+	limits := irc.settings.get()
 	if domainMatch(hostLower, "youtube.com") || domainMatch(hostLower, "youtu.be") {
 		// with youtube we have to check for the <meta> tag instead of <title>
-		return trustedReadLimit, youtubeTitleRe, nil
-	} else if isGarbageJSDomain(hostLower) {
-		return trustedReadLimit, genericTitleRe, nil
+		return limits.trustedReadLimit, youtubeTitleRe, "youtube", nil
+	} else if irc.readLimits.large(hostLower) {
+		return limits.trustedReadLimit, genericTitleRe, "generic", nil
 	} else {
-		return genericTitleReadLimit, genericTitleRe, nil
+		return limits.readLimit, genericTitleRe, "generic", nil
 	}
 }
 
-func (irc *Bot) handleOwnerCommand(target, command string) {
-	if !strings.HasPrefix(command, irc.Nick) {
-		return
-	}
-	command = strings.TrimPrefix(command, irc.Nick)
-	command = strings.TrimPrefix(command, ":")
-	f := strings.Fields(command)
-	if len(f) == 0 {
-		return
+// handleOwnerCommand handles the commands restricted to users on the ACL
+func (irc *Bot) handleOwnerCommand(target, msgid string, level permLevel, f []string) (handled bool) {
+	command := strings.ToLower(f[0])
+	required, ok := ownerCommandLevels[command]
+	if !ok || level == permNone {
+		return false
+	} else if level < required {
+		irc.sendReplyNotice(target, msgid, "you don't have permission to do that")
+		return true
 	}
-	switch strings.ToLower(f[0]) {
+	switch command {
 	case "abuse":
 		if len(f) > 1 {
 			irc.Privmsg(target, fmt.Sprintf("%s isn't a real programmer", f[1]))
 		}
 	case "quit":
-		irc.Quit()
+		go irc.shutdown()
+	case "ignore", "unignore":
+		irc.handleIgnoreCommand(target, msgid, f)
+	case "block", "unblock":
+		irc.handleBlockCommand(target, msgid, f)
+	case "allow", "disallow":
+		irc.handleAllowCommand(target, msgid, f)
+	case "join":
+		if len(f) > 1 {
+			var key string
+			if len(f) > 2 {
+				key = f[2]
+			}
+			irc.joinChannel(f[1], key)
+		}
+	case "part":
+		if len(f) > 1 {
+			irc.partChannel(f[1])
+		} else if strings.HasPrefix(target, "#") {
+			irc.partChannel(target)
+		}
+	case "nick":
+		if len(f) > 1 {
+			irc.SetNick(f[1])
+		}
+	case "stats":
+		irc.sendReplyNotice(target, msgid, irc.stats.summary())
+	case "set":
+		irc.handleSetCommand(target, msgid, f)
+	case "loglevel":
+		irc.handleLogCommand(target, msgid, f)
+	case "domain":
+		irc.handleDomainCommand(target, msgid, f)
+	case "export":
+		irc.handleExportCommand(target, msgid, f)
+	}
+	return true
+}
+
+// sendTitle sends text to wherever the request came from
+func (irc *Bot) sendTitle(req titleRequest, text string) {
+	if req.xmpp != nil {
+		irc.checkErr(req.xmpp.sendGroupchat(req.target, text), "couldn't send to XMPP room", "room", req.target)
+		return
 	}
+	irc.sendNotice(req.target, req.msgid, text, true)
 }
 
 func (irc *Bot) sendReplyNotice(target, msgid, text string) {
-	if msgid == "" {
-		irc.Notice(target, text)
-	} else {
-		irc.SendWithTags(map[string]string{replyTagName: msgid}, "NOTICE", target, text)
+	irc.sendNotice(target, msgid, text, false)
+}
+
+func (irc *Bot) sendNotice(target, msgid, text string, title bool) {
+	irc.output.add(text)
+	var tags map[string]string
+	if msgid != "" {
+		tags = map[string]string{replyTagName: msgid}
+	}
+	irc.queueMessage(tags, "NOTICE", target, text, title)
+}
+
+// parseChannelList parses a comma-delimited list of channels
+func parseChannelList(channels string) (result []string) {
+	for _, channel := range strings.Split(channels, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			result = append(result, channel)
+		}
+	}
+	return
+}
+
+// parseChannelSet parses a comma-delimited list of channels
+// into a set of lowercased channel names
+func parseChannelSet(channels string) (result map[string]empty) {
+	result = make(map[string]empty)
+	for _, channel := range parseChannelList(channels) {
+		result[strings.ToLower(channel)] = empty{}
+	}
+	return
+}
+
+// parseDomainList parses a comma-delimited list of domains
+func parseDomainList(domains string) (result []string) {
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			result = append(result, domain)
+		}
+	}
+	return
+}
+
+func (irc *Bot) processesNotices(channel string) bool {
+	_, ok := irc.noticeChannels[strings.ToLower(channel)]
+	return ok
+}
+
+// recordLastSeen records the server-time of a message in a channel
+func (irc *Bot) recordLastSeen(channel string, e ircmsg.Message) {
+	if present, ts := e.GetTag("time"); present {
+		irc.lastSeenMutex.Lock()
+		defer irc.lastSeenMutex.Unlock()
+		irc.lastSeen[strings.ToLower(channel)] = ts
 	}
 }
 
-func ownerMatches(e ircmsg.Message, owner string) bool {
-	if owner == "" {
+func (irc *Bot) getLastSeen(channel string) string {
+	irc.lastSeenMutex.Lock()
+	defer irc.lastSeenMutex.Unlock()
+	return irc.lastSeen[strings.ToLower(channel)]
+}
+
+// requestCatchup requests the history of a channel since we last saw it
+func (irc *Bot) requestCatchup(channel string) {
+	if _, ok := irc.AcknowledgedCaps()[chathistoryCap]; !ok {
+		return
+	}
+	since := irc.getLastSeen(channel)
+	if since == "" {
+		return
+	}
+	limit := chathistoryLimit
+	if serverLimit, err := strconv.Atoi(irc.ISupport()["CHATHISTORY"]); err == nil && serverLimit > 0 && serverLimit < limit {
+		limit = serverLimit
+	}
+	irc.Send("CHATHISTORY", "AFTER", channel, "timestamp="+since, strconv.Itoa(limit))
+}
+
+// handleCatchup titles links from a chathistory batch that we missed while disconnected
+func (irc *Bot) handleCatchup(batch *ircevent.Batch) bool {
+	// BATCH +<reference> chathistory <target>
+	if len(batch.Params) < 3 || batch.Params[1] != "chathistory" {
 		return false
 	}
-	if present, account := e.GetTag("account"); present && account == owner {
+	if !irc.isActive() {
 		return true
 	}
-	return false
+	channel := batch.Params[2]
+	var missed []ircmsg.Message
+	for _, item := range batch.Items {
+		if item.Command != "PRIVMSG" || len(item.Params) < 2 {
+			continue
+		}
+		irc.recordLastSeen(channel, item.Message)
+		msg, ok := irc.unwrapRelay(item.Message)
+		if !ok || isBotOutput(msg) || irc.ignoreSender(msg) || irc.output.isRelayed(msg.Params[1]) || irc.optedOut(msg) {
+			continue
+		}
+		if findURL(msg.Params[1]) != nil {
+			missed = append(missed, msg)
+		}
+	}
+	// if we missed a lot, prefer the most recent messages
+	if len(missed) > catchupMessageLimit {
+		missed = missed[len(missed)-catchupMessageLimit:]
+	}
+	if len(missed) != 0 && irc.autoTitles(channel) {
+		go func() {
+			for _, msg := range missed {
+				_, msgid := msg.GetTag("msgid")
+				urls, spoilers := irc.extractLinks(channel, msgid, "", msg.Params[1])
+				req := titleRequest{target: channel, msgid: msgid, prefix: catchupPrefix, background: true, nick: msg.Nick(), account: messageAccount(msg), message: msg.Params[1]}
+				irc.titleLinks(req, urls, spoilers)
+			}
+		}()
+	}
+	return true
 }
 
 func newBot() *Bot {
 	// required:
 	nick := os.Getenv("TITLEBOT_NICK")
+	// optional nick to use if TITLEBOT_NICK is taken when connecting
+	// (we keep trying to get TITLEBOT_NICK back, using NickServ GHOST if
+	// SASL is configured):
+	altNick := os.Getenv("TITLEBOT_ALT_NICK")
 	server := os.Getenv("TITLEBOT_SERVER")
 	// required (comma-delimited list of channels)
 	channels := os.Getenv("TITLEBOT_CHANNELS")
@@ -406,19 +999,410 @@ func newBot() *Bot {
 	saslPassword := os.Getenv("TITLEBOT_SASL_PASSWORD")
 	// a Twitter API key (v2-capable) is optional (if unset, Twitter support is disabled):
 	twitterToken := os.Getenv("TITLEBOT_TWITTER_BEARER_TOKEN")
-	// owner is optional (if unset, titlebot won't accept any owner commands)
-	owner := os.Getenv("TITLEBOT_OWNER_ACCOUNT")
+	// the ACL is optional (if unset, titlebot won't accept any owner commands);
+	// it's a comma-delimited list of account:level, where level is one of
+	// admin, channel-manager, or trusted (see permLevel):
+	acl, err := parseACL(os.Getenv("TITLEBOT_ACL"))
+	if err != nil {
+		log.Fatalf("invalid TITLEBOT_ACL: %v", err)
+	}
+	// for compatibility, this account is an admin:
+	if owner := os.Getenv("TITLEBOT_OWNER_ACCOUNT"); owner != "" {
+		acl[strings.ToLower(owner)] = permAdmin
+	}
 	// more optional settings
 	version := os.Getenv("TITLEBOT_VERSION")
 	if version == "" {
 		version = "github.com/ergochat/irc-go"
 	}
-	debug := os.Getenv("TITLEBOT_DEBUG") != ""
+	// log verbosity (error, info, or debug); TITLEBOT_DEBUG is equivalent to debug.
+	// This can be changed at runtime with the loglevel command, which can also
+	// enable debug logging for individual subsystems:
+	logLevel, ok := parseLogLevel(os.Getenv("TITLEBOT_LOG_LEVEL"))
+	if !ok && os.Getenv("TITLEBOT_LOG_LEVEL") != "" {
+		log.Fatalf("invalid TITLEBOT_LOG_LEVEL: %s", os.Getenv("TITLEBOT_LOG_LEVEL"))
+	}
+	if os.Getenv("TITLEBOT_DEBUG") != "" {
+		logLevel = levelDebug
+	}
+	// report panics and errors like failures to save state to Sentry:
+	sentryDSN := os.Getenv("TITLEBOT_SENTRY_DSN")
+	if sentryDSN != "" {
+		if err := initSentry(sentryDSN, version); err != nil {
+			log.Fatalf("invalid TITLEBOT_SENTRY_DSN: %v", err)
+		}
+	}
+	// also log to this file, rotating it when it reaches a maximum size in MB
+	// (default 100) or age (as a Go duration, by default unlimited), and
+	// keeping a number of the rotated files (default 5):
+	var logOutput io.Writer = os.Stderr
+	if logFile := os.Getenv("TITLEBOT_LOG_FILE"); logFile != "" {
+		maxSize := int64(defaultLogMaxSize)
+		if mb, err := strconv.Atoi(os.Getenv("TITLEBOT_LOG_MAX_SIZE")); err == nil && mb > 0 {
+			maxSize = int64(mb) * 1024 * 1024
+		}
+		maxAge, _ := time.ParseDuration(os.Getenv("TITLEBOT_LOG_MAX_AGE"))
+		maxBackups, err := strconv.Atoi(os.Getenv("TITLEBOT_LOG_MAX_BACKUPS"))
+		if err != nil || maxBackups < 0 {
+			maxBackups = defaultLogMaxBackups
+		}
+		file, err := openRotatingFile(logFile, maxSize, maxAge, maxBackups)
+		if err != nil {
+			log.Fatalf("couldn't open TITLEBOT_LOG_FILE: %v", err)
+		}
+		logOutput = io.MultiWriter(os.Stderr, file)
+	}
+	// log as JSON instead of key=value text:
+	logHandler := newLogHandler(logOutput, os.Getenv("TITLEBOT_LOG_FORMAT"))
 	insecure := os.Getenv("TITLEBOT_INSECURE_SKIP_VERIFY") != ""
 	userAgent := os.Getenv("TITLEBOT_USER_AGENT")
 	if userAgent == "" {
 		userAgent = defaultUserAgent
 	}
+	// title links in the existing topic when joining a channel
+	// (topic changes are always titled):
+	titleTopicOnJoin := os.Getenv("TITLEBOT_TITLE_TOPIC_ON_JOIN") != ""
+	// comma-delimited list of channels where links in NOTICEs are also titled
+	// (e.g., for announcements relayed by RSS or CI bots):
+	noticeChannels := os.Getenv("TITLEBOT_NOTICE_CHANNELS")
+	// channel flood control: maximum titles per minute in each channel,
+	// and how many can be sent in a burst (set the limit to -1 to disable):
+	channelRateLimit, err := strconv.Atoi(os.Getenv("TITLEBOT_CHANNEL_RATE_LIMIT"))
+	if err != nil || channelRateLimit == 0 {
+		channelRateLimit = defaultChannelRateLimit
+	}
+	channelBurst, err := strconv.Atoi(os.Getenv("TITLEBOT_CHANNEL_BURST"))
+	if err != nil || channelBurst <= 0 {
+		channelBurst = defaultChannelBurst
+	}
+	// pace outgoing messages to stay under the server's flood limit: messages
+	// per second, and how many can be sent in a burst (set the rate to -1 to
+	// send messages immediately):
+	var sendQueue *sendQueue
+	sendRate, err := strconv.ParseFloat(os.Getenv("TITLEBOT_SEND_RATE"), 64)
+	if err != nil || sendRate == 0 {
+		sendRate = defaultSendRate
+	}
+	sendBurst, err := strconv.Atoi(os.Getenv("TITLEBOT_SEND_BURST"))
+	if err != nil || sendBurst <= 0 {
+		sendBurst = defaultSendBurst
+	}
+	if sendRate > 0 {
+		sendQueue = newSendQueue(sendRate, sendBurst)
+	}
+	// title links sent in direct messages from anyone (not just the owner),
+	// subject to a per-user limit on messages per minute:
+	titlePrivmsgs := os.Getenv("TITLEBOT_TITLE_PRIVMSGS") != ""
+	privmsgRateLimit, err := strconv.Atoi(os.Getenv("TITLEBOT_PRIVMSG_RATE_LIMIT"))
+	if err != nil || privmsgRateLimit <= 0 {
+		privmsgRateLimit = defaultPrivmsgRateLimit
+	}
+	// links marked as spoilers (||https://example.com||, or a message starting
+	// with [spoiler] or [nsfw]) are titled with the title hidden by default;
+	// set to "skip" to not title them at all:
+	skipSpoilers := strings.ToLower(os.Getenv("TITLEBOT_SPOILERS")) == "skip"
+	// follow the titles of articles with an estimate of how long they take
+	// to read, e.g. "(~7 min read)"; this reads the whole page (up to the
+	// trusted read limit) instead of stopping at the title:
+	readingTime := os.Getenv("TITLEBOT_READING_TIME") != ""
+	// follow the titles of products (with schema.org data, as on most
+	// shopping sites) with their price, availability, and rating, e.g.
+	// "— $129.99, in stock, rated 4.6/5 (1234 reviews)"; like the reading
+	// time, this reads the whole page:
+	productInfo := os.Getenv("TITLEBOT_PRODUCT_INFO") != ""
+	// regex (case-insensitive, matched against the whole nick) of bridge bots
+	// that relay messages as `<nick> message`; the messages are unwrapped so
+	// that ignores and opt-outs apply to the original author. Set the relay
+	// mode to "ignore" to ignore their messages entirely instead:
+	var relayNicksRe *regexp.Regexp
+	if relayNicks := os.Getenv("TITLEBOT_RELAY_NICKS"); relayNicks != "" {
+		relayNicksRe, err = regexp.Compile(`(?i)^(?:` + relayNicks + `)$`)
+		if err != nil {
+			log.Fatalf("invalid TITLEBOT_RELAY_NICKS: %v", err)
+		}
+	}
+	ignoreRelays := strings.ToLower(os.Getenv("TITLEBOT_RELAY_MODE")) == "ignore"
+	// comma-delimited list of domains (including their subdomains) never to title,
+	// in addition to the ones blocked at runtime with the `block` command:
+	blockedDomains := parseDomainList(os.Getenv("TITLEBOT_BLOCKED_DOMAINS"))
+	// comma-delimited list of URLs of blocklists (e.g., of malware or phishing
+	// domains) in hosts-file or plain domain list format, and how often to
+	// download them again (as a Go duration, default 24h):
+	var blocklist *remoteBlocklist
+	if blocklistURLs := os.Getenv("TITLEBOT_BLOCKLIST_URLS"); blocklistURLs != "" {
+		blocklist = &remoteBlocklist{refresh: defaultBlocklistRefresh}
+		for _, u := range strings.Split(blocklistURLs, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				blocklist.urls = append(blocklist.urls, u)
+			}
+		}
+		if refresh, err := time.ParseDuration(os.Getenv("TITLEBOT_BLOCKLIST_REFRESH")); err == nil && refresh > 0 {
+			blocklist.refresh = refresh
+		}
+	}
+	// check links against Google Safe Browsing (with this API key) and/or
+	// URLhaus before fetching them; if a link is reported as malicious, post
+	// a warning instead of its title, or with "skip", ignore it silently:
+	safeBrowsingKey := os.Getenv("TITLEBOT_SAFEBROWSING_API_KEY")
+	checkURLhaus := os.Getenv("TITLEBOT_URLHAUS") != ""
+	urlhausKey := os.Getenv("TITLEBOT_URLHAUS_AUTH_KEY")
+	skipMalicious := strings.ToLower(os.Getenv("TITLEBOT_MALICIOUS_LINKS")) == "skip"
+	// comma-delimited list of NSFW domains; their titles are prefixed with [NSFW],
+	// or not sent at all in the channels listed in TITLEBOT_NSFW_SUPPRESS_CHANNELS:
+	nsfwDomains := parseDomainList(os.Getenv("TITLEBOT_NSFW_DOMAINS"))
+	nsfwSuppress := os.Getenv("TITLEBOT_NSFW_SUPPRESS_CHANNELS")
+	// address (e.g., 127.0.0.1:8080) for an HTTP listener serving /healthz,
+	// which reports the state of the IRC connection:
+	httpListen := os.Getenv("TITLEBOT_HTTP_LISTEN")
+	// also serve a status page at / on the listener, showing the connection
+	// state, channels, recent titles (only from channels that publish them
+	// with `linkblog on`), error counters, and the configuration (with
+	// secrets redacted):
+	statusPage := os.Getenv("TITLEBOT_STATUS_PAGE") != ""
+	// also accept POST /announce on the listener, authenticated with this
+	// bearer token, with a JSON body of {"channel": ..., "message": ...} to
+	// relay a message or {"channel": ..., "url": ...} to title a link:
+	announceToken := os.Getenv("TITLEBOT_ANNOUNCE_TOKEN")
+	// the URL the listener can be reached at from outside (e.g., behind a
+	// reverse proxy), for linking to channels' linkblogs: with a database,
+	// channel operators can publish the channel's link history at
+	// /linkblog/<channel> (with RSS and Atom feeds) with `linkblog on`:
+	publicURL := os.Getenv("TITLEBOT_PUBLIC_URL")
+	// if the connection fails (including the first one), retry with
+	// exponential backoff (starting at 5 seconds) up to this delay between
+	// attempts:
+	reconnectMaxDelay, err := time.ParseDuration(os.Getenv("TITLEBOT_RECONNECT_MAX_DELAY"))
+	if err != nil || reconnectMaxDelay < reconnectMinDelay {
+		reconnectMaxDelay = defaultReconnectMaxDelay
+	}
+	// run as one of several instances sharing this lock file (e.g., on a
+	// network filesystem): the instance holding the lock is the primary, and
+	// the others are standbys that take over if the primary stops renewing
+	// the lock for this long (because it died, or couldn't reconnect).
+	// Standbys connect but stay quiet, or with "disconnected", don't connect
+	// until they take over:
+	var ha *haLease
+	if haLock := os.Getenv("TITLEBOT_HA_LOCK"); haLock != "" {
+		haTimeout, err := time.ParseDuration(os.Getenv("TITLEBOT_HA_TIMEOUT"))
+		if err != nil || haTimeout < 5*haClaimSettle {
+			haTimeout = defaultHATimeout
+		}
+		ha = newHALease(haLock, haTimeout, strings.ToLower(os.Getenv("TITLEBOT_HA_STANDBY")) != "disconnected")
+	}
+	// POST a JSON record (channel, nick, url, title, etc.) of each link
+	// titled in a channel to this URL; if the secret is set, the body is
+	// signed with it (X-Titlebot-Signature: sha256=<HMAC-SHA256 in hex>):
+	var hook *webhook
+	if webhookURL := os.Getenv("TITLEBOT_WEBHOOK_URL"); webhookURL != "" {
+		hook = newWebhook(webhookURL, os.Getenv("TITLEBOT_WEBHOOK_SECRET"))
+	}
+	// publish the same JSON records to a message bus: Redis pub/sub
+	// (redis://[user:password@]host:port), NATS (nats://[user:password@]host:port),
+	// or MQTT (mqtt://[user:password@]host:port); rediss://, tls://, and mqtts://
+	// use TLS. The records are published to the topic (by default titlebot.links):
+	var bus *pubsub
+	if pubsubURL := os.Getenv("TITLEBOT_PUBSUB_URL"); pubsubURL != "" {
+		bus, err = newPubSub(pubsubURL, os.Getenv("TITLEBOT_PUBSUB_TOPIC"))
+		if err != nil {
+			log.Fatalf("invalid TITLEBOT_PUBSUB_URL: %v", err)
+		}
+	}
+	// submit links titled in channels for archiving, either to the Wayback
+	// Machine ("wayback") or to an ArchiveBox instance (its base URL), at most
+	// once per interval (by default 10s). The token is optional for the
+	// Wayback Machine ("accesskey:secret" from archive.org/account/s3.php)
+	// and an API key for ArchiveBox. The URL of each capture is recorded
+	// in the link history:
+	var archive *archiver
+	if archiveService := os.Getenv("TITLEBOT_ARCHIVE"); archiveService != "" {
+		archiveInterval, err := time.ParseDuration(os.Getenv("TITLEBOT_ARCHIVE_INTERVAL"))
+		if err != nil || archiveInterval <= 0 {
+			archiveInterval = defaultArchiveInterval
+		}
+		archive = newArchiver(archiveService, os.Getenv("TITLEBOT_ARCHIVE_TOKEN"), archiveInterval)
+	}
+	// shorten long URLs in our output (like archive links) with a self-hosted
+	// shortener: shlink, yourls, or kutt, at the base URL, with the API key
+	// (for YOURLS, the signature token). URLs longer than the length
+	// (by default 80) are shortened:
+	var short *shortener
+	if shortenerKind := os.Getenv("TITLEBOT_SHORTENER"); shortenerKind != "" {
+		shortenLength, err := strconv.Atoi(os.Getenv("TITLEBOT_SHORTEN_LENGTH"))
+		if err != nil || shortenLength <= 0 {
+			shortenLength = defaultShortenLength
+		}
+		short, err = newShortener(shortenerKind, os.Getenv("TITLEBOT_SHORTENER_URL"), os.Getenv("TITLEBOT_SHORTENER_TOKEN"), shortenLength)
+		if err != nil {
+			log.Fatalf("invalid TITLEBOT_SHORTENER: %v", err)
+		}
+	}
+	// a shared read-later account for the save command, used by anyone who
+	// hasn't registered their own (with `readlater`): linkding (the token is
+	// an API token) or wallabag (client_id:client_secret:username:password):
+	var readLater *readLaterAccount
+	if service := os.Getenv("TITLEBOT_READLATER"); service != "" {
+		account, err := newReadLaterAccount(service, os.Getenv("TITLEBOT_READLATER_URL"), os.Getenv("TITLEBOT_READLATER_TOKEN"))
+		if err != nil {
+			log.Fatalf("invalid TITLEBOT_READLATER: %v", err)
+		}
+		readLater = &account
+	}
+	// time zone for displaying times (e.g., the start of an event in a
+	// linked .ics file), by IANA name; by default, UTC:
+	timezone := time.UTC
+	if tz := os.Getenv("TITLEBOT_TIMEZONE"); tz != "" {
+		if timezone, err = time.LoadLocation(tz); err != nil {
+			log.Fatalf("invalid TITLEBOT_TIMEZONE: %v", err)
+		}
+	}
+	// the IPFS gateway to fetch content linked on IPFS (ipfs:// or on
+	// another gateway) from; by default, https://ipfs.io:
+	ipfsGateway := strings.TrimSuffix(os.Getenv("TITLEBOT_IPFS_GATEWAY"), "/")
+	if ipfsGateway == "" {
+		ipfsGateway = defaultIPFSGateway
+	} else if u, err := url.Parse(ipfsGateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Fatalf("invalid TITLEBOT_IPFS_GATEWAY: must be an http or https URL")
+	}
+	// the schemes of the links to title (comma-delimited), out of http,
+	// https, gopher, irc, ircs, ipfs, spotify, and matrix (the last two
+	// being app URIs like spotify:track:<id>); by default, all of them
+	// except gopher:
+	schemes, err := parseSchemes(os.Getenv("TITLEBOT_SCHEMES"))
+	if err != nil {
+		log.Fatalf("invalid TITLEBOT_SCHEMES: %v", err)
+	}
+	urlSchemes = schemeSet(schemes)
+	// custom patterns for links that aren't URLs (whitespace-delimited
+	// pattern=>template pairs), rewritten to URLs with the template, in
+	// which $1 etc. are the pattern's submatches:
+	customPatterns, err := parseURLPatterns(os.Getenv("TITLEBOT_URL_PATTERNS"))
+	if err != nil {
+		log.Fatalf("invalid TITLEBOT_URL_PATTERNS: %v", err)
+	}
+	linkPatterns = append(platformPatterns(schemes), customPatterns...)
+	// remove boilerplate like " - YouTube" from titles: whitespace-delimited
+	// domain=>pattern pairs (* for any domain), in addition to the defaults
+	// for big sites; rules for a domain replace its defaults, and
+	// example.com=> removes them:
+	titleCleanup, err := parseTitleCleanup(os.Getenv("TITLEBOT_TITLE_CLEANUP"))
+	if err != nil {
+		log.Fatalf("invalid TITLEBOT_TITLE_CLEANUP: %v", err)
+	}
+	// also title links in these XMPP multi-user chat rooms (comma-delimited
+	// room JIDs), logging in as the JID (with the server, if it's not
+	// the JID's domain on port 5222) and joining as the nick (by default,
+	// TITLEBOT_NICK):
+	var xmpp *xmppClient
+	if xmppJID := os.Getenv("TITLEBOT_XMPP_JID"); xmppJID != "" {
+		xmppNick := os.Getenv("TITLEBOT_XMPP_NICK")
+		if xmppNick == "" {
+			xmppNick = nick
+		}
+		xmpp = newXMPPClient(xmppJID, os.Getenv("TITLEBOT_XMPP_PASSWORD"), os.Getenv("TITLEBOT_XMPP_SERVER"),
+			xmppNick, parseChannelList(os.Getenv("TITLEBOT_XMPP_ROOMS")))
+	}
+	// send a direct message to these nicks or channels (comma-delimited; by
+	// default, the admin accounts on the ACL) when an API handler fails this
+	// many times in a row, or a domain returns this many errors in an hour
+	// (set either limit to -1 to disable that alert):
+	alertTargets := parseChannelList(os.Getenv("TITLEBOT_ALERT_TARGETS"))
+	if len(alertTargets) == 0 {
+		for account, level := range acl {
+			if level == permAdmin {
+				alertTargets = append(alertTargets, account)
+			}
+		}
+	}
+	alertConsecutive, err := strconv.Atoi(os.Getenv("TITLEBOT_ALERT_CONSECUTIVE_FAILURES"))
+	if err != nil || alertConsecutive == 0 {
+		alertConsecutive = defaultAlertConsecutive
+	}
+	alertDomainErrors, err := strconv.Atoi(os.Getenv("TITLEBOT_ALERT_DOMAIN_ERRORS"))
+	if err != nil || alertDomainErrors == 0 {
+		alertDomainErrors = defaultAlertDomainErrors
+	}
+	// tuning of the connections for fetching links: the maximum connections
+	// to a single host (by default, 16), and the idle connections to keep
+	// per host (by default, 4) and for how long (by default, 90s); how many
+	// TLS sessions to cache for resumption (by default, 512; 0 to disable);
+	// whether to use HTTP/2 (by default, yes); and limits on the size of
+	// the response headers (by default, 64 KiB), the number of headers
+	// (by default, 100), and the time to receive them (by default, 10s):
+	transport := transportConfig{
+		maxConnsPerHost:     defaultMaxConnsPerHost,
+		maxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		idleConnTimeout:     defaultIdleConnTimeout,
+		tlsSessionCacheSize: defaultTLSSessionCacheSize,
+		http2:               os.Getenv("TITLEBOT_HTTP2") != "0",
+		maxHeaderBytes:      defaultMaxHeaderBytes,
+		maxHeaders:          defaultMaxHeaders,
+		headerTimeout:       defaultHeaderTimeout,
+	}
+	if value, err := strconv.Atoi(os.Getenv("TITLEBOT_HTTP_MAX_CONNS_PER_HOST")); err == nil && value > 0 {
+		transport.maxConnsPerHost = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("TITLEBOT_HTTP_MAX_IDLE_PER_HOST")); err == nil && value > 0 {
+		transport.maxIdleConnsPerHost = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("TITLEBOT_HTTP_IDLE_TIMEOUT")); err == nil && value > 0 {
+		transport.idleConnTimeout = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("TITLEBOT_TLS_SESSION_CACHE")); err == nil && value >= 0 {
+		transport.tlsSessionCacheSize = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("TITLEBOT_HTTP_MAX_HEADER_BYTES")); err == nil && value > 0 {
+		transport.maxHeaderBytes = int64(value)
+	}
+	if value, err := strconv.Atoi(os.Getenv("TITLEBOT_HTTP_MAX_HEADERS")); err == nil && value > 0 {
+		transport.maxHeaders = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("TITLEBOT_HTTP_HEADER_TIMEOUT")); err == nil && value > 0 {
+		transport.headerTimeout = value
+	}
+	httpClient.Transport = newTransport(transport)
+	// SQLite database to keep persistent state (e.g., user opt-outs) and the
+	// history of links posted in channels in. Without it, no history is kept,
+	// and the state is saved to TITLEBOT_STATE_FILE if that's set (otherwise
+	// it's lost on restart); with both, the state file is imported into the
+	// database the first time it's used:
+	var db *sql.DB
+	var history *historyStore
+	if dbPath := os.Getenv("TITLEBOT_DATABASE"); dbPath != "" {
+		db, err = openDatabase(dbPath)
+		if err == nil {
+			history, err = newHistoryStore(db)
+		}
+		if err != nil {
+			log.Fatalf("couldn't open TITLEBOT_DATABASE: %v", err)
+		}
+	}
+	// recheck links in the history this long (e.g. 24h) after they were
+	// titled, and say so in the channel if they've died (are 404 or 410, or
+	// their domain is gone), with a link to an archived copy; with
+	// TITLEBOT_RECHECK_NOTIFY=alerts, tell the alert targets instead:
+	var recheckAfter time.Duration
+	if value := os.Getenv("TITLEBOT_RECHECK_AFTER"); value != "" && history != nil {
+		recheckAfter, err = time.ParseDuration(value)
+		if err != nil || recheckAfter <= 0 {
+			log.Fatalf("invalid TITLEBOT_RECHECK_AFTER %q", value)
+		}
+	}
+	recheckAlerts := strings.EqualFold(os.Getenv("TITLEBOT_RECHECK_NOTIFY"), "alerts")
+	// directory for the files written by the export command:
+	exportDir := os.Getenv("TITLEBOT_EXPORT_DIR")
+	state, err := openState(db, os.Getenv("TITLEBOT_STATE_FILE"))
+	if err != nil {
+		log.Fatalf("couldn't load persistent state: %v", err)
+	}
+	// regex (case-insensitive, matched against the whole nick) of nicks to ignore,
+	// e.g. other bots that don't identify themselves with the bot tag:
+	var ignoreNicksRe *regexp.Regexp
+	if ignoreNicks := os.Getenv("TITLEBOT_IGNORE_NICKS"); ignoreNicks != "" {
+		ignoreNicksRe, err = regexp.Compile(`(?i)^(?:` + ignoreNicks + `)$`)
+		if err != nil {
+			log.Fatalf("invalid TITLEBOT_IGNORE_NICKS: %v", err)
+		}
+	}
 
 	var tlsconf *tls.Config
 	if insecure {
@@ -431,46 +1415,203 @@ func newBot() *Bot {
 			Nick:         nick,
 			UseTLS:       true,
 			TLSConfig:    tlsconf,
-			RequestCaps:  []string{"server-time", "message-tags", "account-tag"},
+			RequestCaps:  []string{"server-time", "message-tags", "account-tag", "batch", "multi-prefix", "draft/message-redaction", chathistoryCap},
 			SASLLogin:    saslLogin, // SASL will be enabled automatically if these are set
 			SASLPassword: saslPassword,
 			QuitMessage:  version,
-			Debug:        debug,
+			Log:          slog.NewLogLogger(logHandler, slog.LevelInfo),
 		},
+		// collapse floods of identical messages (but not the protocol log):
+		logger:             slog.New(newDedupHandler(logHandler)),
 		TwitterBearerToken: twitterToken,
-		Owner:              owner,
-		userAgent:          userAgent,
-		semaphore:          make(chan empty, concurrencyLimit),
+		ACL:                acl,
+		titleQueue:         make(chan titleJob, titleQueueLimit),
+		priorityQueue:      make(chan titleJob, priorityQueueLimit),
+		stats:              newBotStats(),
+		readLimits:         newAdaptiveReadLimits(),
+		history:            history,
+		db:                 db,
+		failures:           newFailureTracker(alertConsecutive, alertDomainErrors),
+		alertTargets:       alertTargets,
+		recheckAfter:       recheckAfter,
+		titleCleanup:       titleCleanup,
+		recheckAlerts:      recheckAlerts,
+		httpListen:         httpListen,
+		statusPage:         statusPage,
+		announceToken:      announceToken,
+		publicURL:          publicURL,
+		exportDir:          exportDir,
+		webhook:            hook,
+		pubsub:             bus,
+		archiver:           archive,
+		shortener:          short,
+		readLater:          readLater,
+		timezone:           timezone,
+		ipfsGateway:        ipfsGateway,
+		xmpp:               xmpp,
+		ha:                 ha,
+		sentryEnabled:      sentryDSN != "",
+		configChannels:     parseChannelList(channels),
+		lastSeen:           make(map[string]string),
+		titleTopicOnJoin:   titleTopicOnJoin,
+		noticeChannels:     parseChannelSet(noticeChannels),
+		floodControl:       newFloodControl(channelRateLimit, channelBurst),
+		sendQueue:          sendQueue,
+		blockedDomains:     blockedDomains,
+		remoteBlocklist:    blocklist,
+		safeBrowsingKey:    safeBrowsingKey,
+		useURLhaus:         checkURLhaus || urlhausKey != "",
+		urlhausKey:         urlhausKey,
+		skipMalicious:      skipMalicious,
+		nsfwDomains:        nsfwDomains,
+		nsfwSuppress:       parseChannelSet(nsfwSuppress),
+		titlePrivmsgs:      titlePrivmsgs,
+		state:              state,
+		privmsgLimiter:     newRateLimiter(privmsgRateLimit, privmsgRatePeriod),
+		ignoreNicksRe:      ignoreNicksRe,
+		relayNicksRe:       relayNicksRe,
+		ignoreRelays:       ignoreRelays,
+		skipSpoilers:       skipSpoilers,
+		readingTime:        readingTime,
+		productInfo:        productInfo,
+	}
+	irc.ctx, irc.cancel = context.WithCancel(context.Background())
+	irc.reconnect.maxDelay = reconnectMaxDelay
+	irc.nickRecovery.altNick = altNick
+	irc.setLogLevel(logLevel)
+	irc.settings.values = tunables{
+		userAgent:        userAgent,
+		readLimit:        genericTitleReadLimit,
+		trustedReadLimit: trustedReadLimit,
+		maxURLs:          maxUrlsPerMessage,
 	}
+	irc.loadSettings()
 
 	irc.AddConnectCallback(func(e ircmsg.Message) {
 		if botMode := irc.ISupport()["BOT"]; botMode != "" {
 			irc.Send("MODE", irc.CurrentNick(), "+"+botMode)
 		}
-		for _, channel := range strings.Split(channels, ",") {
-			irc.Join(strings.TrimSpace(channel))
+		for _, channel := range irc.state.autojoinChannels(irc.configChannels) {
+			if channel.Key == "" {
+				irc.Join(channel.Name)
+			} else {
+				irc.Send("JOIN", channel.Name, channel.Key)
+			}
+			irc.requestCatchup(channel.Name)
 		}
 	})
+	irc.AddBatchCallback(irc.handleCatchup)
+	irc.setupChannelTracking()
+	irc.setupSystemd()
+	irc.setupReconnect()
+	irc.setupNickRecovery()
 	irc.AddCallback("PRIVMSG", func(e ircmsg.Message) {
+		if !irc.isActive() {
+			return
+		}
+		e, ok := irc.unwrapRelay(e)
+		if !ok {
+			return
+		}
 		target, message := e.Params[0], e.Params[1]
+		if irc.ignoreSender(e) || irc.output.isRelayed(message) {
+			return
+		}
+		_, msgid := e.GetTag("msgid")
+		level := irc.ACL.level(e)
+		isChannel := strings.HasPrefix(target, "#")
+		if isChannel {
+			irc.recordLastSeen(target, e)
+		} else {
+			if level < permTrusted && !irc.titlePrivmsgs {
+				return
+			}
+			// direct message: reply to the sender, not to ourselves
+			target = e.Nick()
+		}
+		_, editOf := e.GetTag(editTagName)
+		// users who opted out of titling can still use commands (e.g., optin)
+		urls, spoilers := irc.extractLinks(target, msgid, editOf, message)
+		if (urls != nil || spoilers != nil) && !irc.optedOut(e) && irc.autoTitles(target) {
+			if isChannel || level >= permTrusted || irc.privmsgLimiter.allow(strings.ToLower(target)) {
+				req := titleRequest{target: target, msgid: msgid, nick: e.Nick(), account: messageAccount(e), message: message}
+				go irc.titleLinks(req, urls, spoilers)
+			} else {
+				irc.logDebug("filter", "privmsg rate limit exceeded", "target", target)
+			}
+		}
+		if editOf != "" {
+			// don't re-run commands when they're edited
+			return
+		}
+		if strings.HasPrefix(message, irc.CurrentNick()) {
+			f := irc.parseCommand(message)
+			handled := len(f) != 0 &&
+				(irc.handleOwnerCommand(target, msgid, level, f) ||
+					(isChannel && irc.handleChannelCommand(e, target, msgid, level, f)) ||
+					irc.handleUserCommand(e, target, msgid, f))
+			if !handled && level == permNone {
+				irc.sendReplyNotice(target, msgid, "don't @ me, mortal")
+			}
+		}
+	})
+	irc.AddCallback("NOTICE", func(e ircmsg.Message) {
+		if !irc.isActive() {
+			return
+		}
+		if len(e.Params) < 2 || !irc.processesNotices(e.Params[0]) {
+			return
+		}
+		e, ok := irc.unwrapRelay(e)
+		if !ok {
+			return
+		}
+		if isBotOutput(e) || irc.ignoreSender(e) || irc.output.isRelayed(e.Params[1]) || irc.optedOut(e) || !irc.autoTitles(e.Params[0]) {
+			return
+		}
 		_, msgid := e.GetTag("msgid")
-		fromOwner := ownerMatches(e, irc.Owner)
-		if !strings.HasPrefix(target, "#") && !fromOwner {
+		if urls, spoilers := irc.extractLinks(e.Params[0], msgid, "", e.Params[1]); urls != nil || spoilers != nil {
+			req := titleRequest{target: e.Params[0], msgid: msgid, nick: e.Nick(), account: messageAccount(e), message: e.Params[1]}
+			go irc.titleLinks(req, urls, spoilers)
+		}
+	})
+	irc.AddCallback("TOPIC", func(e ircmsg.Message) {
+		if !irc.isActive() {
 			return
 		}
-		if urls := findURL(message); urls != nil {
-			go irc.titleAll(e.Params[0], msgid, urls)
+		if len(e.Params) < 2 || irc.ignoreSender(e) || irc.optedOut(e) || !irc.autoTitles(e.Params[0]) {
+			return
 		}
-		if fromOwner {
-			irc.handleOwnerCommand(e.Params[0], message)
-		} else if strings.HasPrefix(message, irc.Nick) {
-			irc.sendReplyNotice(e.Params[0], msgid, "don't @ me, mortal")
+		_, msgid := e.GetTag("msgid")
+		if urls, spoilers := irc.extractLinks(e.Params[0], msgid, "", e.Params[1]); urls != nil || spoilers != nil {
+			req := titleRequest{target: e.Params[0], msgid: msgid, nick: e.Nick(), account: messageAccount(e), message: e.Params[1]}
+			go irc.titleLinks(req, urls, spoilers)
+		}
+	})
+	irc.AddCallback(ircevent.RPL_TOPIC, func(e ircmsg.Message) {
+		if !irc.isActive() {
+			return
+		}
+		// 332 <client> <channel> :<topic>, sent on JOIN or in response to TOPIC
+		if !irc.titleTopicOnJoin || len(e.Params) < 3 || !irc.autoTitles(e.Params[1]) {
+			return
+		}
+		if urls, spoilers := irc.extractLinks(e.Params[1], "", "", e.Params[2]); urls != nil || spoilers != nil {
+			go irc.titleLinks(titleRequest{target: e.Params[1], background: true}, urls, spoilers)
+		}
+	})
+	irc.AddCallback("REDACT", func(e ircmsg.Message) {
+		// REDACT <target> <msgid> [<reason>]
+		if len(e.Params) >= 2 {
+			irc.messageLinks.forget(e.Params[1])
 		}
 	})
 	irc.AddCallback("INVITE", func(e ircmsg.Message) {
-		fromOwner := ownerMatches(e, irc.Owner)
-		if fromOwner {
-			irc.Join(e.Params[1])
+		if !irc.isActive() {
+			return
+		}
+		if irc.ACL.level(e) >= permChannelManager && len(e.Params) > 1 {
+			irc.joinChannel(e.Params[1], "")
 		}
 	})
 
@@ -478,10 +1619,47 @@ func newBot() *Bot {
 }
 
 func main() {
+	parseFlags()
 	irc := newBot()
-	err := irc.Connect()
-	if err != nil {
-		log.Fatal(err)
+	for i := 0; i < concurrencyLimit; i++ {
+		go irc.titleWorker()
+	}
+	if irc.sendQueue != nil {
+		go irc.sendLoop()
+	}
+	// /healthz reports that we're not connected until we are
+	if irc.httpListen != "" {
+		go irc.serveHTTP(irc.httpListen)
+	}
+	if irc.ha != nil {
+		go irc.haLoop()
+		if !irc.ha.quiet {
+			irc.ha.waitActive()
+		}
+	}
+	if irc.remoteBlocklist != nil {
+		go irc.blocklistLoop()
+	}
+	if irc.webhook != nil {
+		go irc.webhookLoop()
+	}
+	if irc.pubsub != nil {
+		go irc.pubsubLoop()
+	}
+	if irc.archiver != nil {
+		go irc.archiveLoop()
+	}
+	if irc.recheckAfter != 0 {
+		go irc.recheckLoop()
+	}
+	if irc.xmpp != nil {
+		go irc.xmppLoop(irc.xmpp)
+	}
+	irc.handleSignals()
+	irc.handleDiagnosticSignal()
+	if irc.connect() {
+		irc.Loop()
 	}
-	irc.Loop()
+	irc.closeStorage()
+	sentry.Flush(sentryFlushTimeout)
 }
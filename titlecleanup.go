@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// titleCleanupRule removes boilerplate (e.g. " - YouTube") from the titles
+// of pages on a domain (and its subdomains), or on any domain if it's *
+type titleCleanupRule struct {
+	domain string
+	re     *regexp.Regexp // what to remove
+}
+
+var (
+	amazonDomains = []string{"amazon.com", "amazon.co.uk", "amazon.ca", "amazon.de", "amazon.fr", "amazon.it",
+		"amazon.es", "amazon.co.jp", "amazon.com.au", "amazon.in", "amazon.nl", "amazon.se"}
+
+	defaultTitleCleanup = append([]titleCleanupRule{
+		{"youtube.com", regexp.MustCompile(`\s+-\s+YouTube$`)},
+		{"news.ycombinator.com", regexp.MustCompile(`\s+\|\s+Hacker News$`)},
+		{"github.com", regexp.MustCompile(`^GitHub\s+-\s+`)},
+		{"wikipedia.org", regexp.MustCompile(`\s+-\s+Wikipedia$`)},
+		{"stackoverflow.com", regexp.MustCompile(`\s+-\s+Stack Overflow$`)},
+		{"medium.com", regexp.MustCompile(`\s+\|\s+(?:by\s+.+?\s+\|\s+)?Medium$`)},
+		{"bbc.co.uk", regexp.MustCompile(`\s+-\s+BBC (?:News|Sport)$`)},
+		{"bbc.com", regexp.MustCompile(`\s+-\s+BBC (?:News|Sport)$`)},
+	}, amazonCleanup()...)
+)
+
+// Amazon titles look like "Amazon.com: Product Name : Electronics"
+func amazonCleanup() (rules []titleCleanupRule) {
+	prefix := regexp.MustCompile(`^Amazon\.[a-z.]+\s*:\s*`)
+	suffix := regexp.MustCompile(`\s+:\s+Amazon\.[a-z.]+(?:\s*:.*)?$`)
+	for _, domain := range amazonDomains {
+		rules = append(rules, titleCleanupRule{domain, prefix}, titleCleanupRule{domain, suffix})
+	}
+	return
+}
+
+// parseTitleCleanup parses whitespace-delimited domain=>pattern pairs, e.g.
+// `example.com=>\s\|\sExample$`, adding them to the default rules. Rules
+// for a domain replace the defaults for it, and a rule with no pattern
+// (example.com=>) just removes them.
+func parseTitleCleanup(list string) (rules []titleCleanupRule, err error) {
+	overridden := make(map[string]bool)
+	var configured []titleCleanupRule
+	for _, entry := range strings.Fields(list) {
+		domain, pattern, found := strings.Cut(entry, "=>")
+		domain = strings.ToLower(domain)
+		if !found || domain == "" {
+			return nil, fmt.Errorf("%q isn't of the form domain=>pattern", entry)
+		}
+		overridden[domain] = true
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		configured = append(configured, titleCleanupRule{domain, re})
+	}
+	for _, rule := range defaultTitleCleanup {
+		if !overridden[rule.domain] {
+			rules = append(rules, rule)
+		}
+	}
+	return append(rules, configured...), nil
+}
+
+// cleanTitle removes the boilerplate from the title of a page on host
+// (which must be lowercase), unless that would leave nothing
+func cleanTitle(rules []titleCleanupRule, host, title string) string {
+	cleaned := title
+	for _, rule := range rules {
+		if rule.domain == "*" || domainMatch(host, rule.domain) {
+			cleaned = rule.re.ReplaceAllString(cleaned, "")
+		}
+	}
+	if cleaned = strings.TrimSpace(cleaned); cleaned == "" {
+		return title
+	}
+	return cleaned
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCleanTitle(t *testing.T) {
+	cases := []struct {
+		host, title, expected string
+	}{
+		{"www.youtube.com", "A Video - YouTube", "A Video"},
+		{"example.com", "A Video - YouTube", "A Video - YouTube"},
+		{"en.wikipedia.org", "Go (programming language) - Wikipedia", "Go (programming language)"},
+		{"github.com", "GitHub - slingamn/titlebot: a bot", "slingamn/titlebot: a bot"},
+		{"medium.com", "A Post | by Someone | Medium", "A Post"},
+		{"www.amazon.com", "Amazon.com: A Widget : Electronics", "A Widget : Electronics"},
+		{"www.amazon.co.uk", "A Widget : Amazon.co.uk : Electronics", "A Widget"},
+		// unless that would leave nothing
+		{"github.com", "GitHub - ", "GitHub - "},
+	}
+	for _, c := range cases {
+		if result := cleanTitle(defaultTitleCleanup, c.host, c.title); result != c.expected {
+			t.Errorf("cleanTitle(%q, %q) = %q, expected %q", c.host, c.title, result, c.expected)
+		}
+	}
+}
+
+func TestParseTitleCleanup(t *testing.T) {
+	rules, err := parseTitleCleanup(`youtube.com=> WIKIPEDIA.ORG=>\s\(Wiki\)$ *=>^Home\s-\s`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		host, title, expected string
+	}{
+		{"youtube.com", "A Video - YouTube", "A Video - YouTube"},
+		{"en.wikipedia.org", "Go - Wikipedia", "Go - Wikipedia"},
+		{"en.wikipedia.org", "Go (Wiki)", "Go"},
+		{"example.com", "Home - Example", "Example"},
+		{"github.com", "GitHub - slingamn/titlebot", "slingamn/titlebot"},
+	}
+	for _, c := range cases {
+		if result := cleanTitle(rules, c.host, c.title); result != c.expected {
+			t.Errorf("cleanTitle(%q, %q) = %q, expected %q", c.host, c.title, result, c.expected)
+		}
+	}
+
+	for _, list := range []string{"example.com", "=>foo", "example.com=>("} {
+		if _, err := parseTitleCleanup(list); err == nil {
+			t.Errorf("parseTitleCleanup(%q) should have failed", list)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/ergochat/irc-go/ircutils"
+)
+
+var errBencode = errors.New("invalid or truncated bencoding")
+
+// lists and dictionaries nested deeper than this are rejected, so that a
+// file of nothing but l's can't recurse without bound
+const bencodeMaxDepth = 32
+
+// torrentInfo is what we report about a .torrent file
+type torrentInfo struct {
+	name  string
+	size  int64
+	files int
+}
+
+// bdecode decodes the bencoded value at data[pos:], returning it (as an
+// int64, string, []interface{}, or map[string]interface{}) and where it
+// ends; depth is how many lists and dictionaries it's inside
+func bdecode(data []byte, pos, depth int) (value interface{}, next int, err error) {
+	if pos >= len(data) || depth > bencodeMaxDepth {
+		return nil, pos, errBencode
+	}
+	switch c := data[pos]; {
+	case c == 'i':
+		end := pos + 1
+		for end < len(data) && data[end] != 'e' {
+			end++
+		}
+		if end == len(data) {
+			return nil, pos, errBencode
+		}
+		n, err := strconv.ParseInt(string(data[pos+1:end]), 10, 64)
+		if err != nil {
+			return nil, pos, errBencode
+		}
+		return n, end + 1, nil
+	case c >= '0' && c <= '9':
+		colon := pos
+		for colon < len(data) && data[colon] != ':' {
+			colon++
+		}
+		if colon == len(data) {
+			return nil, pos, errBencode
+		}
+		length, err := strconv.Atoi(string(data[pos:colon]))
+		// (comparing with what's left, since colon+1+length can overflow)
+		if err != nil || length < 0 || length > len(data)-colon-1 {
+			return nil, pos, errBencode
+		}
+		return string(data[colon+1 : colon+1+length]), colon + 1 + length, nil
+	case c == 'l':
+		var list []interface{}
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			if value, pos, err = bdecode(data, pos, depth+1); err != nil {
+				return nil, pos, err
+			}
+			list = append(list, value)
+		}
+		if pos == len(data) {
+			return nil, pos, errBencode
+		}
+		return list, pos + 1, nil
+	case c == 'd':
+		dict := make(map[string]interface{})
+		next, err = bdecodeDict(data, pos, depth, func(key string, value interface{}) {
+			dict[key] = value
+		})
+		return dict, next, err
+	default:
+		return nil, pos, errBencode
+	}
+}
+
+// bdecodeDict decodes the dictionary at data[pos:], calling f with each
+// entry. If the dictionary is truncated, f has been called with the
+// entries before the truncation when it returns the error.
+func bdecodeDict(data []byte, pos, depth int, f func(key string, value interface{})) (next int, err error) {
+	pos++ // 'd'
+	for pos < len(data) && data[pos] != 'e' {
+		key, keyEnd, err := bdecode(data, pos, depth+1)
+		if err != nil {
+			return pos, err
+		}
+		keyStr, ok := key.(string)
+		if !ok {
+			return pos, errBencode
+		}
+		value, valueEnd, err := bdecode(data, keyEnd, depth+1)
+		if err != nil {
+			return pos, err
+		}
+		f(keyStr, value)
+		pos = valueEnd
+	}
+	if pos >= len(data) {
+		return pos, errBencode
+	}
+	return pos + 1, nil
+}
+
+// parseTorrent reads the name, total size, and number of files from the
+// info dictionary of a .torrent file. The file may be truncated: in the info
+// dictionary, the keys we need sort before the (large) piece hashes.
+func parseTorrent(data []byte) (result torrentInfo, ok bool) {
+	if len(data) == 0 || data[0] != 'd' {
+		return
+	}
+	info := make(map[string]interface{})
+	pos := 1
+	for pos < len(data) && data[pos] != 'e' {
+		key, keyEnd, err := bdecode(data, pos, 1)
+		if err != nil {
+			return
+		}
+		if key == "info" {
+			if keyEnd < len(data) && data[keyEnd] == 'd' {
+				bdecodeDict(data, keyEnd, 1, func(key string, value interface{}) {
+					info[key] = value
+				})
+			}
+			break
+		}
+		if _, pos, err = bdecode(data, keyEnd, 1); err != nil {
+			return
+		}
+	}
+
+	name, _ := info["name"].(string)
+	result.name = ircutils.SanitizeText(name, titleCharLimit)
+	if length, ok := info["length"].(int64); ok {
+		result.size, result.files = max(length, 0), 1
+	} else if files, ok := info["files"].([]interface{}); ok {
+		for _, file := range files {
+			if file, ok := file.(map[string]interface{}); ok {
+				// (ignoring nonsensical lengths, rather than overflowing)
+				if length, _ := file["length"].(int64); length > 0 && length <= math.MaxInt64-result.size {
+					result.size += length
+				}
+				result.files++
+			}
+		}
+	}
+	return result, result.name != ""
+}
+
+func (info torrentInfo) String() string {
+	switch info.files {
+	case 0:
+		return "Torrent: " + info.name
+	case 1:
+		return fmt.Sprintf("Torrent: %s, %s", info.name, formatSize(info.size))
+	default:
+		return fmt.Sprintf("Torrent: %s, %s in %d files", info.name, formatSize(info.size), info.files)
+	}
+}
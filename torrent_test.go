@@ -0,0 +1,80 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBdecode(t *testing.T) {
+	cases := []struct {
+		data  string
+		value interface{}
+		next  int
+	}{
+		{"i42e", int64(42), 4},
+		{"i-7exyz", int64(-7), 4},
+		{"4:spam", "spam", 6},
+		{"0:", "", 2},
+		{"l4:spami1ee", []interface{}{"spam", int64(1)}, 11},
+		{"le", []interface{}(nil), 2},
+		{"d3:cow3:mooe", map[string]interface{}{"cow": "moo"}, 12},
+	}
+	for _, c := range cases {
+		value, next, err := bdecode([]byte(c.data), 0, 0)
+		if err != nil || next != c.next || !reflect.DeepEqual(value, c.value) {
+			t.Errorf("bdecode(%q) = %#v, %d, %v; expected %#v, %d", c.data, value, next, err, c.value, c.next)
+		}
+	}
+
+	invalid := []string{
+		"",
+		// truncated
+		"i42", "4:spa", "4", "l4:spam", "d3:cow", "d3:cow3:moo",
+		// malformed
+		"x", "i4x2e", "ie", "-1:a", "4x:spam", "d1:ae", "di1ei2ee",
+		// oversized
+		"9223372036854775807:spam", "9223372036854775808:spam", "99999999999999999999:", "i99999999999999999999e",
+		// nested too deeply
+		strings.Repeat("l", bencodeMaxDepth+2) + strings.Repeat("e", bencodeMaxDepth+2),
+		strings.Repeat("l", 1000000),
+	}
+	for _, data := range invalid {
+		if value, _, err := bdecode([]byte(data), 0, 0); err == nil {
+			t.Errorf("bdecode(%.40q) = %#v; expected an error", data, value)
+		}
+	}
+}
+
+func TestParseTorrent(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected torrentInfo
+		ok       bool
+	}{
+		{"d8:announce3:url4:infod6:lengthi2048e4:name8:file.iso12:piece lengthi16384e6:pieces20:01234567890123456789ee",
+			torrentInfo{name: "file.iso", size: 2048, files: 1}, true},
+		{"d4:infod5:filesld6:lengthi100e4:pathl1:aeed6:lengthi200e4:pathl1:beee4:name3:dir6:pieces4:abcdee",
+			torrentInfo{name: "dir", size: 300, files: 2}, true},
+		// truncated in the piece hashes, after the keys we need
+		{"d4:infod6:lengthi2048e4:name8:file.iso6:pieces20:0123",
+			torrentInfo{name: "file.iso", size: 2048, files: 1}, true},
+		// nonsensical lengths
+		{"d4:infod5:filesld6:lengthi9223372036854775807eed6:lengthi9223372036854775807eed6:lengthi-5eee4:name3:dire",
+			torrentInfo{name: "dir", size: 9223372036854775807, files: 3}, true},
+		{"d4:infod6:lengthi-5e4:name1:xee", torrentInfo{name: "x", size: 0, files: 1}, true},
+		{"d4:infod4:name9223372036854775807:xee", torrentInfo{}, false},
+		{"d8:announce9223372036854775807:x", torrentInfo{}, false},
+		{"d4:infoi1ee", torrentInfo{}, false},
+		{"l4:infoe", torrentInfo{}, false},
+		{"", torrentInfo{}, false},
+	}
+	for _, c := range cases {
+		if info, ok := parseTorrent([]byte(c.data)); info != c.expected || ok != c.ok {
+			t.Errorf("parseTorrent(%q) = %+v, %t; expected %+v, %t", c.data, info, ok, c.expected, c.ok)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// a link dump to a single slow host shouldn't take every connection
+	defaultMaxConnsPerHost     = 16
+	defaultMaxIdleConnsPerHost = 4
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSSessionCacheSize = 512
+	// a server can tie up a worker by trickling out headers, so these
+	// are limited separately from the overall timeout:
+	defaultMaxHeaderBytes = 64 * 1024
+	defaultMaxHeaders     = 100
+	defaultHeaderTimeout  = 10 * time.Second
+)
+
+// transportConfig is the tuning of the HTTP transport for fetching links
+type transportConfig struct {
+	maxConnsPerHost     int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	tlsSessionCacheSize int // 0 to disable TLS session resumption
+	http2               bool
+	maxHeaderBytes      int64
+	maxHeaders          int           // total header values, including trailers
+	headerTimeout       time.Duration // from sending the request to the end of the headers
+}
+
+func newTransport(config transportConfig) http.RoundTripper {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:           256,
+		MaxConnsPerHost:        config.maxConnsPerHost,
+		MaxIdleConnsPerHost:    config.maxIdleConnsPerHost,
+		IdleConnTimeout:        config.idleConnTimeout,
+		TLSHandshakeTimeout:    10 * time.Second,
+		ExpectContinueTimeout:  time.Second,
+		ForceAttemptHTTP2:      config.http2,
+		TLSClientConfig:        &tls.Config{},
+		MaxResponseHeaderBytes: config.maxHeaderBytes,
+		ResponseHeaderTimeout:  config.headerTimeout,
+	}
+	if config.tlsSessionCacheSize > 0 {
+		transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(config.tlsSessionCacheSize)
+	}
+	if !config.http2 {
+		// a non-nil, empty map disables HTTP/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return &headerLimitTransport{Transport: transport, maxHeaders: config.maxHeaders}
+}
+
+// headerLimitTransport rejects responses with too many headers (which
+// MaxResponseHeaderBytes doesn't limit by itself, e.g. with HTTP/2's
+// header compression), and counts trailers against the same limit
+type headerLimitTransport struct {
+	*http.Transport
+	maxHeaders int
+}
+
+func (t *headerLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if count := headerCount(resp.Header); count > t.maxHeaders {
+		resp.Body.Close()
+		return nil, fmt.Errorf("response has too many headers (%d)", count)
+	}
+	resp.Body = &trailerLimitBody{ReadCloser: resp.Body, resp: resp, maxHeaders: t.maxHeaders}
+	return resp, nil
+}
+
+func headerCount(header http.Header) (count int) {
+	for _, values := range header {
+		count += len(values)
+	}
+	return
+}
+
+// trailerLimitBody checks the trailers, which are only read with the
+// end of the body
+type trailerLimitBody struct {
+	io.ReadCloser
+	resp       *http.Response
+	maxHeaders int
+}
+
+func (b *trailerLimitBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if err == io.EOF && headerCount(b.resp.Header)+headerCount(b.resp.Trailer) > b.maxHeaders {
+		return n, fmt.Errorf("response has too many headers and trailers")
+	}
+	return
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// the schemes we have handlers for, and the ones we recognize by default
+// (gopher has to be enabled explicitly, since it's a raw TCP connection
+// to any port the link names)
+var (
+	supportedSchemes = []string{"http", "https", "gopher", "irc", "ircs", "ipfs", "spotify", "matrix"}
+	defaultSchemes   = []string{"http", "https", "irc", "ircs", "ipfs", "spotify", "matrix"}
+)
+
+// linkPatterns are patterns for links that aren't URLs, which are rewritten
+// to URLs for titling: platform URIs like spotify:track:<id>, and the
+// operator's own, like go/foo
+var linkPatterns = platformPatterns(defaultSchemes)
+
+type urlPattern struct {
+	re       *regexp.Regexp
+	template string // expanded with the submatches, as in regexp.Expand
+	// alternatively, converts the match to a URL
+	convert func(match string) string
+}
+
+// parseSchemes parses a comma-delimited list of schemes to recognize
+func parseSchemes(list string) (schemes []string, err error) {
+	if list == "" {
+		return defaultSchemes, nil
+	}
+	for _, scheme := range strings.Split(list, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme == "" {
+			continue
+		}
+		if !sliceContains(supportedSchemes, scheme) {
+			return nil, fmt.Errorf("unsupported scheme %q (supported: %s)", scheme, strings.Join(supportedSchemes, ", "))
+		}
+		schemes = append(schemes, scheme)
+	}
+	if len(schemes) == 0 {
+		return nil, fmt.Errorf("no schemes")
+	}
+	return
+}
+
+// urlSchemes are the schemes of URLs (with a //) that findURL looks for;
+// see TITLEBOT_SCHEMES
+var urlSchemes = schemeSet(defaultSchemes)
+
+func schemeSet(schemes []string) map[string]bool {
+	result := make(map[string]bool)
+	for _, scheme := range schemes {
+		if _, ok := platformURIPatterns[scheme]; !ok {
+			result[scheme] = true
+		}
+	}
+	return result
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isASCIISpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// scanURLs finds the spans of the URLs with urlSchemes in a message: a
+// scheme starting a word, ://, and everything up to the next whitespace.
+// It runs on every message, so rather than using a regex, it looks for
+// the :// and then checks the scheme before it.
+func scanURLs(str string) (spans [][]int) {
+	for offset := 0; offset < len(str); {
+		i := strings.Index(str[offset:], "://")
+		if i == -1 {
+			break
+		}
+		sep := offset + i
+		start := sep
+		for start > offset && (('a' <= str[start-1] && str[start-1] <= 'z') || ('A' <= str[start-1] && str[start-1] <= 'Z')) {
+			start--
+		}
+		if start == sep || (start > 0 && isWordChar(str[start-1])) || !urlSchemes[strings.ToLower(str[start:sep])] {
+			offset = sep + 3
+			continue
+		}
+		end := sep + 3
+		for end < len(str) && !isASCIISpace(str[end]) {
+			end++
+		}
+		spans = append(spans, []int{start, end})
+		offset = end
+	}
+	return
+}
+
+// parseURLPatterns parses whitespace-delimited pattern=>template pairs, e.g.
+// `\bgo/(\S+)=>https://go.example.com/$1`
+func parseURLPatterns(list string) (patterns []urlPattern, err error) {
+	for _, entry := range strings.Fields(list) {
+		pattern, template, found := strings.Cut(entry, "=>")
+		if !found || pattern == "" || template == "" {
+			return nil, fmt.Errorf("%q isn't of the form pattern=>template", entry)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, urlPattern{re: re, template: template})
+	}
+	return
+}
+
+// findPatternURLs finds the links matching linkPatterns in a message,
+// skipping any inside the URLs already found (at spans)
+func findPatternURLs(str string, spans [][]int) (matches []urlMatch) {
+	for _, pattern := range linkPatterns {
+	patternMatches:
+		for _, submatch := range pattern.re.FindAllStringSubmatchIndex(str, -1) {
+			for _, span := range spans {
+				if submatch[0] < span[1] && span[0] < submatch[1] {
+					continue patternMatches
+				}
+			}
+			var url string
+			if pattern.convert != nil {
+				url = pattern.convert(str[submatch[0]:submatch[1]])
+			} else {
+				url = string(pattern.re.ExpandString(nil, pattern.template, str, submatch))
+			}
+			matches = append(matches, urlMatch{submatch[0], url})
+		}
+	}
+	return
+}
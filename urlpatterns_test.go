@@ -0,0 +1,134 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// schemeURLRe is the regex that scanURLs replaced, kept as a baseline
+func schemeURLRe(schemes []string) *regexp.Regexp {
+	var quoted []string
+	for scheme := range schemeSet(schemes) {
+		quoted = append(quoted, regexp.QuoteMeta(scheme))
+	}
+	return regexp.MustCompile(`\b(?i)((?:` + strings.Join(quoted, "|") + `)://.*?)(\s|$)`)
+}
+
+func regexURLSpans(re *regexp.Regexp, str string) (spans [][]int) {
+	for _, match := range re.FindAllStringSubmatchIndex(str, -1) {
+		spans = append(spans, match[2:4])
+	}
+	return
+}
+
+var scanURLsMessages = []string{
+	"",
+	"no links here, just a long enough message to be typical of a channel",
+	"check this out https://example.com/2024/05/why-go-is-great.html it's good",
+	"HTTPS://EXAMPLE.COM and http://example.org/a?b=c#d\tgopher://example.net/1",
+	"xhttp://example.com 1http://example.com _http://example.com ftp://example.com",
+	"://example.com http:// https://a https://b",
+	"(see https://example.com/page) or <https://example.org>",
+	"ircs://irc.example.com/#chat, then irc://irc.example.net:6667/",
+}
+
+func TestScanURLsMatchesRegex(t *testing.T) {
+	re := schemeURLRe(defaultSchemes)
+	for _, message := range scanURLsMessages {
+		if spans, expected := scanURLs(message), regexURLSpans(re, message); !reflect.DeepEqual(spans, expected) {
+			t.Errorf("scanURLs(%q) = %v, but the regex finds %v", message, spans, expected)
+		}
+	}
+}
+
+func BenchmarkScanURLs(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, message := range scanURLsMessages {
+			scanURLs(message)
+		}
+	}
+}
+
+func BenchmarkScanURLsRegex(b *testing.B) {
+	re := schemeURLRe(defaultSchemes)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, message := range scanURLsMessages {
+			regexURLSpans(re, message)
+		}
+	}
+}
+
+func TestParseSchemes(t *testing.T) {
+	cases := []struct {
+		list     string
+		expected []string
+		err      bool
+	}{
+		{"", defaultSchemes, false},
+		{"https", []string{"https"}, false},
+		{" HTTP , https,,gopher ", []string{"http", "https", "gopher"}, false},
+		{"ftp", nil, true},
+		{" , ", nil, true},
+	}
+	for _, c := range cases {
+		schemes, err := parseSchemes(c.list)
+		if (err != nil) != c.err || !reflect.DeepEqual(schemes, c.expected) {
+			t.Errorf("parseSchemes(%q) = %v, %v; expected %v (error: %t)", c.list, schemes, err, c.expected, c.err)
+		}
+	}
+}
+
+func TestParseURLPatterns(t *testing.T) {
+	cases := []struct {
+		list  string
+		count int
+		err   bool
+	}{
+		{"", 0, false},
+		{`\bgo/(\S+)=>https://go.example.com/$1`, 1, false},
+		{`\bgo/(\S+)=>https://go.example.com/$1 \bT(\d+)=>https://tickets.example.com/$1`, 2, false},
+		{`\bgo/(\S+)`, 0, true},
+		{`=>https://example.com`, 0, true},
+		{`\bgo/(\S+)=>`, 0, true},
+		{`go/(=>https://example.com`, 0, true},
+	}
+	for _, c := range cases {
+		patterns, err := parseURLPatterns(c.list)
+		if (err != nil) != c.err || len(patterns) != c.count {
+			t.Errorf("parseURLPatterns(%q) = %d patterns, %v; expected %d (error: %t)", c.list, len(patterns), err, c.count, c.err)
+		}
+	}
+}
+
+func TestFindPatternURLs(t *testing.T) {
+	patterns, err := parseURLPatterns(`\bgo/(\w+)=>https://go.example.com/$1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := linkPatterns
+	linkPatterns = patterns
+	defer func() { linkPatterns = saved }()
+
+	cases := []struct {
+		message  string
+		expected []string
+	}{
+		{"nothing to see", nil},
+		{"see go/wiki", []string{"https://go.example.com/wiki"}},
+		{"go/a then https://example.com then go/b",
+			[]string{"https://go.example.com/a", "https://example.com", "https://go.example.com/b"}},
+		// inside a URL that was already found
+		{"https://example.com/go/wiki", []string{"https://example.com/go/wiki"}},
+	}
+	for _, c := range cases {
+		if result := findURL(c.message); !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("findURL(%q) = %v, expected %v", c.message, result, c.expected)
+		}
+	}
+}
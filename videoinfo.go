@@ -0,0 +1,297 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// videoInfo is what we can learn about a video from its container's headers
+type videoInfo struct {
+	duration      time.Duration
+	width, height int
+}
+
+func (info videoInfo) String() string {
+	var details []string
+	if info.duration > 0 {
+		details = append(details, formatClock(info.duration))
+	}
+	if info.width > 0 && info.height > 0 {
+		details = append(details, formatResolution(info.width, info.height))
+	}
+	return strings.Join(details, ", ")
+}
+
+// formatResolution describes the standard resolutions as e.g. 1080p
+// (whichever way up the video is), and the others by their dimensions
+func formatResolution(width, height int) string {
+	switch short := min(width, height); short {
+	case 240, 360, 480, 720, 1080, 1440, 2160, 4320:
+		return fmt.Sprintf("%dp", short)
+	default:
+		return fmt.Sprintf("%d×%d", width, height)
+	}
+}
+
+// describeVideo reads the duration and resolution of an MP4 or Matroska
+// (including WebM) video. For MP4, the metadata (the moov box) may be after
+// the media data at the end of the file, in which case we fetch it with
+// a ranged request, as long as it's within byteLimit.
+func (irc *Bot) describeVideo(url string, head []byte, byteLimit int) (info videoInfo, ok bool) {
+	if len(head) >= 4 && binary.BigEndian.Uint32(head) == ebmlHeaderID {
+		info = matroskaInfo(head)
+	} else {
+		moov, offset, size := findMP4Box(head, "moov")
+		if size == 0 {
+			size = int64(byteLimit)
+		}
+		if moov == nil && offset > 0 && size <= int64(byteLimit) {
+			data, err := irc.fetchRange(url, offset, size)
+			if irc.checkErr(err, "couldn't fetch video metadata", "url", url) {
+				return
+			}
+			moov, _, _ = findMP4Box(data, "moov")
+		}
+		if moov == nil {
+			return
+		}
+		info = mp4Info(moov)
+	}
+	return info, info.duration > 0 || info.height > 0
+}
+
+// fetchRange fetches `size` bytes of url, starting at `offset`
+func (irc *Bot) fetchRange(url string, offset, size int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(irc.ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("ranged request returned status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, size))
+}
+
+// mp4Box reads the header of an MP4 box, returning its type and the sizes
+// of the header and of the whole box (or 0 if it extends to the end of the file)
+func mp4Box(data []byte) (boxType string, headerSize int, size int64, ok bool) {
+	if len(data) < 8 {
+		return
+	}
+	size = int64(binary.BigEndian.Uint32(data))
+	boxType = string(data[4:8])
+	headerSize = 8
+	if size == 1 {
+		if len(data) < 16 {
+			return
+		}
+		size = int64(binary.BigEndian.Uint64(data[8:16]))
+		headerSize = 16
+	}
+	if size != 0 && size < int64(headerSize) {
+		return
+	}
+	return boxType, headerSize, size, true
+}
+
+// findMP4Box looks for a top-level box. If it's in data, it returns the box's
+// contents; if data ends before the end of the box (or before we find it),
+// it returns the offset in the file to read more from, and how much to read
+// (or 0 if we don't know).
+func findMP4Box(data []byte, wanted string) (contents []byte, offset, size int64) {
+	var position int64
+	for {
+		// (comparing with what's left of data, since the sizes come from
+		// the file, and a huge box size could overflow position+size)
+		if int64(len(data))-position < 8 {
+			// the next box is past what we read (e.g., after a large mdat)
+			return nil, position, 0
+		}
+		boxType, headerSize, boxSize, ok := mp4Box(data[position:])
+		if !ok {
+			return nil, 0, 0
+		}
+		if boxType == wanted {
+			if boxSize == 0 || boxSize > int64(len(data))-position {
+				// it starts here, but it's truncated
+				return nil, position, boxSize
+			}
+			return data[position+int64(headerSize) : position+boxSize], 0, 0
+		}
+		if boxSize == 0 || boxSize > math.MaxInt64-position {
+			return nil, 0, 0
+		}
+		position += boxSize
+	}
+}
+
+// mp4Info reads the duration from the movie header (mvhd) and the
+// dimensions from the track headers (tkhd) in a moov box
+func mp4Info(moov []byte) (info videoInfo) {
+	for len(moov) >= 8 {
+		boxType, headerSize, size, ok := mp4Box(moov)
+		if !ok || size == 0 || size > int64(len(moov)) {
+			break
+		}
+		box := moov[headerSize:size]
+		switch boxType {
+		case "mvhd":
+			// version, then (by version) the timescale and duration
+			if len(box) >= 20 && box[0] == 0 {
+				timescale := binary.BigEndian.Uint32(box[12:16])
+				duration := binary.BigEndian.Uint32(box[16:20])
+				if timescale > 0 {
+					info.duration = time.Duration(int64(duration) * int64(time.Second) / int64(timescale))
+				}
+			} else if len(box) >= 32 && box[0] == 1 {
+				timescale := binary.BigEndian.Uint32(box[20:24])
+				duration := binary.BigEndian.Uint64(box[24:32])
+				if timescale > 0 {
+					info.duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+				}
+			}
+		case "trak":
+			// the dimensions are the last two fields of tkhd, as 16.16 fixed point
+			if tkhd, _, _ := findMP4Box(box, "tkhd"); len(tkhd) >= 84 {
+				end := len(tkhd)
+				width := int(binary.BigEndian.Uint32(tkhd[end-8:end-4]) >> 16)
+				height := int(binary.BigEndian.Uint32(tkhd[end-4:end]) >> 16)
+				if width*height > info.width*info.height {
+					info.width, info.height = width, height
+				}
+			}
+		}
+		moov = moov[size:]
+	}
+	return
+}
+
+const (
+	ebmlHeaderID     = 0x1A45DFA3
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549A966
+	mkvTimecodeScale = 0x2AD7B1
+	mkvDuration      = 0x4489
+	mkvTracks        = 0x1654AE6B
+	mkvTrackEntry    = 0xAE
+	mkvVideo         = 0xE0
+	mkvPixelWidth    = 0xB0
+	mkvPixelHeight   = 0xBA
+	mkvCluster       = 0x1F43B675
+)
+
+// ebmlVint reads a variable-length integer: an element ID (keeping the
+// length marker) or a size (without it; all ones means unknown)
+func ebmlVint(data []byte, keepMarker bool) (value uint64, length int, unknown bool) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false
+	}
+	length = 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > len(data) {
+		return 0, 0, false
+	}
+	value = uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xff >> length)
+	}
+	allOnes := value == uint64(0xff>>length)
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xff
+	}
+	return value, length, !keepMarker && allOnes
+}
+
+func ebmlUint(data []byte) (value uint64) {
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return
+}
+
+// matroskaInfo walks the EBML elements down to the segment information
+// (duration) and the video tracks (dimensions), stopping at the first cluster
+func matroskaInfo(data []byte) (info videoInfo) {
+	timecodeScale := uint64(1000000) // nanoseconds
+	var duration float64
+	var walk func(data []byte) bool
+	walk = func(data []byte) bool {
+		for len(data) > 0 {
+			id, idLen, _ := ebmlVint(data, true)
+			if idLen == 0 {
+				return false
+			}
+			size, sizeLen, unknown := ebmlVint(data[idLen:], false)
+			if sizeLen == 0 {
+				return false
+			}
+			data = data[idLen+sizeLen:]
+			truncated := unknown || size > uint64(len(data))
+			body := data
+			if !truncated {
+				body = data[:size]
+			}
+			switch id {
+			case mkvCluster:
+				return false
+			case mkvSegment, mkvInfo, mkvTracks, mkvTrackEntry, mkvVideo:
+				if !walk(body) {
+					return false
+				}
+			default:
+				if truncated {
+					return false
+				}
+				switch id {
+				case mkvTimecodeScale:
+					timecodeScale = ebmlUint(body)
+				case mkvDuration:
+					switch len(body) {
+					case 4:
+						duration = float64(math.Float32frombits(binary.BigEndian.Uint32(body)))
+					case 8:
+						duration = math.Float64frombits(binary.BigEndian.Uint64(body))
+					}
+				case mkvPixelWidth:
+					if info.width == 0 {
+						info.width = int(ebmlUint(body))
+					}
+				case mkvPixelHeight:
+					if info.height == 0 {
+						info.height = int(ebmlUint(body))
+					}
+				}
+			}
+			if truncated {
+				// the element runs past what we read
+				return false
+			}
+			data = data[size:]
+		}
+		return true
+	}
+	walk(data)
+	// (rejecting NaN, and durations too long for a time.Duration)
+	if nanos := duration * float64(timecodeScale); nanos > 0 && nanos < math.MaxInt64 {
+		info.duration = time.Duration(nanos)
+	}
+	return
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func testMP4Box(boxType string, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, boxType...), body...)
+}
+
+// testMP4LargeBox is a box header with a 64-bit size, and no contents
+func testMP4LargeBox(boxType string, size uint64) []byte {
+	box := binary.BigEndian.AppendUint32(nil, 1)
+	box = append(box, boxType...)
+	return binary.BigEndian.AppendUint64(box, size)
+}
+
+func testMP4Movie() []byte {
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)   // timescale
+	binary.BigEndian.PutUint32(mvhd[16:], 125000) // duration
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 1920<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 1080<<16)
+	return testMP4Box("moov", testMP4Box("mvhd", mvhd), testMP4Box("trak", testMP4Box("tkhd", tkhd)))
+}
+
+func TestFindMP4Box(t *testing.T) {
+	ftyp := testMP4Box("ftyp", []byte("isom"))
+	moov := testMP4Movie()
+	cases := []struct {
+		name     string
+		data     []byte
+		contents []byte
+		offset   int64
+		size     int64
+	}{
+		{"found", append(append([]byte(nil), ftyp...), moov...), moov[8:], 0, 0},
+		{"truncated", append(append([]byte(nil), ftyp...), moov[:20]...), nil, int64(len(ftyp)), int64(len(moov))},
+		{"after a large mdat", append(append([]byte(nil), ftyp...), testMP4LargeBox("mdat", 1<<30)...), nil, int64(len(ftyp)) + 1<<30, 0},
+		{"header cut off", append(append([]byte(nil), ftyp...), moov[:4]...), nil, int64(len(ftyp)), 0},
+		{"zero size", append(append([]byte(nil), ftyp...), 0, 0, 0, 0, 'f', 'r', 'e', 'e'), nil, 0, 0},
+		{"zero size, wanted", append(append([]byte(nil), ftyp...), 0, 0, 0, 0, 'm', 'o', 'o', 'v'), nil, int64(len(ftyp)), 0},
+		{"smaller than its header", append(append([]byte(nil), ftyp...), 0, 0, 0, 4, 'm', 'o', 'o', 'v'), nil, 0, 0},
+		{"huge largesize", append(append([]byte(nil), ftyp...), testMP4LargeBox("mdat", math.MaxInt64)...), nil, 0, 0},
+		{"huge largesize, wanted", append(append([]byte(nil), ftyp...), testMP4LargeBox("moov", math.MaxInt64)...), nil, int64(len(ftyp)), math.MaxInt64},
+		{"largesize beyond int64", append(append([]byte(nil), ftyp...), testMP4LargeBox("mdat", math.MaxUint64)...), nil, 0, 0},
+	}
+	for _, c := range cases {
+		contents, offset, size := findMP4Box(c.data, "moov")
+		if !bytes.Equal(contents, c.contents) || offset != c.offset || size != c.size {
+			t.Errorf("%s: got %d bytes, offset %d, size %d; expected %d bytes, offset %d, size %d",
+				c.name, len(contents), offset, size, len(c.contents), c.offset, c.size)
+		}
+	}
+}
+
+func TestMP4Info(t *testing.T) {
+	moov, _, _ := findMP4Box(testMP4Movie(), "moov")
+	if info := mp4Info(moov); info.duration != 125*time.Second || info.width != 1920 || info.height != 1080 {
+		t.Errorf("got %+v", info)
+	}
+	// a track whose tkhd has a huge size
+	trak := testMP4Box("trak", testMP4LargeBox("tkhd", math.MaxInt64-4))
+	if info := mp4Info(trak); info != (videoInfo{}) {
+		t.Errorf("got %+v from a bad track", info)
+	}
+	// no prefix of a file may panic
+	file := append(testMP4Box("ftyp", []byte("isom")), testMP4Movie()...)
+	for i := range file {
+		if moov, _, _ := findMP4Box(file[:i], "moov"); moov != nil {
+			mp4Info(moov)
+		}
+	}
+}
+
+// testEBML encodes an element with a 1-byte size, or an 8-byte size if
+// the contents are too long (or size is given)
+func testEBML(id uint32, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	element := binary.BigEndian.AppendUint32(nil, id)
+	for len(element) > 1 && element[0] == 0 {
+		element = element[1:]
+	}
+	if len(body) < 0x7f {
+		element = append(element, 0x80|byte(len(body)))
+	} else {
+		element = append(element, 0x01)
+		element = append(element, binary.BigEndian.AppendUint64(nil, uint64(len(body)))[1:]...)
+	}
+	return append(element, body...)
+}
+
+func testEBMLFloat(value float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(value))
+}
+
+func testMatroska(duration float64) []byte {
+	// the segment's size is unknown (all ones), as when it's being streamed
+	segment := []byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	return bytes.Join([][]byte{
+		testEBML(ebmlHeaderID, testEBML(0x4282, []byte("webm"))),
+		segment,
+		testEBML(mkvInfo, testEBML(mkvTimecodeScale, []byte{0x0f, 0x42, 0x40}), testEBML(mkvDuration, testEBMLFloat(duration))),
+		testEBML(mkvTracks, testEBML(mkvTrackEntry, testEBML(mkvVideo,
+			testEBML(mkvPixelWidth, []byte{0x05, 0x00}), testEBML(mkvPixelHeight, []byte{0x02, 0xd0})))),
+		testEBML(mkvCluster, []byte{0}),
+	}, nil)
+}
+
+func TestMatroskaInfo(t *testing.T) {
+	if info := matroskaInfo(testMatroska(90500)); info.duration != 90500*time.Millisecond || info.width != 1280 || info.height != 720 {
+		t.Errorf("got %+v", info)
+	}
+	for _, duration := range []float64{1e300, math.NaN(), math.Inf(1), -5} {
+		if info := matroskaInfo(testMatroska(duration)); info.duration != 0 || info.width != 1280 {
+			t.Errorf("duration %v: got %+v", duration, info)
+		}
+	}
+
+	file := testMatroska(90500)
+	for i := range file {
+		matroskaInfo(file[:i])
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"zero size", append(testEBML(ebmlHeaderID), testEBML(mkvSegment, testEBML(mkvInfo))...)},
+		{"huge size", append(testEBML(ebmlHeaderID), 0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe)},
+		{"huge element in the segment", append(testEBML(ebmlHeaderID), testEBML(mkvSegment,
+			[]byte{0x15, 0x49, 0xa9, 0x66, 0x01, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})...)},
+		{"invalid size", append(testEBML(ebmlHeaderID), 0x18, 0x53, 0x80, 0x67, 0x00)},
+		{"invalid ID", []byte{0x00, 0x81, 0x00}},
+	}
+	for _, c := range cases {
+		if info := matroskaInfo(c.data); info != (videoInfo{}) {
+			t.Errorf("%s: got %+v", c.name, info)
+		}
+	}
+}
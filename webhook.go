@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// how many records can be waiting to be posted before we start dropping them
+const webhookQueueSize = 256
+
+// linkRecord describes a titled link; it's posted to TITLEBOT_WEBHOOK_URL
+// and published to TITLEBOT_PUBSUB_URL
+type linkRecord struct {
+	Channel   string    `json:"channel"`
+	Nick      string    `json:"nick"`
+	Account   string    `json:"account,omitempty"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Handler   string    `json:"handler"`
+	NSFW      bool      `json:"nsfw"`
+	MsgID     string    `json:"msgid,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// webhook posts records to a URL in the background, so that a slow
+// endpoint can't hold up titling
+type webhook struct {
+	url    string
+	secret string // if set, the body is signed with HMAC-SHA256
+	queue  chan linkRecord
+}
+
+func newWebhook(url, secret string) *webhook {
+	return &webhook{url: url, secret: secret, queue: make(chan linkRecord, webhookQueueSize)}
+}
+
+// recordLink queues a link titled in a channel for the webhook and
+// pub/sub; like the history, it excludes direct messages and spoilers
+func (irc *Bot) recordLink(req titleRequest, url, title, handler string, nsfw bool) {
+	if (irc.webhook == nil && irc.pubsub == nil) || req.spoiler || !req.inChannel() {
+		return
+	}
+	if handler == "" {
+		handler = "generic"
+	}
+	record := linkRecord{
+		Channel:   req.target,
+		Nick:      req.nick,
+		Account:   req.account,
+		URL:       url,
+		Title:     title,
+		Handler:   handler,
+		NSFW:      nsfw,
+		MsgID:     req.msgid,
+		Timestamp: time.Now().UTC(),
+	}
+	if irc.webhook != nil {
+		select {
+		case irc.webhook.queue <- record:
+		default:
+			irc.logError("webhook queue is full, dropping record", "url", url)
+		}
+	}
+	if irc.pubsub != nil {
+		select {
+		case irc.pubsub.queue <- record:
+		default:
+			irc.logError("pub/sub queue is full, dropping record", "url", url)
+		}
+	}
+}
+
+func (irc *Bot) webhookLoop() {
+	for record := range irc.webhook.queue {
+		irc.checkErr(irc.postWebhook(record), "couldn't post to webhook", "url", record.URL)
+	}
+}
+
+func (irc *Bot) postWebhook(record linkRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(irc.ctx, "POST", irc.webhook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", irc.settings.get().userAgent)
+	if irc.webhook.secret != "" {
+		mac := hmac.New(sha256.New, []byte(irc.webhook.secret))
+		mac.Write(body)
+		req.Header.Set("X-Titlebot-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"time"
+)
+
+const (
+	// links waiting for one of the concurrencyLimit workers; beyond this,
+	// they're dropped
+	titleQueueLimit = 512
+	// links that wait this long for a worker (e.g., behind a flood of
+	// slow links) are dropped, since their titles would be stale
+	titleQueueTimeout = 30 * time.Second
+	// explicitly requested titles have their own queue, which workers
+	// take from first, so that they aren't the ones dropped in a flood
+	priorityQueueLimit = 64
+)
+
+// titleJob is a link waiting in the queue to be titled
+type titleJob struct {
+	req      titleRequest
+	url      string
+	deadline time.Time
+	result   *titleResult
+	done     chan empty // closed once the link is titled or dropped
+}
+
+// title titles a single link and sends its title
+func (irc *Bot) title(req titleRequest, url string) {
+	irc.inFlight.Add(1)
+	defer irc.inFlight.Done()
+	irc.deliverTitle(req, irc.queueTitle(req, url))
+}
+
+// queueTitle queues a link to be titled by the worker pool, waiting until it
+// is (or until it's dropped, because the queue is full or it waited too long)
+func (irc *Bot) queueTitle(req titleRequest, url string) (result titleResult) {
+	job := titleJob{req: req, url: url, deadline: time.Now().Add(titleQueueTimeout), result: &result, done: make(chan empty)}
+	queue := irc.titleQueue
+	if req.requested {
+		queue = irc.priorityQueue
+	}
+	select {
+	case queue <- job:
+	default:
+		irc.logInfo("title queue is full, not titling", "url", url, "target", req.target)
+		irc.stats.saturated()
+		return
+	}
+	<-job.done
+	return
+}
+
+// titleWorker titles links from the queues, taking explicitly requested
+// ones first; there are concurrencyLimit of them
+func (irc *Bot) titleWorker() {
+	for {
+		var job titleJob
+		select {
+		case job = <-irc.priorityQueue:
+		default:
+			select {
+			case job = <-irc.priorityQueue:
+			case job = <-irc.titleQueue:
+			}
+		}
+		if time.Now().After(job.deadline) {
+			irc.logInfo("link waited too long in the title queue, not titling", "url", job.url, "target", job.req.target)
+			irc.stats.saturated()
+		} else {
+			*job.result = irc.titleNow(job.req, job.url)
+		}
+		close(job.done)
+	}
+}
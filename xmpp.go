@@ -0,0 +1,381 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircfmt"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	xmppResource = "titlebot"
+	// how often to send a whitespace keepalive, to notice dead connections
+	xmppKeepAlive = time.Minute
+	xmppTimeout   = time.Minute
+	// how often a standby checks whether to connect (or a primary whether
+	// it's become a standby and has to disconnect): two instances would
+	// keep replacing each other's session, since the resource is fixed
+	xmppStandbyCheck = 10 * time.Second
+)
+
+var errXMPPStreamClosed = errors.New("stream closed by the server")
+
+// xmppClient is a minimal XMPP client that joins multi-user chat rooms
+// (XEP-0045) and titles the links posted there, using the same pipeline
+// (and per-channel settings, keyed by the room's JID) as IRC. It supports
+// STARTTLS and SASL PLAIN, which is all that a typical server requires.
+type xmppClient struct {
+	jid      string // user@domain
+	password string
+	server   string // host:port; by default, the domain's port 5222
+	nick     string // our nick in the rooms
+	rooms    []string
+
+	conn       net.Conn
+	dec        *xml.Decoder
+	writeMutex sync.Mutex
+	backoff    reconnectBackoff
+}
+
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+type xmppMessage struct {
+	From  string    `xml:"from,attr"`
+	Type  string    `xml:"type,attr"`
+	ID    string    `xml:"id,attr"`
+	Body  string    `xml:"body"`
+	Delay *struct{} `xml:"urn:xmpp:delay delay"` // room history sent on join
+}
+
+type xmppIQ struct {
+	From string    `xml:"from,attr"`
+	ID   string    `xml:"id,attr"`
+	Type string    `xml:"type,attr"`
+	Ping *struct{} `xml:"urn:xmpp:ping ping"`
+}
+
+func newXMPPClient(jid, password, server, nick string, rooms []string) *xmppClient {
+	if server == "" {
+		_, domain, _ := strings.Cut(jid, "@")
+		server = net.JoinHostPort(domain, "5222")
+	}
+	return &xmppClient{
+		jid:      jid,
+		password: password,
+		server:   server,
+		nick:     nick,
+		rooms:    rooms,
+		backoff:  reconnectBackoff{maxDelay: defaultReconnectMaxDelay},
+	}
+}
+
+func (c *xmppClient) domain() string {
+	_, domain, _ := strings.Cut(c.jid, "@")
+	return domain
+}
+
+func (c *xmppClient) write(format string, args ...interface{}) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.conn == nil {
+		return net.ErrClosed
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(xmppTimeout))
+	_, err := fmt.Fprintf(c.conn, format, args...)
+	return err
+}
+
+func xmlEscape(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// next returns the next top-level element of the stream
+func (c *xmppClient) next() (xml.StartElement, error) {
+	for {
+		token, err := c.dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			return token, nil
+		case xml.EndElement:
+			if token.Name.Local == "stream" {
+				return xml.StartElement{}, errXMPPStreamClosed
+			}
+		}
+	}
+}
+
+// openStream (re)starts the XML stream, returning the server's features
+func (c *xmppClient) openStream() (features xmppFeatures, err error) {
+	err = c.write("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>",
+		xmlEscape(c.domain()))
+	if err != nil {
+		return
+	}
+	c.dec = xml.NewDecoder(c.conn)
+	for {
+		start, err := c.next()
+		if err != nil {
+			return features, err
+		}
+		if start.Name.Local == "features" {
+			err = c.dec.DecodeElement(&features, &start)
+			return features, err
+		}
+	}
+}
+
+// expect reads the next element, failing unless it's named `name`
+func (c *xmppClient) expect(name string) error {
+	start, err := c.next()
+	if err != nil {
+		return err
+	}
+	if start.Name.Local != name {
+		return fmt.Errorf("expected <%s>, got <%s>", name, start.Name.Local)
+	}
+	return c.dec.Skip()
+}
+
+// connect logs in and joins the rooms
+func (c *xmppClient) connect() (err error) {
+	conn, err := net.DialTimeout("tcp", c.server, xmppTimeout)
+	if err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	c.conn = conn
+	c.writeMutex.Unlock()
+	conn.SetReadDeadline(time.Now().Add(xmppTimeout))
+
+	features, err := c.openStream()
+	if err != nil {
+		return err
+	}
+	if features.StartTLS == nil {
+		return errors.New("server doesn't support STARTTLS")
+	}
+	if err = c.write("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	if err = c.expect("proceed"); err != nil {
+		return err
+	}
+	// the certificate is for the XMPP domain, not whichever host serves it
+	// (e.g., the one set with TITLEBOT_XMPP_SERVER)
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: c.domain()})
+	if err = tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	c.conn = tlsConn
+	c.writeMutex.Unlock()
+
+	if features, err = c.openStream(); err != nil {
+		return err
+	}
+	if !sliceContains(features.Mechanisms, "PLAIN") {
+		return errors.New("server doesn't support SASL PLAIN")
+	}
+	user, _, _ := strings.Cut(c.jid, "@")
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00" + user + "\x00" + c.password))
+	if err = c.write("<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='PLAIN'>%s</auth>", credentials); err != nil {
+		return err
+	}
+	if err = c.expect("success"); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+
+	if _, err = c.openStream(); err != nil {
+		return err
+	}
+	err = c.write("<iq type='set' id='bind'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><resource>%s</resource></bind></iq>", xmppResource)
+	if err != nil {
+		return err
+	}
+	var bind xmppIQ
+	start, err := c.next()
+	if err == nil {
+		err = c.dec.DecodeElement(&bind, &start)
+	}
+	if err != nil {
+		return err
+	}
+	if bind.Type != "result" {
+		return errors.New("resource binding failed")
+	}
+
+	if err = c.write("<presence/>"); err != nil {
+		return err
+	}
+	for _, room := range c.rooms {
+		// don't ask for the room history; those links were titled already
+		err = c.write("<presence to='%s/%s'><x xmlns='http://jabber.org/protocol/muc'><history maxstanzas='0'/></x></presence>",
+			xmlEscape(room), xmlEscape(c.nick))
+		if err != nil {
+			return err
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	return nil
+}
+
+func (c *xmppClient) close() {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// sendGroupchat sends a message to a room, without IRC formatting
+func (c *xmppClient) sendGroupchat(room, text string) error {
+	return c.write("<message to='%s' type='groupchat'><body>%s</body></message>",
+		xmlEscape(room), xmlEscape(ircfmt.Strip(text)))
+}
+
+// xmppLoop stays connected to the XMPP server, reconnecting with backoff,
+// while we're active (i.e., not an HA standby)
+func (irc *Bot) xmppLoop(c *xmppClient) {
+	for irc.ctx.Err() == nil {
+		if !irc.isActive() {
+			select {
+			case <-time.After(xmppStandbyCheck):
+			case <-irc.ctx.Done():
+			}
+			continue
+		}
+		delay := c.backoff.attempt()
+		err := c.connect()
+		if err == nil {
+			c.backoff.connect()
+			irc.logInfo("connected to XMPP", "server", c.server, "rooms", strings.Join(c.rooms, ","))
+			err = irc.xmppReadLoop(c)
+			c.backoff.disconnect()
+		}
+		c.close()
+		if irc.ctx.Err() != nil {
+			return
+		} else if !irc.isActive() {
+			irc.logInfo("disconnected from XMPP while we're a standby", "server", c.server)
+			continue
+		}
+		irc.logError("XMPP connection failed, reconnecting", "server", c.server, "error", err, "delay", delay.String())
+		select {
+		case <-time.After(delay):
+		case <-irc.ctx.Done():
+		}
+	}
+}
+
+func (irc *Bot) xmppReadLoop(c *xmppClient) error {
+	done := make(chan empty)
+	defer close(done)
+	go func() {
+		keepAlive := time.NewTicker(xmppKeepAlive)
+		defer keepAlive.Stop()
+		standbyCheck := time.NewTicker(xmppStandbyCheck)
+		defer standbyCheck.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-irc.ctx.Done():
+				c.write("</stream:stream>")
+				c.close()
+				return
+			case <-keepAlive.C:
+				c.write(" ")
+			case <-standbyCheck.C:
+				if !irc.isActive() {
+					c.write("</stream:stream>")
+					c.close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		start, err := c.next()
+		if err != nil {
+			if err == io.EOF {
+				err = errXMPPStreamClosed
+			}
+			return err
+		}
+		switch start.Name.Local {
+		case "message":
+			var message xmppMessage
+			if err := c.dec.DecodeElement(&message, &start); err != nil {
+				return err
+			}
+			irc.handleXMPPMessage(c, message)
+		case "iq":
+			var iq xmppIQ
+			if err := c.dec.DecodeElement(&iq, &start); err != nil {
+				return err
+			}
+			if iq.Type == "get" && iq.Ping != nil {
+				c.write("<iq type='result' id='%s' to='%s'/>", xmlEscape(iq.ID), xmlEscape(iq.From))
+			} else if iq.Type == "get" || iq.Type == "set" {
+				c.write("<iq type='error' id='%s' to='%s'><error type='cancel'><service-unavailable xmlns='urn:ietf:params:xml:ns:xmpp-stanzas'/></error></iq>",
+					xmlEscape(iq.ID), xmlEscape(iq.From))
+			}
+		default:
+			if err := c.dec.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// xmppSender represents a room occupant as the sender of an IRC message,
+// for the ignore list and opt-outs: the nick is their nick in the room,
+// and the account is their occupant JID (room@server/nick), which entries
+// like chat@conference.example.com/alice or *@conference.example.com/*
+// match, like the accounts of relayed users
+func xmppSender(room, nick, body string) ircmsg.Message {
+	e := ircmsg.MakeMessage(nil, nick+"!xmpp@"+room, "PRIVMSG", room, body)
+	e.SetTag("account", room+"/"+nick)
+	return e
+}
+
+func (irc *Bot) handleXMPPMessage(c *xmppClient, message xmppMessage) {
+	room, nick, _ := strings.Cut(message.From, "/")
+	if message.Type != "groupchat" || message.Body == "" || message.Delay != nil || nick == "" || nick == c.nick {
+		return
+	}
+	if !irc.isActive() || !irc.autoTitles(room) {
+		return
+	}
+	sender := xmppSender(room, nick, message.Body)
+	if irc.ignoreSender(sender) || irc.output.isRelayed(message.Body) || irc.optedOut(sender) {
+		return
+	}
+	// spoilers are hidden with IRC formatting, which XMPP clients won't render
+	if urls, _ := irc.extractLinks(room, message.ID, "", message.Body); urls != nil {
+		go irc.titleAll(titleRequest{target: room, nick: nick, xmpp: c, message: message.Body}, urls)
+	}
+}
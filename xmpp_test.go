@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Shivaram Lingamneni
+// Released under the MIT License
+
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestXMPPSenderIgnore(t *testing.T) {
+	irc := testRelayBot(t)
+	irc.ignoreNicksRe = regexp.MustCompile(`(?i)bot$`)
+	for _, entry := range []string{"alice", "chat@conference.example.com/bob", "*@conference.example.net/*"} {
+		if _, err := irc.state.setIgnore(entry, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := irc.state.setOptOut("chat@conference.example.com/carol", true); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		room, nick string
+		ignored    bool
+		optedOut   bool
+	}{
+		{"chat@conference.example.com", "alice", true, false},
+		{"chat@conference.example.com", "bob", true, false},
+		{"other@conference.example.com", "bob", false, false},
+		{"chat@conference.example.net", "dave", true, false},
+		{"chat@conference.example.com", "linkbot", true, false},
+		{"chat@conference.example.com", "carol", false, true},
+		{"chat@conference.example.com", "erin", false, false},
+	}
+	for _, c := range cases {
+		e := xmppSender(c.room, c.nick, "https://example.com")
+		if ignored := irc.ignoreSender(e); ignored != c.ignored {
+			t.Errorf("ignoreSender(%s/%s) = %t, expected %t", c.room, c.nick, ignored, c.ignored)
+		}
+		if optedOut := irc.optedOut(e); optedOut != c.optedOut {
+			t.Errorf("optedOut(%s/%s) = %t, expected %t", c.room, c.nick, optedOut, c.optedOut)
+		}
+	}
+}